@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"embed"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -17,6 +18,10 @@ import (
 	"github.com/Ewan-Greer09/finance-app/api/config"
 	"github.com/Ewan-Greer09/finance-app/api/database"
 	"github.com/Ewan-Greer09/finance-app/api/handlers"
+	"github.com/Ewan-Greer09/finance-app/api/jobs"
+	"github.com/Ewan-Greer09/finance-app/api/prices"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+	"github.com/Ewan-Greer09/finance-app/api/recurring"
 )
 
 //go:embed web/*
@@ -29,37 +34,199 @@ type API struct {
 	*slog.Logger
 	config.Config
 	*Handler
-	ExpenseHandler *handlers.ExpenseHandler
-	IncomeHandler  *handlers.IncomeHandler
-	AdminHandler   *handlers.AdminHandler
+	ExpenseHandler      *handlers.ExpenseHandler
+	IncomeHandler       *handlers.IncomeHandler
+	AdminHandler        *handlers.AdminHandler
+	CategoryHandler     *handlers.CategoryHandler
+	AccountHandler      *handlers.AccountHandler
+	RecurringHandler    *handlers.RecurringHandler
+	BudgetHandler       *handlers.BudgetHandler
+	GoalHandler         *handlers.GoalHandler
+	LedgerHandler       *handlers.LedgerHandler
+	TrashHandler        *handlers.TrashHandler
+	SearchHandler       *handlers.SearchHandler
+	RuleHandler         *handlers.RuleHandler
+	ReconcileHandler    *handlers.ReconcileHandler
+	LoanHandler         *handlers.LoanHandler
+	InvestmentHandler   *handlers.InvestmentHandler
+	NetWorthHandler     *handlers.NetWorthHandler
+	BillHandler         *handlers.BillHandler
+	NotificationHandler *handlers.NotificationHandler
+	Scheduler           *jobs.Scheduler
 }
 
 func NewAPI() *API {
 	cfg := config.LoadConfig()
 	log := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(cfg.API.LogLevel)}))
+	// one connection pool for every handler and the background jobs, so writes
+	// queue on it rather than failing on a lock another pool holds
+	db := database.NewDatabase(cfg)
 
 	api := &API{
 		Name: cfg.API.ServiceName,
 		Server: &http.Server{
 			Addr: cfg.API.Addr,
 		},
-		Logger:         log,
-		Config:         cfg,
-		Handler:        NewHandler(log, cfg),
-		ExpenseHandler: handlers.NewExpenseHandler(log, database.NewDatabase(cfg), webFS),
-		IncomeHandler:  handlers.NewIncomeHandler(log, database.NewDatabase(cfg), webFS),
-		AdminHandler:   handlers.NewAdminHandler(log, database.NewDatabase(cfg), webFS),
+		Logger:              log,
+		Config:              cfg,
+		Handler:             NewHandler(log, db, cfg),
+		ExpenseHandler:      handlers.NewExpenseHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		IncomeHandler:       handlers.NewIncomeHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		AdminHandler:        handlers.NewAdminHandler(log, db, webFS),
+		CategoryHandler:     handlers.NewCategoryHandler(log, db, webFS),
+		AccountHandler:      handlers.NewAccountHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		RecurringHandler:    handlers.NewRecurringHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		BudgetHandler:       handlers.NewBudgetHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		GoalHandler:         handlers.NewGoalHandler(log, db, webFS),
+		LedgerHandler:       handlers.NewLedgerHandler(log, db, webFS),
+		TrashHandler:        handlers.NewTrashHandler(log, db, webFS, trashRetention(cfg)),
+		SearchHandler:       handlers.NewSearchHandler(log, db, webFS),
+		RuleHandler:         handlers.NewRuleHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		ReconcileHandler:    handlers.NewReconcileHandler(log, db, webFS),
+		LoanHandler:         handlers.NewLoanHandler(log, db, webFS),
+		InvestmentHandler:   handlers.NewInvestmentHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		NetWorthHandler:     handlers.NewNetWorthHandler(log, db, webFS, cfg.API.DefaultCurrency),
+		BillHandler:         handlers.NewBillHandler(log, db, webFS, cfg.API.DefaultCurrency, billReminderDays(cfg)),
+		NotificationHandler: handlers.NewNotificationHandler(log, db, webFS),
+		Scheduler:           jobs.NewScheduler(log, schedulerInterval(cfg)),
 	}
 	api.Server.Handler = api.registerRoutes()
+	api.loadRates()
+	api.loadPrices()
+	api.addJobs()
 	return api
 }
 
+func schedulerInterval(cfg config.Config) time.Duration {
+	if cfg.API.SchedulerInterval <= 0 {
+		return time.Hour
+	}
+	return time.Duration(cfg.API.SchedulerInterval) * time.Second
+}
+
+func trashRetention(cfg config.Config) time.Duration {
+	if cfg.API.TrashRetentionDays <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(cfg.API.TrashRetentionDays) * 24 * time.Hour
+}
+
+// a negative setting falls back to three days, zero reminds on the day a bill is due
+func billReminderDays(cfg config.Config) int {
+	if cfg.API.BillReminderDays < 0 {
+		return 3
+	}
+	return cfg.API.BillReminderDays
+}
+
+// registers the background jobs, they start with the server in Run
+func (a *API) addJobs() {
+	a.Scheduler.Add(jobs.Job{
+		Name: "recurring",
+		Run: func(ctx context.Context) error {
+			posted, err := recurring.PostDue(ctx, a.Handler.Database, handlers.Today())
+			if posted > 0 {
+				a.Info("Posted recurring transactions", "count", posted)
+			}
+			return err
+		},
+	})
+	a.Scheduler.Add(jobs.Job{
+		Name: "journal",
+		Run: func(ctx context.Context) error {
+			unbalanced, err := a.Handler.Database.GetUnbalancedEntries()
+			if err != nil {
+				return err
+			}
+			if len(unbalanced) > 0 {
+				return fmt.Errorf("%d journal entries don't balance, the first is %d", len(unbalanced), unbalanced[0].ID)
+			}
+			return nil
+		},
+	})
+	a.Scheduler.Add(jobs.Job{
+		Name: "trash",
+		Run: func(ctx context.Context) error {
+			purged, err := a.Handler.Database.PurgeDeleted(time.Now().Add(-trashRetention(a.Config)))
+			if purged > 0 {
+				a.Info("Purged deleted transactions", "count", purged)
+			}
+			return err
+		},
+	})
+	// runs on every tick but keeps one snapshot a day, the last one taken
+	a.Scheduler.Add(jobs.Job{
+		Name: "networth",
+		Run: func(ctx context.Context) error {
+			taken, err := a.Handler.Database.SnapshotNetWorth(a.Config.API.DefaultCurrency, handlers.Today())
+			a.Debug("Recorded net worth snapshots", "count", taken)
+			return err
+		},
+	})
+	a.Scheduler.Add(jobs.Job{
+		Name: "bills",
+		Run: func(ctx context.Context) error {
+			raised, err := a.Handler.Database.RemindBills(handlers.Today().AddDate(0, 0, billReminderDays(a.Config)))
+			if raised > 0 {
+				a.Info("Raised bill reminders", "count", raised)
+			}
+			return err
+		},
+	})
+}
+
+// imports the configured exchange rates file, if there is one
+func (a *API) loadRates() {
+	if a.Config.API.RatesFile == "" {
+		return
+	}
+
+	parsed, err := rates.LoadFile(a.Config.API.RatesFile)
+	if err != nil {
+		a.Error("Failed to load rates file", "file", a.Config.API.RatesFile, "error", err)
+		return
+	}
+
+	err = a.Handler.Database.AddRates(parsed)
+	if err != nil {
+		a.Error("Failed to import rates", "file", a.Config.API.RatesFile, "error", err)
+		return
+	}
+
+	a.Info("Imported exchange rates", "file", a.Config.API.RatesFile, "count", len(parsed))
+}
+
+// imports the configured security prices file, if there is one. Securities
+// have to exist already, so on a fresh database nothing is stored until the
+// next start.
+func (a *API) loadPrices() {
+	if a.Config.API.PricesFile == "" {
+		return
+	}
+
+	quotes, err := prices.LoadFile(a.Config.API.PricesFile)
+	if err != nil {
+		a.Error("Failed to load prices file", "file", a.Config.API.PricesFile, "error", err)
+		return
+	}
+
+	count, err := a.Handler.Database.AddPrices(quotes)
+	if err != nil {
+		a.Error("Failed to import prices", "file", a.Config.API.PricesFile, "error", err)
+		return
+	}
+
+	a.Info("Imported security prices", "file", a.Config.API.PricesFile, "count", count)
+}
+
 func (a *API) Run() error {
 	a.Info("Starting API server", "name", a.Name, "port", a.Server.Addr)
 
 	doneCh := make(chan os.Signal, 1)
 	signal.Notify(doneCh, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
 
+	a.Scheduler.Start()
+
 	go func() {
 		if err := a.Server.ListenAndServe(); err != nil {
 			if err == http.ErrServerClosed {
@@ -82,6 +249,9 @@ func (a *API) Run() error {
 		return err
 	}
 
+	// stop background jobs before the database they use is closed
+	a.Scheduler.Stop()
+
 	err := a.Handler.Database.Close()
 	if err != nil {
 		a.Error("Error while closing DB connection", "error", err)
@@ -98,7 +268,7 @@ func (a *API) registerRoutes() http.HandlerFunc {
 
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
@@ -128,7 +298,25 @@ func (a *API) registerRoutes() http.HandlerFunc {
 			r.Route("/expense", a.ExpenseHandler.Routes)
 			r.Route("/income", a.IncomeHandler.Routes)
 			r.Route("/admin", a.AdminHandler.Routes)
-			r.Get("/graph", a.HandleGetExpensesAndIncomesGraph)
+			r.Route("/category", a.CategoryHandler.Routes)
+			r.Route("/account", a.AccountHandler.Routes)
+			r.Route("/recurring", a.RecurringHandler.Routes)
+			r.Route("/budget", a.BudgetHandler.Routes)
+			r.Route("/goal", a.GoalHandler.Routes)
+			r.Route("/ledger", a.LedgerHandler.Routes)
+			r.Route("/trash", a.TrashHandler.Routes)
+			r.Route("/search", a.SearchHandler.Routes)
+			r.Route("/rule", a.RuleHandler.Routes)
+			r.Route("/reconcile", a.ReconcileHandler.Routes)
+			r.Route("/loan", a.LoanHandler.Routes)
+			r.Route("/investment", a.InvestmentHandler.Routes)
+			r.Route("/networth", a.NetWorthHandler.Routes)
+			r.Route("/bill", a.BillHandler.Routes)
+			r.Route("/notification", a.NotificationHandler.Routes)
+			r.With(
+				handlers.RequireUser(a.Handler.Database),
+				handlers.RequireLedger(a.Handler.Database),
+			).Get("/graph", a.HandleGetExpensesAndIncomesGraph)
 		})
 	})
 
@@ -15,6 +15,18 @@ type Config struct {
 		LogFile      string `mapstructure:"log_file"`
 		Timeout      int    `mapstructure:"timeout"`
 		DatabaseName string `mapstructure:"database_name"`
+		// currency used for amounts entered without one, and for legacy rows
+		DefaultCurrency string `mapstructure:"default_currency"`
+		// optional ECB-style XML or CSV exchange rates file imported on startup
+		RatesFile string `mapstructure:"rates_file"`
+		// optional CSV of security prices imported on startup
+		PricesFile string `mapstructure:"prices_file"`
+		// seconds between runs of background jobs such as posting recurring transactions
+		SchedulerInterval int `mapstructure:"scheduler_interval"`
+		// days deleted transactions stay in the trash before they are purged for good
+		TrashRetentionDays int `mapstructure:"trash_retention_days"`
+		// days before a bill is due that a reminder notification is raised
+		BillReminderDays int `mapstructure:"bill_reminder_days"`
 	} `mapstructure:"api"`
 }
 
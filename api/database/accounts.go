@@ -0,0 +1,385 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var ErrAccountInUse = errors.New("account has transactions, transfers, trades, recurring items, bills, goals, loans or reconciliations")
+
+// accountColumns are the columns of records that belong to a ledger and point
+// at one of its accounts
+var accountColumns = []struct {
+	table, column string
+}{
+	{"expenses", "account_id"},
+	{"incomes", "account_id"},
+	{"transfers", "from_account_id"},
+	{"transfers", "to_account_id"},
+	{"recurrings", "account_id"},
+	{"bills", "account_id"},
+	{"goals", "account_id"},
+	{"loans", "account_id"},
+	{"trades", "account_id"},
+	{"rules", "account_id"},
+	{"reconciliations", "account_id"},
+}
+
+// seedAccounts gives accounts from before they belonged to a ledger to the
+// ledger whose transactions use them, or the oldest ledger, and transfers to
+// the ledger of the account they leave. Every ledger gets an account to fall
+// back on, and transactions without an account are pointed at their ledger's
+// oldest one. It runs after seedLedgers.
+func seedAccounts(db *gorm.DB, currency string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE accounts SET ledger_id = COALESCE(
+			(SELECT MIN(ledger_id) FROM expenses WHERE expenses.account_id = accounts.id AND expenses.ledger_id > 0),
+			(SELECT MIN(ledger_id) FROM incomes WHERE incomes.account_id = accounts.id AND incomes.ledger_id > 0),
+			(SELECT MIN(id) FROM ledgers WHERE deleted_at IS NULL),
+			0
+		) WHERE ledger_id IS NULL OR ledger_id = 0`).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(
+			"UPDATE transfers SET ledger_id = (SELECT ledger_id FROM accounts WHERE accounts.id = transfers.from_account_id) WHERE ledger_id IS NULL OR ledger_id = 0",
+		).Error
+		if err != nil {
+			return err
+		}
+
+		err = splitSharedAccounts(tx)
+		if err != nil {
+			return err
+		}
+
+		var ledgerIDs []uint
+		err = tx.Model(models.Ledger{}).
+			Where("id NOT IN (?)", tx.Model(models.Account{}).Select("ledger_id")).
+			Pluck("id", &ledgerIDs).Error
+		if err != nil {
+			return err
+		}
+		for _, ledgerID := range ledgerIDs {
+			err = addMainAccount(tx, ledgerID, currency)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, table := range []string{"expenses", "incomes"} {
+			err = tx.Exec(
+				"UPDATE ? SET account_id = (SELECT MIN(id) FROM accounts WHERE accounts.ledger_id = ?.ledger_id AND accounts.deleted_at IS NULL) WHERE account_id IS NULL OR account_id = 0",
+				clause.Table{Name: table}, clause.Table{Name: table},
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// splitSharedAccounts gives a ledger still using an account another ledger
+// owns, from when accounts were shared between ledgers, its own copy of the
+// account with no opening balance, and moves its records onto the copy.
+func splitSharedAccounts(tx *gorm.DB) error {
+	// the copy made for each ledger and account it was using
+	type shared struct{ LedgerID, AccountID uint }
+	copies := map[shared]uint{}
+
+	for _, col := range accountColumns {
+		var pairs []shared
+		err := tx.Raw(
+			"SELECT DISTINCT t.ledger_id, t.? AS account_id FROM ? t JOIN accounts ON accounts.id = t.? WHERE t.ledger_id > 0 AND accounts.ledger_id != t.ledger_id",
+			clause.Column{Name: col.column}, clause.Table{Name: col.table}, clause.Column{Name: col.column},
+		).Scan(&pairs).Error
+		if err != nil {
+			return err
+		}
+
+		for _, pair := range pairs {
+			id, ok := copies[pair]
+			if !ok {
+				var account models.Account
+				err = tx.Unscoped().Model(models.Account{}).First(&account, pair.AccountID).Error
+				if err != nil {
+					return err
+				}
+				copied := models.Account{
+					LedgerID:       pair.LedgerID,
+					Name:           account.Name,
+					Type:           account.Type,
+					OpeningBalance: money.New(0, account.Currency()),
+				}
+				err = tx.Create(&copied).Error
+				if err != nil {
+					return err
+				}
+				id = copied.ID
+				copies[pair] = id
+			}
+
+			var moved []uint
+			err = tx.Table(col.table).Where("ledger_id = ? AND ? = ?", pair.LedgerID, clause.Column{Name: col.column}, pair.AccountID).Pluck("id", &moved).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Exec("UPDATE ? SET ? = ? WHERE id IN ?", clause.Table{Name: col.table}, clause.Column{Name: col.column}, id, moved).Error
+			if err != nil {
+				return err
+			}
+
+			// the journal's account postings follow the records
+			for _, src := range journalSources {
+				if src.table != col.table {
+					continue
+				}
+				for _, recordID := range moved {
+					err = postJournal(tx, src.source, recordID)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// addMainAccount opens the account a ledger's transactions go to until it has
+// others
+func addMainAccount(tx *gorm.DB, ledgerID uint, currency string) error {
+	return tx.Create(&models.Account{
+		LedgerID:       ledgerID,
+		Name:           "Main Account",
+		Type:           models.AccountTypeCurrent,
+		OpeningBalance: money.New(0, currency),
+	}).Error
+}
+
+// Gets the ledger's accounts by name
+func (d *SQLite) GetAccounts(ledgerID uint) ([]models.Account, error) {
+	var accounts []models.Account
+	tx := d.DB.Model(models.Account{}).Where("ledger_id = ?", ledgerID).Order("name").Find(&accounts)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return accounts, nil
+}
+
+func (d *SQLite) GetAccount(ledgerID uint, id int) (models.Account, error) {
+	err := inLedger(d.DB, &models.Account{}, ledgerID, id)
+	if err != nil {
+		return models.Account{}, err
+	}
+
+	var account models.Account
+	tx := d.DB.Model(models.Account{}).First(&account, id)
+	if tx.Error != nil {
+		return models.Account{}, tx.Error
+	}
+	return account, nil
+}
+
+// Gets the account the ledger's transactions go to when none is picked, its
+// oldest one
+func (d *SQLite) GetDefaultAccount(ledgerID uint) (models.Account, error) {
+	var account models.Account
+	tx := d.DB.Model(models.Account{}).Where("ledger_id = ?", ledgerID).Order("id").First(&account)
+	if tx.Error != nil {
+		return models.Account{}, tx.Error
+	}
+	return account, nil
+}
+
+// Adds an Account, posting its opening balance to the journal
+func (d *SQLite) AddAccount(account models.Account) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(models.Account{}).Create(&account).Error
+		if err != nil {
+			return err
+		}
+		return postJournal(tx, models.JournalOpening, account.ID)
+	})
+}
+
+// Deletes an account, refusing with ErrAccountInUse while anything, including
+// deleted transactions that could be restored, recurring items still to post
+// and bills still due, points at it
+func (d *SQLite) DeleteAccount(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Account{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+
+		var expenses, incomes, transfers, trades, recurrings, bills, goals, loans, reconciliations int64
+		err = tx.Unscoped().Model(models.Expense{}).Where("account_id = ?", id).Count(&expenses).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(models.Income{}).Where("account_id = ?", id).Count(&incomes).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(models.Transfer{}).Where("from_account_id = ? OR to_account_id = ?", id, id).Count(&transfers).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(models.Trade{}).Where("account_id = ?", id).Count(&trades).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Recurring{}).Where("account_id = ? AND next_on IS NOT NULL", id).Count(&recurrings).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Bill{}).Where("account_id = ? AND due_on IS NOT NULL", id).Count(&bills).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Goal{}).Where("account_id = ?", id).Count(&goals).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Loan{}).Where("account_id = ? OR liability_account_id = ?", id, id).Count(&loans).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Reconciliation{}).Where("account_id = ?", id).Count(&reconciliations).Error
+		if err != nil {
+			return err
+		}
+		if expenses+incomes+transfers+trades+recurrings+bills+goals+loans+reconciliations > 0 {
+			return ErrAccountInUse
+		}
+
+		err = tx.Model(models.Account{}).Delete(&models.Account{}, id).Error
+		if err != nil {
+			return err
+		}
+		return postJournal(tx, models.JournalOpening, uint(id))
+	})
+}
+
+func (d *SQLite) AddTransfer(transfer models.Transfer) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(models.Transfer{}).Omit("FromAccount", "ToAccount").Create(&transfer).Error
+		if err != nil {
+			return err
+		}
+		return postJournal(tx, models.JournalTransfer, transfer.ID)
+	})
+}
+
+// Gets a page of the ledger's Transfers, newest first, and the cursor for the
+// next page, which is empty on the last one
+func (d *SQLite) GetTransfers(ledgerID uint, page Page) ([]models.Transfer, string, error) {
+	var filter TransactionFilter
+	tx, err := paginate(d.DB.Model(models.Transfer{}).Where("ledger_id = ?", ledgerID), filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var transfers []models.Transfer
+	tx = tx.Preload("FromAccount").Preload("ToAccount").Find(&transfers)
+	if tx.Error != nil {
+		return nil, "", tx.Error
+	}
+
+	next := ""
+	if len(transfers) > page.size() {
+		transfers = transfers[:page.size()]
+		last := transfers[len(transfers)-1]
+		next = encodeCursor(filter, filter.cursorKey(last.OccurredOn, last.Amount, last.Note), last.ID)
+	}
+	return transfers, next, nil
+}
+
+// Deletes a Transfer, refusing with ErrReconciled while either side is reconciled
+func (d *SQLite) DeleteTransfer(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Transfer{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+
+		var count int64
+		err = tx.Model(models.Transfer{}).
+			Where("id = ? AND (from_status = ? OR to_status = ?)", id, models.StatusReconciled, models.StatusReconciled).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrReconciled
+		}
+
+		err = tx.Model(models.Transfer{}).Delete(&models.Transfer{}, id).Error
+		if err != nil {
+			return err
+		}
+		return postJournal(tx, models.JournalTransfer, uint(id))
+	})
+}
+
+// a day's total for one account in one currency, signed by its effect on the balance
+type balanceRow struct {
+	AccountID  uint
+	Currency   string
+	OccurredOn time.Time
+	Total      int64
+}
+
+// Gets the balance of each of the ledger's accounts at the end of the given
+// day, from the account postings in the ledger's journal: its opening
+// balance, incomes and transfers in, less expenses and transfers out. Amounts in another
+// currency are converted at the rate on the day they occurred.
+func (d *SQLite) GetAccountBalances(ledgerID uint, on time.Time) ([]models.AccountBalance, error) {
+	accounts, err := d.GetAccounts(ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []balanceRow
+	err = d.DB.Model(models.Posting{}).
+		Joins("JOIN journal_entries ON journal_entries.id = postings.journal_entry_id").
+		Select("postings.account_id, postings.amount_currency AS currency, journal_entries.occurred_on, SUM(postings.amount_minor) AS total").
+		Where("journal_entries.ledger_id = ? AND postings.type = ? AND journal_entries.occurred_on <= ?", ledgerID, models.PostingAccount, on.UTC()).
+		Group("1, 2, 3").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	byAccount := map[uint][]balanceRow{}
+	for _, row := range rows {
+		byAccount[row.AccountID] = append(byAccount[row.AccountID], row)
+	}
+
+	conv := rates.NewConverter(d)
+	balances := make([]models.AccountBalance, 0, len(accounts))
+	for _, account := range accounts {
+		balance := models.AccountBalance{Account: account, Balance: money.New(0, account.Currency())}
+		for _, row := range byAccount[account.ID] {
+			amount, err := conv.Convert(money.New(row.Total, row.Currency), account.Currency(), row.OccurredOn)
+			if err != nil {
+				balance.Incomplete = true
+				continue
+			}
+			balance.Balance, err = balance.Balance.Add(amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+		balances = append(balances, balance)
+	}
+	return balances, nil
+}
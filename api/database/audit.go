@@ -0,0 +1,174 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// Actor is who changes are recorded against in the audit log. The zero value
+// is the app itself, e.g. a background job.
+type Actor struct {
+	UserID    uint
+	Username  string
+	RequestID string
+}
+
+// systemActor is the name audit entries get when there is no user behind them
+const systemActor = "system"
+
+// AuditFilter narrows the audit log. Zero fields don't filter, so the zero
+// value matches everything.
+type AuditFilter struct {
+	Entity    string
+	EntityID  uint
+	Action    string
+	Actor     string
+	RequestID string
+	// inclusive range of days the change was made on
+	From time.Time
+	To   time.Time
+	// most entries to return, 0 means no limit
+	Limit int
+}
+
+// As returns a view of the database that records the changes it makes against
+// actor. It shares the connection, so it's cheap to make one per request.
+func (d *SQLite) As(actor Actor) Database {
+	return &SQLite{DB: d.DB, actor: actor, search: d.search, currency: d.currency}
+}
+
+// Gets the audit entries matching the filter, newest first
+func (d *SQLite) GetAuditEntries(filter AuditFilter) ([]models.AuditEntry, error) {
+	tx := d.DB.Model(models.AuditEntry{})
+	if filter.Entity != "" {
+		tx = tx.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		tx = tx.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.Actor != "" {
+		tx = tx.Where("actor = ?", filter.Actor)
+	}
+	if filter.RequestID != "" {
+		tx = tx.Where("request_id = ?", filter.RequestID)
+	}
+	if !filter.From.IsZero() {
+		tx = tx.Where("created_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		tx = tx.Where("created_at < ?", filter.To.UTC().AddDate(0, 0, 1))
+	}
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit)
+	}
+
+	var entries []models.AuditEntry
+	tx = tx.Order("created_at desc, id desc").Find(&entries)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return entries, nil
+}
+
+// entityOf is the audit entity for a pointer to an Expense, Income or User
+func entityOf(model interface{}) string {
+	switch model.(type) {
+	case *models.Expense:
+		return models.AuditExpense
+	case *models.Income:
+		return models.AuditIncome
+	case *models.Transfer:
+		return models.AuditTransfer
+	default:
+		return models.AuditUser
+	}
+}
+
+// snapshot is the JSON of the entity with id, deleted or not, along with the
+// associations an edit can change. It's empty when the row doesn't exist.
+func snapshot(tx *gorm.DB, entity string, id uint) (string, error) {
+	var row interface{}
+	q := tx.Unscoped()
+	switch entity {
+	case models.AuditExpense:
+		row = &models.Expense{}
+		q = q.Preload("Tags").Preload("Splits")
+	case models.AuditIncome:
+		row = &models.Income{}
+		q = q.Preload("Tags")
+	case models.AuditTransfer:
+		row = &models.Transfer{}
+	default:
+		row = &models.User{}
+	}
+
+	err := q.First(row, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if user, ok := row.(*models.User); ok {
+		user.Password = ""
+	}
+
+	b, err := json.Marshal(row)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// record adds an audit entry for a change to the entity with id, before is
+// its snapshot from ahead of the change. It must run in the same transaction
+// as the change so one is never kept without the other.
+func (d *SQLite) record(tx *gorm.DB, action, entity string, id uint, before string) error {
+	after := ""
+	if action != models.AuditDelete {
+		var err error
+		after, err = snapshot(tx, entity, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	actor := d.actor.Username
+	if actor == "" {
+		actor = systemActor
+	}
+	return tx.Create(&models.AuditEntry{
+		ActorID:   d.actor.UserID,
+		Actor:     actor,
+		RequestID: d.actor.RequestID,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  id,
+		Before:    before,
+		After:     after,
+	}).Error
+}
+
+// protectAuditLog adds triggers so audit entries can't be changed or removed,
+// even by hand.
+func protectAuditLog(db *gorm.DB) error {
+	for _, event := range []string{"UPDATE", "DELETE"} {
+		err := db.Exec(
+			"CREATE TRIGGER IF NOT EXISTS audit_entries_no_" + strings.ToLower(event) + " BEFORE " + event + " ON audit_entries " +
+				"BEGIN SELECT RAISE(ABORT, 'audit entries are immutable'); END",
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/recurring"
+)
+
+// ErrBillNotDue is returned when paying a bill whose schedule has finished,
+// or that was already paid for that due date
+var ErrBillNotDue = errors.New("bill has no payment due")
+
+// Gets the ledger's bills, soonest due first and finished ones last
+func (d *SQLite) GetBills(ledgerID uint) ([]models.Bill, error) {
+	var bills []models.Bill
+	tx := d.DB.Model(models.Bill{}).
+		Where("ledger_id = ?", ledgerID).
+		Preload("Category").Preload("Account").
+		Order("due_on IS NULL, due_on, payee").
+		Find(&bills)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return bills, nil
+}
+
+func (d *SQLite) GetBill(ledgerID uint, id int) (models.Bill, error) {
+	err := inLedger(d.DB, &models.Bill{}, ledgerID, id)
+	if err != nil {
+		return models.Bill{}, err
+	}
+
+	var bill models.Bill
+	tx := d.DB.Model(models.Bill{}).Preload("Category").Preload("Account").First(&bill, id)
+	if tx.Error != nil {
+		return models.Bill{}, tx.Error
+	}
+	return bill, nil
+}
+
+// Adds a bill, refusing with ErrInvalidBill when its schedule doesn't parse
+func (d *SQLite) AddBill(bill models.Bill) error {
+	err := bill.Validate()
+	if err != nil {
+		return err
+	}
+	_, err = recurring.ScheduleFor(bill.Recurrence())
+	if err != nil {
+		return fmt.Errorf("%w: %w", models.ErrInvalidBill, err)
+	}
+	return d.DB.Omit("Category", "Account").Create(&bill).Error
+}
+
+// Deletes a bill, dismissing any reminders raised for it
+func (d *SQLite) DeleteBill(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Bill{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Notification{}).
+			Where("bill_id = ? AND read_at IS NULL", id).
+			Update("read_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.Bill{}, id).Error
+	})
+}
+
+// Pays the bill's current due date with an expense of amount on the day on,
+// entered by userID, then moves the bill to next, nil when its schedule has
+// finished. Like a recurring expense it goes through the ledger's rules. The
+// reminder for the paid due date is dismissed.
+func (d *SQLite) PayBill(bill models.Bill, userID uint, amount money.Money, on time.Time, next *time.Time) error {
+	if bill.DueOn == nil {
+		return ErrBillNotDue
+	}
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		moved := tx.Model(models.Bill{}).
+			Where("id = ? AND due_on = ?", bill.ID, *bill.DueOn).
+			Update("due_on", next)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		if moved.RowsAffected == 0 {
+			return ErrBillNotDue
+		}
+
+		ruled, err := applyRules(tx, bill.LedgerID, models.CategoryKindExpense, ruleTarget{
+			Source:     bill.Payee,
+			Amount:     amount,
+			AccountID:  bill.AccountID,
+			CategoryID: bill.CategoryID,
+		})
+		if err != nil {
+			return err
+		}
+		tags, err := findOrCreateTags(tx, withTags(nil, ruled.Tags))
+		if err != nil {
+			return err
+		}
+
+		expense := models.Expense{
+			LedgerID:   bill.LedgerID,
+			UserID:     userID,
+			Amount:     amount,
+			Source:     ruled.Source,
+			Notes:      "Bill due " + bill.DueOn.Format("02 Jan 2006"),
+			OccurredOn: on,
+			CategoryID: ruled.CategoryID,
+			Tags:       tags,
+			AccountID:  bill.AccountID,
+		}
+		err = tx.Create(&expense).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+		err = d.record(tx, models.AuditCreate, models.AuditExpense, expense.ID, "")
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(models.Notification{}).
+			Where("bill_id = ? AND due_on = ? AND read_at IS NULL", bill.ID, *bill.DueOn).
+			Update("read_at", time.Now()).Error
+	})
+}
+
+// Raises a reminder for every bill due on or before until that hasn't had one
+// for that due date yet, overdue bills included. It returns how many were raised.
+func (d *SQLite) RemindBills(until time.Time) (int, error) {
+	var bills []models.Bill
+	err := d.DB.Model(models.Bill{}).Where("due_on <= ?", until).Find(&bills).Error
+	if err != nil {
+		return 0, err
+	}
+
+	raised := 0
+	for _, bill := range bills {
+		amount := bill.Amount.Format()
+		if bill.Estimated {
+			amount = "about " + amount
+		}
+		created := d.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Notification{
+			LedgerID: bill.LedgerID,
+			Message:  fmt.Sprintf("%s is due %s: %s", bill.Payee, bill.DueOn.Format("02 Jan 2006"), amount),
+			BillID:   bill.ID,
+			DueOn:    bill.DueOn,
+		})
+		if created.Error != nil {
+			return raised, created.Error
+		}
+		raised += int(created.RowsAffected)
+	}
+	return raised, nil
+}
+
+// Gets the ledger's notifications that haven't been dismissed, newest first
+func (d *SQLite) GetNotifications(ledgerID uint) ([]models.Notification, error) {
+	var notifications []models.Notification
+	tx := d.DB.Model(models.Notification{}).
+		Where("ledger_id = ? AND read_at IS NULL", ledgerID).
+		Order("created_at DESC").
+		Find(&notifications)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return notifications, nil
+}
+
+// Dismisses a notification for everyone in the ledger
+func (d *SQLite) ReadNotification(ledgerID uint, id int) error {
+	err := inLedger(d.DB, &models.Notification{}, ledgerID, id)
+	if err != nil {
+		return err
+	}
+	return d.DB.Model(models.Notification{}).Where("id = ?", id).Update("read_at", time.Now()).Error
+}
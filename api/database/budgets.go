@@ -0,0 +1,254 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var ErrBudgetExists = errors.New("category already has a budget for that period")
+
+// spendRow is the spending in one category and currency on one day
+type spendRow struct {
+	CategoryID uint
+	Currency   string
+	OccurredOn time.Time
+	Total      int64
+}
+
+// spendingByCategory sums a ledger's expenses from from up to but not including
+// to. Split expenses are counted per line under each line's category.
+func (d *SQLite) spendingByCategory(ledgerID uint, from, to time.Time) ([]spendRow, error) {
+	var whole, splits []spendRow
+	err := d.DB.Model(models.Expense{}).
+		Select("category_id, amount_currency AS currency, occurred_on, SUM(amount_minor) AS total").
+		Where("ledger_id = ? AND occurred_on >= ? AND occurred_on < ?", ledgerID, from.UTC(), to.UTC()).
+		Where("NOT EXISTS (SELECT 1 FROM expense_splits WHERE expense_splits.expense_id = expenses.id AND expense_splits.deleted_at IS NULL)").
+		Group("1, 2, 3").
+		Scan(&whole).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.DB.Model(models.ExpenseSplit{}).
+		Select("expense_splits.category_id, expense_splits.amount_currency AS currency, expenses.occurred_on, SUM(expense_splits.amount_minor) AS total").
+		Joins("JOIN expenses ON expenses.id = expense_splits.expense_id AND expenses.deleted_at IS NULL").
+		Where("expenses.ledger_id = ? AND expenses.occurred_on >= ? AND expenses.occurred_on < ?", ledgerID, from.UTC(), to.UTC()).
+		Group("1, 2, 3").
+		Scan(&splits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return append(whole, splits...), nil
+}
+
+// periodStart is the first day of the budget period containing t, weeks start on Monday
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC().Truncate(24 * time.Hour)
+	switch period {
+	case models.BudgetPeriodWeekly:
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case models.BudgetPeriodYearly:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// nextPeriod is the first day of the period after the one starting on start
+func nextPeriod(period string, start time.Time) time.Time {
+	switch period {
+	case models.BudgetPeriodWeekly:
+		return start.AddDate(0, 0, 7)
+	case models.BudgetPeriodYearly:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func (d *SQLite) GetBudgets(ledgerID uint) ([]models.Budget, error) {
+	var budgets []models.Budget
+	tx := d.DB.Model(models.Budget{}).Where("ledger_id = ?", ledgerID).Preload("Category").Order("period, category_id").Find(&budgets)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return budgets, nil
+}
+
+func (d *SQLite) GetBudget(ledgerID uint, id int) (models.Budget, error) {
+	err := inLedger(d.DB, &models.Budget{}, ledgerID, id)
+	if err != nil {
+		return models.Budget{}, err
+	}
+
+	var budget models.Budget
+	tx := d.DB.Model(models.Budget{}).Preload("Category").First(&budget, id)
+	if tx.Error != nil {
+		return models.Budget{}, tx.Error
+	}
+	return budget, nil
+}
+
+func (d *SQLite) AddBudget(budget models.Budget) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(models.Budget{}).
+			Where("ledger_id = ? AND category_id = ? AND period = ?", budget.LedgerID, budget.CategoryID, budget.Period).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrBudgetExists
+		}
+
+		return tx.Omit("Category").Create(&budget).Error
+	})
+}
+
+// Updates the amount and rollover of a budget in budget.LedgerID, its category
+// and period are fixed
+func (d *SQLite) UpdateBudget(budget models.Budget) error {
+	err := inLedger(d.DB, &models.Budget{}, budget.LedgerID, int(budget.ID))
+	if err != nil {
+		return err
+	}
+
+	tx := d.DB.Model(&models.Budget{}).
+		Where("id = ?", budget.ID).
+		Updates(map[string]interface{}{
+			"amount_minor":    budget.Amount.Minor,
+			"amount_currency": budget.Amount.Currency,
+			"rollover":        budget.Rollover,
+		})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Budgets are removed for good so the category and period can be budgeted again
+func (d *SQLite) DeleteBudget(ledgerID uint, id int) error {
+	err := inLedger(d.DB, &models.Budget{}, ledgerID, id)
+	if err != nil {
+		return err
+	}
+
+	tx := d.DB.Unscoped().Delete(&models.Budget{}, id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// GetBudgetProgress works out the period containing on for every budget in the
+// ledger, counting the ledger's expenses in the budget's category and its
+// subcategories and any rollover from earlier periods since the budget started.
+func (d *SQLite) GetBudgetProgress(ledgerID uint, on time.Time) ([]models.BudgetProgress, error) {
+	budgets, err := d.GetBudgets(ledgerID)
+	if err != nil || len(budgets) == 0 {
+		return nil, err
+	}
+
+	categories, err := d.GetCategories(models.CategoryKindExpense)
+	if err != nil {
+		return nil, err
+	}
+	children := map[uint][]uint{}
+	for _, category := range categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+
+	// one query covering the earliest period any budget needs
+	from, to := on, on
+	for _, budget := range budgets {
+		first := firstPeriod(budget, on)
+		if first.Before(from) {
+			from = first
+		}
+		if end := nextPeriod(budget.Period, periodStart(budget.Period, on)); end.After(to) {
+			to = end
+		}
+	}
+	rows, err := d.spendingByCategory(ledgerID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	byCategory := map[uint][]spendRow{}
+	for _, row := range rows {
+		byCategory[row.CategoryID] = append(byCategory[row.CategoryID], row)
+	}
+
+	conv := rates.NewConverter(d)
+	progress := make([]models.BudgetProgress, 0, len(budgets))
+	for _, budget := range budgets {
+		currency := budget.Amount.Currency
+		current := periodStart(budget.Period, on)
+		p := models.BudgetProgress{
+			Budget:     budget,
+			From:       current,
+			To:         nextPeriod(budget.Period, current).AddDate(0, 0, -1),
+			RolledOver: money.New(0, currency),
+		}
+
+		// spending per period, keyed by the period's first day
+		spent := map[time.Time]money.Money{}
+		for _, id := range withDescendants(budget.CategoryID, children) {
+			for _, row := range byCategory[id] {
+				amount, err := conv.Convert(money.New(row.Total, row.Currency), currency, row.OccurredOn)
+				if err != nil {
+					p.Incomplete = true
+					continue
+				}
+				key := periodStart(budget.Period, row.OccurredOn)
+				spent[key], err = money.Sum(currency, spent[key], amount)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for start := firstPeriod(budget, on); start.Before(current); start = nextPeriod(budget.Period, start) {
+			left := budget.Amount.Minor + p.RolledOver.Minor - spent[start].Minor
+			if budget.Rollover == models.RolloverUnspent && left < 0 {
+				left = 0
+			}
+			p.RolledOver = money.New(left, currency)
+		}
+
+		p.Available, _ = budget.Amount.Add(p.RolledOver)
+		p.Spent, _ = money.Sum(currency, spent[current])
+		p.Remaining, _ = p.Available.Sub(p.Spent)
+		progress = append(progress, p)
+	}
+	return progress, nil
+}
+
+// firstPeriod is where rollover starts counting from, budgets without rollover
+// only look at the current period
+func firstPeriod(budget models.Budget, on time.Time) time.Time {
+	current := periodStart(budget.Period, on)
+	if budget.Rollover == models.RolloverNone || budget.Rollover == "" {
+		return current
+	}
+	first := periodStart(budget.Period, budget.StartOn)
+	if first.After(current) {
+		return current
+	}
+	return first
+}
+
+func withDescendants(id uint, children map[uint][]uint) []uint {
+	ids := []uint{id}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// Uncategorised is the category every transaction falls back to, one exists
+// for each kind.
+const Uncategorised = "Uncategorised"
+
+var ErrCategoryInUse = errors.New("category has subcategories, transactions or budgets")
+
+// default tree created on a fresh database, parents first
+var defaultCategories = map[string][]struct {
+	Name     string
+	Children []string
+}{
+	models.CategoryKindExpense: {
+		{Name: Uncategorised},
+		{Name: "Housing", Children: []string{"Rent", "Mortgage", "Utilities", "Council Tax", "Maintenance"}},
+		{Name: "Food", Children: []string{"Groceries", "Eating Out", "Takeaway"}},
+		{Name: "Transport", Children: []string{"Fuel", "Public Transport", "Parking", "Car Maintenance"}},
+		{Name: "Shopping", Children: []string{"Clothing", "Electronics", "Household"}},
+		{Name: "Bills", Children: []string{"Phone", "Internet", "Subscriptions", "Insurance"}},
+		{Name: "Entertainment", Children: []string{"Going Out", "Hobbies", "Holidays"}},
+		{Name: "Health", Children: []string{"Pharmacy", "Fitness"}},
+		{Name: "Personal", Children: []string{"Gifts", "Education", "Alcohol"}},
+	},
+	models.CategoryKindIncome: {
+		{Name: Uncategorised},
+		{Name: "Salary", Children: []string{"Wages", "Bonus"}},
+		{Name: "Self Employment", Children: []string{"Freelancing"}},
+		{Name: "Investments", Children: []string{"Interest", "Dividends"}},
+		{Name: "Gifts"},
+		{Name: "Refunds"},
+	},
+}
+
+// seedCategories creates the default tree when there are no categories yet,
+// then points any transaction without a category at Uncategorised.
+func seedCategories(db *gorm.DB) error {
+	var count int64
+	err := db.Model(models.Category{}).Count(&count).Error
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for kind, parents := range defaultCategories {
+				for _, p := range parents {
+					parent := models.Category{Name: p.Name, Kind: kind}
+					if err := tx.Create(&parent).Error; err != nil {
+						return err
+					}
+					for _, name := range p.Children {
+						child := models.Category{Name: name, Kind: kind, ParentID: &parent.ID}
+						if err := tx.Create(&child).Error; err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for kind, table := range map[string]string{
+		models.CategoryKindExpense: "expenses",
+		models.CategoryKindIncome:  "incomes",
+	} {
+		fallback, err := uncategorised(db, kind)
+		if err != nil {
+			return err
+		}
+		err = db.Exec(
+			"UPDATE ? SET category_id = ? WHERE category_id IS NULL OR category_id = 0",
+			clause.Table{Name: table}, fallback.ID,
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func uncategorised(db *gorm.DB, kind string) (models.Category, error) {
+	var category models.Category
+	tx := db.Model(models.Category{}).Where("name = ? AND kind = ? AND parent_id IS NULL", Uncategorised, kind).First(&category)
+	if tx.Error != nil {
+		return models.Category{}, tx.Error
+	}
+	return category, nil
+}
+
+// Gets every category of a kind as a flat list, or every category when kind is empty
+func (d *SQLite) GetCategories(kind string) ([]models.Category, error) {
+	var categories []models.Category
+	tx := d.DB.Model(models.Category{})
+	if kind != "" {
+		tx = tx.Where("kind = ?", kind)
+	}
+	tx = tx.Order("name").Find(&categories)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return categories, nil
+}
+
+func (d *SQLite) GetCategory(id int) (models.Category, error) {
+	var category models.Category
+	tx := d.DB.Model(models.Category{}).First(&category, id)
+	if tx.Error != nil {
+		return models.Category{}, tx.Error
+	}
+	return category, nil
+}
+
+// Gets the Uncategorised category for a kind
+func (d *SQLite) GetUncategorised(kind string) (models.Category, error) {
+	return uncategorised(d.DB, kind)
+}
+
+func (d *SQLite) AddCategory(category models.Category) error {
+	tx := d.DB.Model(models.Category{}).Create(&category)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+func (d *SQLite) UpdateCategory(category models.Category) error {
+	tx := d.DB.Model(&category).Select("name", "parent_id").Updates(&category)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Deletes a category, refusing with ErrCategoryInUse while it still has
+// subcategories or transactions, including deleted ones that could be restored
+func (d *SQLite) DeleteCategory(id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		var children, expenses, incomes, budgets int64
+		err := tx.Model(models.Category{}).Where("parent_id = ?", id).Count(&children).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(models.Expense{}).Where("category_id = ?", id).Count(&expenses).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(models.Income{}).Where("category_id = ?", id).Count(&incomes).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Budget{}).Where("category_id = ?", id).Count(&budgets).Error
+		if err != nil {
+			return err
+		}
+		if children+expenses+incomes+budgets > 0 {
+			return ErrCategoryInUse
+		}
+
+		return tx.Model(models.Category{}).Delete(&models.Category{}, id).Error
+	})
+}
@@ -2,100 +2,625 @@ package database
 
 import (
 	"log"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/Ewan-Greer09/finance-app/api/config"
 	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
 )
 
+// ErrNotFound is returned when a lookup by id or name matches nothing
+var ErrNotFound = gorm.ErrRecordNotFound
+
 type SQLite struct {
 	DB *gorm.DB
+	// who changes are recorded against in the audit log, see As
+	actor Actor
+	// whether the full-text search index is available, see searchAvailable
+	search bool
+	// the currency a new ledger's first account is opened in
+	currency string
 }
 
 type Database interface {
 	AddExpense(link models.Expense) error
 	AddIncome(link models.Income) error
-	GetExpenses() ([]models.Expense, error)
-	GetIncomes() ([]models.Income, error)
-	DeleteExpense(id int) error
-	DeleteIncome(id int) error
+	GetExpense(ledgerID uint, id int) (models.Expense, error)
+	GetExpenses(ledgerID uint, filter TransactionFilter, page Page) ([]models.Expense, string, error)
+	GetIncome(ledgerID uint, id int) (models.Income, error)
+	GetIncomes(ledgerID uint, filter TransactionFilter, page Page) ([]models.Income, string, error)
+	FilterExpenses(ledgerID uint, filter TransactionFilter) ([]models.Expense, error)
+	FilterIncomes(ledgerID uint, filter TransactionFilter) ([]models.Income, error)
+	UpdateExpense(ledgerID uint, expense models.Expense) error
+	UpdateIncome(ledgerID uint, income models.Income) error
+	DeleteExpense(ledgerID uint, id int) error
+	DeleteIncome(ledgerID uint, id int) error
+	SetExpenseSplits(ledgerID uint, id int, splits []models.ExpenseSplit) error
+
+	GetDeletedExpenses(ledgerID uint) ([]models.Expense, error)
+	GetDeletedIncomes(ledgerID uint) ([]models.Income, error)
+	RestoreExpense(ledgerID uint, id int) error
+	RestoreIncome(ledgerID uint, id int) error
+	PurgeExpense(ledgerID uint, id int) error
+	PurgeIncome(ledgerID uint, id int) error
+	PurgeDeleted(before time.Time) (int, error)
+
+	Search(ledgerID uint, text string, limit int) ([]models.SearchResult, error)
+
+	GetTags() ([]models.Tag, error)
+	AddExpenseTag(ledgerID uint, id int, tag string) error
+	RemoveExpenseTag(ledgerID uint, id int, tag string) error
+	AddIncomeTag(ledgerID uint, id int, tag string) error
+	RemoveIncomeTag(ledgerID uint, id int, tag string) error
+
+	GetCategories(kind string) ([]models.Category, error)
+	GetCategory(id int) (models.Category, error)
+	GetUncategorised(kind string) (models.Category, error)
+	AddCategory(category models.Category) error
+	UpdateCategory(category models.Category) error
+	DeleteCategory(id int) error
+
+	GetAccounts(ledgerID uint) ([]models.Account, error)
+	GetAccount(ledgerID uint, id int) (models.Account, error)
+	GetDefaultAccount(ledgerID uint) (models.Account, error)
+	AddAccount(account models.Account) error
+	DeleteAccount(ledgerID uint, id int) error
+	GetAccountBalances(ledgerID uint, on time.Time) ([]models.AccountBalance, error)
+	GetUnbalancedEntries() ([]models.JournalEntry, error)
+	AddTransfer(transfer models.Transfer) error
+	GetTransfers(ledgerID uint, page Page) ([]models.Transfer, string, error)
+	DeleteTransfer(ledgerID uint, id int) error
+
+	GetReconciliations(ledgerID uint) ([]models.Reconciliation, error)
+	GetReconciliation(ledgerID uint, id int) (models.ReconciliationProgress, error)
+	StartReconciliation(reconciliation models.Reconciliation) (models.Reconciliation, error)
+	SetCleared(ledgerID uint, id int, kind string, lineID int, cleared bool) error
+	FinishReconciliation(ledgerID uint, id int) error
+	CancelReconciliation(ledgerID uint, id int) error
+	UnreconcileExpense(ledgerID uint, id int) error
+	UnreconcileIncome(ledgerID uint, id int) error
+	UnreconcileTransfer(ledgerID uint, id int) error
+	UnreconcileTrade(ledgerID uint, id int) error
+
+	GetLoans(ledgerID uint) ([]models.Loan, error)
+	GetLoan(ledgerID uint, id int) (models.Loan, error)
+	AddLoan(loan models.Loan) error
+	DeleteLoan(ledgerID uint, id int) error
+	PayLoan(ledgerID uint, id int, userID uint, on time.Time) error
+
+	GetSecurities() ([]models.Security, error)
+	GetSecurity(id int) (models.Security, error)
+	AddSecurity(security models.Security) error
+	AddPrices(quotes []models.PriceQuote) (int, error)
+	GetPrices() ([]models.SecurityPrice, error)
+	GetTrades(ledgerID uint) ([]models.Trade, error)
+	AddTrade(trade models.Trade, categoryID, userID uint) error
+	DeleteTrade(ledgerID uint, id int) error
+
+	GetAssets(ledgerID uint) ([]models.Asset, error)
+	GetAsset(ledgerID uint, id int) (models.Asset, error)
+	AddAsset(asset models.Asset) error
+	RevalueAsset(ledgerID uint, id int, value money.Money, on time.Time) error
+	DeleteAsset(ledgerID uint, id int) error
+	GetNetWorth(ledgerID uint, currency string, on time.Time) (models.NetWorth, error)
+	SnapshotNetWorth(currency string, on time.Time) (int, error)
+	GetNetWorthSnapshots(ledgerID uint) ([]models.NetWorthSnapshot, error)
+
+	GetBills(ledgerID uint) ([]models.Bill, error)
+	GetBill(ledgerID uint, id int) (models.Bill, error)
+	AddBill(bill models.Bill) error
+	DeleteBill(ledgerID uint, id int) error
+	PayBill(bill models.Bill, userID uint, amount money.Money, on time.Time, next *time.Time) error
+	RemindBills(until time.Time) (int, error)
+	GetNotifications(ledgerID uint) ([]models.Notification, error)
+	ReadNotification(ledgerID uint, id int) error
 
 	GetUser(username string) (models.User, error)
 	CreateUser(user models.User) error
+	SetReportingCurrency(username, currency string) error
+
+	GetLedgers(userID uint) ([]models.LedgerMember, error)
+	GetMember(ledgerID, userID uint) (models.LedgerMember, error)
+	GetMembers(ledgerID uint) ([]models.LedgerMember, error)
+	AddLedger(ledger models.Ledger, ownerID uint) (models.Ledger, error)
+	AddMember(member models.LedgerMember) error
+	SetMemberRole(ledgerID, userID uint, role string) error
+	RemoveMember(ledgerID, userID uint) error
+
+	As(actor Actor) Database
+	GetAuditEntries(filter AuditFilter) ([]models.AuditEntry, error)
+
+	GetRules(ledgerID uint) ([]models.Rule, error)
+	GetRule(ledgerID uint, id int) (models.Rule, error)
+	AddRule(rule models.Rule) error
+	UpdateRule(rule models.Rule) error
+	DeleteRule(ledgerID uint, id int) error
+	ReapplyRules(ledgerID uint) (int, error)
+
+	AddRates(rates []models.ExchangeRate) error
+	GetRate(base, quote string, on time.Time) (models.ExchangeRate, error)
+
+	GetRecurring(ledgerID uint) ([]models.Recurring, error)
+	GetDueRecurring(on time.Time) ([]models.Recurring, error)
+	AddRecurring(recurring models.Recurring) error
+	DeleteRecurring(ledgerID uint, id int) error
+	PostRecurring(recurring models.Recurring, on time.Time, next *time.Time) error
+
+	GetBudgets(ledgerID uint) ([]models.Budget, error)
+	GetBudget(ledgerID uint, id int) (models.Budget, error)
+	AddBudget(budget models.Budget) error
+	UpdateBudget(budget models.Budget) error
+	DeleteBudget(ledgerID uint, id int) error
+	GetBudgetProgress(ledgerID uint, on time.Time) ([]models.BudgetProgress, error)
+
+	GetGoals(ledgerID uint) ([]models.Goal, error)
+	GetGoal(ledgerID uint, id int) (models.Goal, error)
+	AddGoal(goal models.Goal) error
+	DeleteGoal(ledgerID uint, id int) error
+	AddContribution(contribution models.GoalContribution) error
+	DeleteContribution(ledgerID uint, id int) error
 
 	Close() error
 }
 
 func NewDatabase(cf config.Config) *SQLite {
-	db, err := gorm.Open(sqlite.Open(cf.API.DatabaseName), &gorm.Config{})
+	// the migrations and seeds below store amounts in it, so a bad one would
+	// zero every legacy amount before the column holding them is dropped
+	if !money.ValidCurrency(cf.API.DefaultCurrency) {
+		log.Panicf("default_currency %q must be a three letter currency code such as USD", cf.API.DefaultCurrency)
+	}
+
+	// the background jobs write alongside requests, so a transaction takes the
+	// write lock when it begins and waits for it rather than failing, and
+	// readers don't block on it in WAL mode
+	db, err := gorm.Open(sqlite.Open(cf.API.DatabaseName+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"), &gorm.Config{})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	search, err := searchAvailable(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = db.AutoMigrate(&models.Expense{}, &models.Income{}, &models.User{}, &models.ExchangeRate{}, &models.Category{}, &models.Tag{}, &models.Account{}, &models.Transfer{}, &models.ExpenseSplit{}, &models.Recurring{}, &models.Budget{}, &models.Goal{}, &models.GoalContribution{}, &models.Ledger{}, &models.LedgerMember{}, &models.AuditEntry{}, &models.Rule{}, &models.JournalEntry{}, &models.Posting{}, &models.Reconciliation{}, &models.Loan{}, &models.LoanPayment{}, &models.Security{}, &models.SecurityPrice{}, &models.Trade{}, &models.Asset{}, &models.NetWorthSnapshot{}, &models.Bill{}, &models.Notification{})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = migrateLegacyAmounts(db, cf.API.DefaultCurrency)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = backfillOccurredOn(db)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.AutoMigrate(&models.Expense{}, &models.Income{}, &models.User{})
+	err = seedCategories(db)
 	if err != nil {
 		log.Panic(err)
 	}
 
+	err = claimUnownedTransactions(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = seedLedgers(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = seedAccounts(db, cf.API.DefaultCurrency)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = seedLoanAccounts(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = backfillJournal(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = dropLegacyBudgetIndex(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = protectAuditLog(db)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if search {
+		err = createSearchIndex(db)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
 	return &SQLite{
-		DB: db,
+		DB:       db,
+		search:   search,
+		currency: cf.API.DefaultCurrency,
 	}
 }
 
-// Adds an Expense to the database
+// Adds an Expense and its split lines to the database after running the
+// ledger's rules over it, creating any of its tags that don't exist yet
 func (d *SQLite) AddExpense(expense models.Expense) error {
-	tx := d.DB.Model(models.Expense{}).Create(&expense)
+	err := expense.ValidateSplits()
+	if err != nil {
+		return err
+	}
+
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		ruled, err := applyRules(tx, expense.LedgerID, models.CategoryKindExpense, ruleTarget{
+			Source:     expense.Source,
+			Amount:     expense.Amount,
+			AccountID:  expense.AccountID,
+			CategoryID: expense.CategoryID,
+			Tags:       tagNames(expense.Tags),
+		})
+		if err != nil {
+			return err
+		}
+		expense.Source = ruled.Source
+		expense.CategoryID = ruled.CategoryID
+		expense.Tags = withTags(expense.Tags, ruled.Tags)
+
+		expense.Tags, err = findOrCreateTags(tx, expense.Tags)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Expense{}).Create(&expense).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditCreate, models.AuditExpense, expense.ID, "")
+	})
+}
+
+func (d *SQLite) GetExpense(ledgerID uint, id int) (models.Expense, error) {
+	err := inLedger(d.DB, &models.Expense{}, ledgerID, id)
+	if err != nil {
+		return models.Expense{}, err
+	}
+
+	var expense models.Expense
+	tx := d.DB.Model(models.Expense{}).
+		Preload("Category").Preload("Tags").Preload("Account").Preload("Splits.Category").
+		First(&expense, id)
 	if tx.Error != nil {
-		return tx.Error
+		return models.Expense{}, tx.Error
 	}
-	return nil
+	return expense, nil
+}
+
+// Gets a page of the ledger's Expenses matching the filter in its order, and
+// the cursor for the next page, which is empty on the last one
+func (d *SQLite) GetExpenses(ledgerID uint, filter TransactionFilter, page Page) ([]models.Expense, string, error) {
+	tx, err := paginate(applyFilter(d.DB.Model(models.Expense{}).Where("ledger_id = ?", ledgerID), filter, "expense_tags", "expense_id"), filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var expenses []models.Expense
+	tx = tx.Preload("Category").Preload("Tags").Preload("Account").Preload("Splits.Category").Find(&expenses)
+	if tx.Error != nil {
+		return nil, "", tx.Error
+	}
+
+	next := ""
+	if len(expenses) > page.size() {
+		expenses = expenses[:page.size()]
+		last := expenses[len(expenses)-1]
+		next = encodeCursor(filter, filter.cursorKey(last.OccurredOn, last.Amount, last.Source), last.ID)
+	}
+	return expenses, next, nil
 }
 
-// Gets 10 Expenses from the database
-func (d *SQLite) GetExpenses() ([]models.Expense, error) {
+// Gets every one of the ledger's Expenses matching the filter, in its order
+func (d *SQLite) FilterExpenses(ledgerID uint, filter TransactionFilter) ([]models.Expense, error) {
 	var expenses []models.Expense
-	tx := d.DB.Model(models.Expense{}).Order("created_at desc").Limit(10).Find(&expenses)
+	tx := order(applyFilter(d.DB.Model(models.Expense{}).Where("ledger_id = ?", ledgerID), filter, "expense_tags", "expense_id"), filter).
+		Preload("Category").Preload("Tags").Preload("Account").Preload("Splits.Category").
+		Find(&expenses)
 	if tx.Error != nil {
 		return nil, tx.Error
 	}
 	return expenses, nil
 }
 
-func (d *SQLite) DeleteExpense(id int) error {
-	tx := d.DB.Model(models.Expense{}).Delete(&models.Expense{}, id)
-	if tx.Error != nil {
-		return tx.Error
+// Updates an Expense's source, notes, amount, date, category and account. Its tags
+// and split lines are kept, so the amount of a split expense must still match
+// its lines.
+func (d *SQLite) UpdateExpense(ledgerID uint, expense models.Expense) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Expense{}, ledgerID, int(expense.ID))
+		if err != nil {
+			return err
+		}
+		err = notReconciled(tx, &models.Expense{}, int(expense.ID))
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(models.ExpenseSplit{}).Where("expense_id = ?", expense.ID).Find(&expense.Splits).Error
+		if err != nil {
+			return err
+		}
+		err = expense.ValidateSplits()
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, models.AuditExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Expense{}).
+			Where("id = ?", expense.ID).
+			Updates(transactionColumns(expense.Source, expense.Notes, expense.Amount, expense.OccurredOn, expense.CategoryID, expense.AccountID)).
+			Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, models.AuditExpense, expense.ID, before)
+	})
+}
+
+// transactionColumns are the columns an edit to an Income or Expense changes
+func transactionColumns(source, notes string, amount money.Money, occurredOn time.Time, categoryID, accountID uint) map[string]interface{} {
+	return map[string]interface{}{
+		"source":          source,
+		"notes":           notes,
+		"amount_minor":    amount.Minor,
+		"amount_currency": amount.Currency,
+		"occurred_on":     occurredOn,
+		"category_id":     categoryID,
+		"account_id":      accountID,
 	}
-	return nil
 }
 
-// Adds an Income to the database
+func (d *SQLite) DeleteExpense(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Expense{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = notReconciled(tx, &models.Expense{}, id)
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, models.AuditExpense, uint(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Expense{}).Delete(&models.Expense{}, id).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalExpense, uint(id))
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditDelete, models.AuditExpense, uint(id), before)
+	})
+}
+
+// Replaces an Expense's split lines, an empty list removes the split
+func (d *SQLite) SetExpenseSplits(ledgerID uint, id int, splits []models.ExpenseSplit) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Expense{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = notReconciled(tx, &models.Expense{}, id)
+		if err != nil {
+			return err
+		}
+
+		var expense models.Expense
+		err = tx.Model(models.Expense{}).First(&expense, id).Error
+		if err != nil {
+			return err
+		}
+
+		expense.Splits = splits
+		err = expense.ValidateSplits()
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, models.AuditExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+
+		// old lines have no history worth keeping, so they're removed outright
+		err = tx.Unscoped().Where("expense_id = ?", id).Delete(&models.ExpenseSplit{}).Error
+		if err != nil {
+			return err
+		}
+		for i := range splits {
+			splits[i].ExpenseID = expense.ID
+		}
+		if len(splits) > 0 {
+			err = tx.Model(models.ExpenseSplit{}).Create(&splits).Error
+			if err != nil {
+				return err
+			}
+		}
+		err = postJournal(tx, models.JournalExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, models.AuditExpense, expense.ID, before)
+	})
+}
+
+// Adds an Income to the database after running the ledger's rules over it,
+// creating any of its tags that don't exist yet
 func (d *SQLite) AddIncome(link models.Income) error {
-	tx := d.DB.Model(models.Income{}).Create(&link)
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		ruled, err := applyRules(tx, link.LedgerID, models.CategoryKindIncome, ruleTarget{
+			Source:     link.Source,
+			Amount:     link.Amount,
+			AccountID:  link.AccountID,
+			CategoryID: link.CategoryID,
+			Tags:       tagNames(link.Tags),
+		})
+		if err != nil {
+			return err
+		}
+		link.Source = ruled.Source
+		link.CategoryID = ruled.CategoryID
+		link.Tags = withTags(link.Tags, ruled.Tags)
+
+		link.Tags, err = findOrCreateTags(tx, link.Tags)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Income{}).Create(&link).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalIncome, link.ID)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditCreate, models.AuditIncome, link.ID, "")
+	})
+}
+
+func (d *SQLite) GetIncome(ledgerID uint, id int) (models.Income, error) {
+	err := inLedger(d.DB, &models.Income{}, ledgerID, id)
+	if err != nil {
+		return models.Income{}, err
+	}
+
+	var income models.Income
+	tx := d.DB.Model(models.Income{}).
+		Preload("Category").Preload("Tags").Preload("Account").
+		First(&income, id)
 	if tx.Error != nil {
-		return tx.Error
+		return models.Income{}, tx.Error
 	}
-	return nil
+	return income, nil
 }
 
-// Gets 10 Incomes from the database
-func (d *SQLite) GetIncomes() ([]models.Income, error) {
+// Gets a page of the ledger's Incomes matching the filter in its order, and
+// the cursor for the next page, which is empty on the last one
+func (d *SQLite) GetIncomes(ledgerID uint, filter TransactionFilter, page Page) ([]models.Income, string, error) {
+	tx, err := paginate(applyFilter(d.DB.Model(models.Income{}).Where("ledger_id = ?", ledgerID), filter, "income_tags", "income_id"), filter, page)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var incomes []models.Income
-	tx := d.DB.Model(models.Income{}).Order("created_at desc").Limit(10).Find(&incomes)
+	tx = tx.Preload("Category").Preload("Tags").Preload("Account").Find(&incomes)
 	if tx.Error != nil {
-		return nil, tx.Error
+		return nil, "", tx.Error
 	}
-	return incomes, nil
+
+	next := ""
+	if len(incomes) > page.size() {
+		incomes = incomes[:page.size()]
+		last := incomes[len(incomes)-1]
+		next = encodeCursor(filter, filter.cursorKey(last.OccurredOn, last.Amount, last.Source), last.ID)
+	}
+	return incomes, next, nil
 }
 
-func (d *SQLite) DeleteIncome(id int) error {
-	tx := d.DB.Model(models.Income{}).Delete(&models.Income{}, id)
+// Gets every one of the ledger's Incomes matching the filter, in its order
+func (d *SQLite) FilterIncomes(ledgerID uint, filter TransactionFilter) ([]models.Income, error) {
+	var incomes []models.Income
+	tx := order(applyFilter(d.DB.Model(models.Income{}).Where("ledger_id = ?", ledgerID), filter, "income_tags", "income_id"), filter).
+		Preload("Category").Preload("Tags").Preload("Account").
+		Find(&incomes)
 	if tx.Error != nil {
-		return tx.Error
+		return nil, tx.Error
 	}
-	return nil
+	return incomes, nil
+}
+
+// Updates an Income's source, notes, amount, date, category and account,
+// keeping its tags
+func (d *SQLite) UpdateIncome(ledgerID uint, income models.Income) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Income{}, ledgerID, int(income.ID))
+		if err != nil {
+			return err
+		}
+		err = notReconciled(tx, &models.Income{}, int(income.ID))
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, models.AuditIncome, income.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Income{}).
+			Where("id = ?", income.ID).
+			Updates(transactionColumns(income.Source, income.Notes, income.Amount, income.OccurredOn, income.CategoryID, income.AccountID)).
+			Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalIncome, income.ID)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, models.AuditIncome, income.ID, before)
+	})
+}
+
+func (d *SQLite) DeleteIncome(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Income{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = notReconciled(tx, &models.Income{}, id)
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, models.AuditIncome, uint(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Model(models.Income{}).Delete(&models.Income{}, id).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalIncome, uint(id))
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditDelete, models.AuditIncome, uint(id), before)
+	})
 }
 
 func (d *SQLite) GetUser(username string) (models.User, error) {
@@ -107,14 +632,75 @@ func (d *SQLite) GetUser(username string) (models.User, error) {
 	return user, nil
 }
 
+// Creates a user along with their personal ledger
 func (d *SQLite) CreateUser(user models.User) error {
-	tx := d.DB.Model(models.User{}).Create(&user)
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(models.User{}).Create(&user).Error
+		if err != nil {
+			return err
+		}
+		err = createPersonalLedger(tx, user, d.currency)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditCreate, models.AuditUser, user.ID, "")
+	})
+}
+
+func (d *SQLite) SetReportingCurrency(username, currency string) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Model(models.User{}).Where("username = ?", username).First(&user).Error
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, models.AuditUser, user.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&user).Update("reporting_currency", currency).Error
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, models.AuditUser, user.ID, before)
+	})
+}
+
+// Adds exchange rates, replacing any already stored for the same day and pair
+func (d *SQLite) AddRates(rates []models.ExchangeRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	tx := d.DB.Model(models.ExchangeRate{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "base"}, {Name: "quote"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at", "deleted_at"}),
+	}).CreateInBatches(&rates, 500)
 	if tx.Error != nil {
 		return tx.Error
 	}
 	return nil
 }
 
+// Gets the most recent rate published on or before the given day
+func (d *SQLite) GetRate(base, quote string, on time.Time) (models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	tx := d.DB.Model(models.ExchangeRate{}).
+		Where("base = ? AND quote = ? AND date <= ?", base, quote, on).
+		Order("date desc").
+		Limit(1).
+		Find(&rate)
+	if tx.Error != nil {
+		return models.ExchangeRate{}, tx.Error
+	}
+	// Find rather than First, missing rates are routine and First logs every miss
+	if tx.RowsAffected == 0 {
+		return models.ExchangeRate{}, gorm.ErrRecordNotFound
+	}
+	return rate, nil
+}
+
 func (d *SQLite) Close() error {
 	db, err := d.DB.DB()
 	if err != nil {
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+// SortField is what a list of expenses or incomes is ordered by, ties are
+// broken by ID. Amounts are sorted within each currency, see groupColumn.
+type SortField string
+
+const (
+	SortDate   SortField = "date"
+	SortAmount SortField = "amount"
+	SortSource SortField = "source"
+)
+
+// TransactionFilter narrows a list of expenses or incomes. Zero fields don't
+// filter, so the zero value matches everything.
+type TransactionFilter struct {
+	// inclusive range of OccurredOn days
+	From time.Time
+	To   time.Time
+	// inclusive range of amounts, only transactions in the bound's currency match
+	MinAmount *money.Money
+	MaxAmount *money.Money
+	// case insensitive substring of the source
+	Source string
+	// only transactions in this category or one nested under it
+	CategoryID uint
+	AccountID  uint
+	// only transactions carrying this tag
+	Tag string
+
+	// newest first when Sort is empty
+	Sort      SortField
+	Ascending bool
+}
+
+// joinTable and joinColumn are the many2many table linking the filtered
+// model to its tags, e.g. expense_tags and expense_id
+func applyFilter(tx *gorm.DB, f TransactionFilter, joinTable, joinColumn string) *gorm.DB {
+	if !f.From.IsZero() {
+		tx = tx.Where("occurred_on >= ?", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		tx = tx.Where("occurred_on <= ?", f.To.UTC())
+	}
+	if f.MinAmount != nil {
+		tx = tx.Where("amount_currency = ? AND amount_minor >= ?", f.MinAmount.Currency, f.MinAmount.Minor)
+	}
+	if f.MaxAmount != nil {
+		tx = tx.Where("amount_currency = ? AND amount_minor <= ?", f.MaxAmount.Currency, f.MaxAmount.Minor)
+	}
+	if f.Source != "" {
+		tx = tx.Where(`source LIKE ? ESCAPE '\'`, "%"+escapeLike(f.Source)+"%")
+	}
+	if f.CategoryID != 0 {
+		tx = tx.Where(
+			"category_id IN (WITH RECURSIVE nested(id) AS (SELECT ? UNION SELECT categories.id FROM categories JOIN nested ON categories.parent_id = nested.id) SELECT id FROM nested)",
+			f.CategoryID,
+		)
+	}
+	if f.AccountID != 0 {
+		tx = tx.Where("account_id = ?", f.AccountID)
+	}
+	if f.Tag != "" {
+		tx = tx.Where(
+			"id IN (SELECT "+joinColumn+" FROM "+joinTable+" JOIN tags ON tags.id = "+joinTable+".tag_id WHERE tags.name = ?)",
+			f.Tag,
+		)
+	}
+	return tx
+}
+
+// escapeLike stops % and _ in s matching anything in a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// sortColumn is the column expression the filter orders by
+func (f TransactionFilter) sortColumn() string {
+	switch f.Sort {
+	case SortAmount:
+		return "amount_minor"
+	case SortSource:
+		return "source COLLATE NOCASE"
+	default:
+		return "occurred_on"
+	}
+}
+
+// groupColumn is what rows are grouped by, always A to Z, before they're
+// sorted, or empty for none. Amounts in different currencies can't be
+// compared, so an amount sort lists each currency's amounts in turn.
+func (f TransactionFilter) groupColumn() string {
+	if f.Sort == SortAmount {
+		return "amount_currency"
+	}
+	return ""
+}
+
+// direction is the order's SQL keyword and the comparison that moves along it
+func (f TransactionFilter) direction() (string, string) {
+	if f.Ascending {
+		return "asc", ">"
+	}
+	return "desc", "<"
+}
+
+// order sorts tx by the filter's sort, with ID breaking ties
+func order(tx *gorm.DB, f TransactionFilter) *gorm.DB {
+	dir, _ := f.direction()
+	if group := f.groupColumn(); group != "" {
+		tx = tx.Order(group + " asc")
+	}
+	return tx.Order(f.sortColumn() + " " + dir + ", id " + dir)
+}
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't handed out by a list
+// with the same sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page asks for one page of a sorted list. Cursor is the next cursor of the
+// page before, empty for the first page.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+func (p Page) size() int {
+	if p.Limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(p.Limit, MaxPageSize)
+}
+
+// paginate sorts tx by the filter and starts it after the page's cursor. It
+// fetches one row more than the page holds so the caller can tell whether
+// there is another page.
+func paginate(tx *gorm.DB, f TransactionFilter, page Page) (*gorm.DB, error) {
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, f)
+		if err != nil {
+			return nil, err
+		}
+		col := f.sortColumn()
+		_, cmp := f.direction()
+		after := "(" + col + " " + cmp + " ? OR (" + col + " = ? AND id " + cmp + " ?))"
+		if group := f.groupColumn(); group != "" {
+			tx = tx.Where("("+group+" > ? OR ("+group+" = ? AND "+after+"))", c.group, c.group, c.key, c.key, c.id)
+		} else {
+			tx = tx.Where(after, c.key, c.key, c.id)
+		}
+	}
+	return order(tx, f).Limit(page.size() + 1), nil
+}
+
+// cursorKey is a row's value of the filter's group and sort columns, written
+// as a string
+func (f TransactionFilter) cursorKey(occurredOn time.Time, amount money.Money, source string) string {
+	switch f.Sort {
+	case SortAmount:
+		return amount.Currency + " " + strconv.FormatInt(amount.Minor, 10)
+	case SortSource:
+		return source
+	default:
+		return occurredOn.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// sortName identifies the sort a cursor was made for, so it isn't used with another
+func (f TransactionFilter) sortName() string {
+	dir, _ := f.direction()
+	return string(f.Sort) + " " + dir
+}
+
+// cursors are opaque to callers, inside they're the sort, the last row's ID
+// and its sort key, which goes last as a source may contain the separator
+func encodeCursor(f TransactionFilter, key string, id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(f.sortName() + "|" + strconv.FormatUint(uint64(id), 10) + "|" + key))
+}
+
+// cursor is where a page starts: after the row id, whose group and sort
+// columns held group and key
+type cursor struct {
+	group string
+	key   interface{}
+	id    uint
+}
+
+func decodeCursor(encoded string, f TransactionFilter) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(b), "|", 3)
+	if len(parts) != 3 || parts[0] != f.sortName() {
+		return cursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	c := cursor{id: uint(id)}
+	switch f.Sort {
+	case SortAmount:
+		var minor string
+		c.group, minor, _ = strings.Cut(parts[2], " ")
+		c.key, err = strconv.ParseInt(minor, 10, 64)
+	case SortSource:
+		c.key = parts[2]
+	default:
+		c.key, err = time.Parse(time.RFC3339Nano, parts[2])
+	}
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+func TestCursor(t *testing.T) {
+	on := time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		filter TransactionFilter
+		source string
+		group  string
+		want   interface{}
+	}{
+		{"newest first", TransactionFilter{}, "Shop", "", on},
+		{"oldest first", TransactionFilter{Sort: SortDate, Ascending: true}, "Shop", "", on},
+		{"by amount", TransactionFilter{Sort: SortAmount}, "Shop", "GBP", int64(-1250)},
+		{"by source", TransactionFilter{Sort: SortSource, Ascending: true}, "Shop", "", "Shop"},
+		{"source with the separator", TransactionFilter{Sort: SortSource}, "A|B|C", "", "A|B|C"},
+		{"empty source", TransactionFilter{Sort: SortSource}, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.filter.cursorKey(on, money.New(-1250, "GBP"), tt.source)
+			cursor := encodeCursor(tt.filter, key, 42)
+
+			c, err := decodeCursor(cursor, tt.filter)
+			if err != nil {
+				t.Fatalf("decodeCursor(%q) error = %v", cursor, err)
+			}
+			if c.id != 42 {
+				t.Errorf("decodeCursor id = %d, want 42", c.id)
+			}
+			if c.group != tt.group {
+				t.Errorf("decodeCursor group = %q, want %q", c.group, tt.group)
+			}
+			if tm, ok := c.key.(time.Time); ok {
+				if !tm.Equal(tt.want.(time.Time)) {
+					t.Errorf("decodeCursor key = %v, want %v", c.key, tt.want)
+				}
+			} else if c.key != tt.want {
+				t.Errorf("decodeCursor key = %#v, want %#v", c.key, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	byDate := TransactionFilter{}
+	byAmount := TransactionFilter{Sort: SortAmount}
+	tests := []struct {
+		name   string
+		cursor string
+		filter TransactionFilter
+	}{
+		{"not base64", "!!!", byDate},
+		{"another sort", encodeCursor(byDate, byDate.cursorKey(time.Now(), money.Money{}, ""), 1), byAmount},
+		{"another direction", encodeCursor(byDate, byDate.cursorKey(time.Now(), money.Money{}, ""), 1), TransactionFilter{Ascending: true}},
+		{"missing parts", encodeCursor(byDate, "", 1)[:4], byDate},
+		{"bad id", base64.RawURLEncoding.EncodeToString([]byte("amount desc|x|GBP 100")), byAmount},
+		{"bad amount", encodeCursor(byAmount, "GBP ten", 1), byAmount},
+		{"amount without a currency", encodeCursor(byAmount, "100", 1), byAmount},
+		{"bad date", encodeCursor(byDate, "yesterday", 1), byDate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.filter)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("decodeCursor(%q) error = %v, want ErrInvalidCursor", tt.cursor, err)
+			}
+		})
+	}
+}
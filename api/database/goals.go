@@ -0,0 +1,87 @@
+package database
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+func (d *SQLite) GetGoals(ledgerID uint) ([]models.Goal, error) {
+	var goals []models.Goal
+	tx := d.DB.Model(models.Goal{}).
+		Where("ledger_id = ?", ledgerID).
+		Preload("Account").
+		Preload("Contributions", func(db *gorm.DB) *gorm.DB { return db.Order("occurred_on, id") }).
+		Order("name").
+		Find(&goals)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return goals, nil
+}
+
+func (d *SQLite) GetGoal(ledgerID uint, id int) (models.Goal, error) {
+	err := inLedger(d.DB, &models.Goal{}, ledgerID, id)
+	if err != nil {
+		return models.Goal{}, err
+	}
+
+	var goal models.Goal
+	tx := d.DB.Model(models.Goal{}).
+		Preload("Account").
+		Preload("Contributions", func(db *gorm.DB) *gorm.DB { return db.Order("occurred_on, id") }).
+		First(&goal, id)
+	if tx.Error != nil {
+		return models.Goal{}, tx.Error
+	}
+	return goal, nil
+}
+
+func (d *SQLite) AddGoal(goal models.Goal) error {
+	tx := d.DB.Omit("Account").Create(&goal)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Deletes a goal along with its contribution history
+func (d *SQLite) DeleteGoal(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Goal{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("goal_id = ?", id).Delete(&models.GoalContribution{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.Goal{}, id).Error
+	})
+}
+
+func (d *SQLite) AddContribution(contribution models.GoalContribution) error {
+	tx := d.DB.Create(&contribution)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Deletes a contribution to one of the ledger's goals
+func (d *SQLite) DeleteContribution(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		var contribution models.GoalContribution
+		err := tx.Model(models.GoalContribution{}).First(&contribution, id).Error
+		if err != nil {
+			return err
+		}
+		err = inLedger(tx, &models.Goal{}, ledgerID, int(contribution.GoalID))
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.GoalContribution{}, id).Error
+	})
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+var (
+	ErrSecurityExists = errors.New("a security with that symbol already exists")
+	ErrNotEnoughHeld  = errors.New("not enough units held to sell")
+)
+
+func (d *SQLite) GetSecurities() ([]models.Security, error) {
+	var securities []models.Security
+	tx := d.DB.Model(models.Security{}).Order("symbol").Find(&securities)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return securities, nil
+}
+
+func (d *SQLite) GetSecurity(id int) (models.Security, error) {
+	var security models.Security
+	tx := d.DB.Model(models.Security{}).First(&security, id)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return models.Security{}, ErrNotFound
+	}
+	if tx.Error != nil {
+		return models.Security{}, tx.Error
+	}
+	return security, nil
+}
+
+// Adds a security, refusing with ErrSecurityExists when its symbol is taken
+func (d *SQLite) AddSecurity(security models.Security) error {
+	security.Symbol = strings.ToUpper(strings.TrimSpace(security.Symbol))
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(models.Security{}).Where("symbol = ?", security.Symbol).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrSecurityExists
+		}
+		return tx.Create(&security).Error
+	})
+}
+
+// Stores imported prices, replacing any already held for the same security
+// and day. Quotes for symbols that aren't a Security are skipped, it returns
+// how many were stored.
+func (d *SQLite) AddPrices(quotes []models.PriceQuote) (int, error) {
+	securities, err := d.GetSecurities()
+	if err != nil {
+		return 0, err
+	}
+	bySymbol := map[string]uint{}
+	for _, security := range securities {
+		bySymbol[security.Symbol] = security.ID
+	}
+
+	var prices []models.SecurityPrice
+	for _, quote := range quotes {
+		id, ok := bySymbol[quote.Symbol]
+		if !ok {
+			continue
+		}
+		prices = append(prices, models.SecurityPrice{SecurityID: id, Date: quote.Date, Price: quote.Price})
+	}
+	if len(prices) == 0 {
+		return 0, nil
+	}
+
+	tx := d.DB.Model(models.SecurityPrice{}).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "security_id"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price", "updated_at", "deleted_at"}),
+	}).CreateInBatches(&prices, 500)
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	return len(prices), nil
+}
+
+// Gets every stored price, oldest first
+func (d *SQLite) GetPrices() ([]models.SecurityPrice, error) {
+	var prices []models.SecurityPrice
+	tx := d.DB.Model(models.SecurityPrice{}).Order("security_id, date").Find(&prices)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return prices, nil
+}
+
+// Gets the ledger's trades in the order they happened. A dividend only counts
+// while the income it was booked as does, like loan payments.
+func (d *SQLite) GetTrades(ledgerID uint) ([]models.Trade, error) {
+	return ledgerTrades(d.DB, ledgerID)
+}
+
+func ledgerTrades(tx *gorm.DB, ledgerID uint) ([]models.Trade, error) {
+	var trades []models.Trade
+	err := tx.Model(models.Trade{}).
+		Where("ledger_id = ?", ledgerID).
+		Where("kind != ? OR income_id IN (?)", models.TradeDividend,
+			tx.Session(&gorm.Session{NewDB: true}).Model(models.Income{}).Select("id")).
+		Preload("Account").Preload("Security").
+		Order("traded_on, id").
+		Find(&trades).Error
+	if err != nil {
+		return nil, err
+	}
+	return trades, nil
+}
+
+// oversold reports whether trades ever sell more of a holding than is held
+func oversold(trades []models.Trade) bool {
+	type key struct{ account, security uint }
+	held := map[key]float64{}
+	for _, t := range trades {
+		k := key{t.AccountID, t.SecurityID}
+		switch t.Kind {
+		case models.TradeBuy:
+			held[k] += t.Quantity
+		case models.TradeSell:
+			held[k] -= t.Quantity
+			if held[k] < -1e-9 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Adds a trade, refusing with ErrNotEnoughHeld if it sells more than the
+// account held on the day. A dividend is also booked as an income to the
+// account under categoryID, entered by userID.
+func (d *SQLite) AddTrade(trade models.Trade, categoryID, userID uint) error {
+	err := trade.Validate()
+	if err != nil {
+		return err
+	}
+
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		if trade.Kind == models.TradeDividend {
+			income := models.Income{
+				LedgerID:   trade.LedgerID,
+				UserID:     userID,
+				Amount:     trade.Amount,
+				Source:     trade.Security.Symbol + " dividend",
+				OccurredOn: trade.TradedOn,
+				CategoryID: categoryID,
+				AccountID:  trade.AccountID,
+			}
+			err := tx.Model(models.Income{}).Create(&income).Error
+			if err != nil {
+				return err
+			}
+			err = postJournal(tx, models.JournalIncome, income.ID)
+			if err != nil {
+				return err
+			}
+			err = d.record(tx, models.AuditCreate, models.AuditIncome, income.ID, "")
+			if err != nil {
+				return err
+			}
+			trade.IncomeID = income.ID
+		}
+
+		err := tx.Omit("Account", "Security").Create(&trade).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalTrade, trade.ID)
+		if err != nil {
+			return err
+		}
+		return checkHoldings(tx, trade.LedgerID)
+	})
+}
+
+// checkHoldings refuses with ErrNotEnoughHeld once the ledger's trades sell
+// more than was held, so the change that caused it is rolled back
+func checkHoldings(tx *gorm.DB, ledgerID uint) error {
+	trades, err := ledgerTrades(tx, ledgerID)
+	if err != nil {
+		return err
+	}
+	if oversold(trades) {
+		return ErrNotEnoughHeld
+	}
+	return nil
+}
+
+// Deletes a trade, refusing with ErrNotEnoughHeld when later sells need what
+// it bought and with ErrReconciled while it or a dividend's income is
+// reconciled. A dividend's income goes to the trash with it.
+func (d *SQLite) DeleteTrade(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Trade{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+
+		var trade models.Trade
+		err = tx.Model(models.Trade{}).First(&trade, id).Error
+		if err != nil {
+			return err
+		}
+		if trade.Status == models.StatusReconciled {
+			return ErrReconciled
+		}
+		if trade.IncomeID != 0 {
+			err = notReconciled(tx, &models.Income{}, int(trade.IncomeID))
+			if err != nil {
+				return err
+			}
+			before, err := snapshot(tx, models.AuditIncome, trade.IncomeID)
+			if err != nil {
+				return err
+			}
+			deleted := tx.Model(models.Income{}).Delete(&models.Income{}, trade.IncomeID)
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			if deleted.RowsAffected > 0 {
+				err = postJournal(tx, models.JournalIncome, trade.IncomeID)
+				if err != nil {
+					return err
+				}
+				err = d.record(tx, models.AuditDelete, models.AuditIncome, trade.IncomeID, before)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = tx.Delete(&models.Trade{}, id).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalTrade, uint(id))
+		if err != nil {
+			return err
+		}
+		return checkHoldings(tx, ledgerID)
+	})
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+// journalSources are the tables journal entries are posted from
+var journalSources = []struct {
+	source, table string
+}{
+	{models.JournalExpense, "expenses"},
+	{models.JournalIncome, "incomes"},
+	{models.JournalTransfer, "transfers"},
+	{models.JournalTrade, "trades"},
+	{models.JournalOpening, "accounts"},
+}
+
+// journalEntry builds the entry for the record source/id as it is now. It
+// reports false when the record is gone or deleted, so shouldn't have one.
+func journalEntry(tx *gorm.DB, source string, id uint) (models.JournalEntry, bool, error) {
+	entry := models.JournalEntry{Source: source, SourceID: id}
+
+	switch source {
+	case models.JournalExpense:
+		var expense models.Expense
+		err := tx.Model(models.Expense{}).Preload("Splits").First(&expense, id).Error
+		if err != nil {
+			return entry, false, err
+		}
+		entry.LedgerID = expense.LedgerID
+		entry.OccurredOn = expense.OccurredOn
+		entry.Description = expense.Source
+		if len(expense.Splits) == 0 {
+			entry.Postings = append(entry.Postings, categoryPosting(expense.CategoryID, expense.Amount))
+		}
+		for _, split := range expense.Splits {
+			entry.Postings = append(entry.Postings, categoryPosting(split.CategoryID, split.Amount))
+		}
+		entry.Postings = append(entry.Postings, accountPosting(expense.AccountID, expense.Amount.Neg()))
+
+	case models.JournalIncome:
+		var income models.Income
+		err := tx.Model(models.Income{}).First(&income, id).Error
+		if err != nil {
+			return entry, false, err
+		}
+		entry.LedgerID = income.LedgerID
+		entry.OccurredOn = income.OccurredOn
+		entry.Description = income.Source
+		entry.Postings = []models.Posting{
+			accountPosting(income.AccountID, income.Amount),
+			categoryPosting(income.CategoryID, income.Amount.Neg()),
+		}
+
+	case models.JournalTransfer:
+		var transfer models.Transfer
+		err := tx.Model(models.Transfer{}).First(&transfer, id).Error
+		if err != nil {
+			return entry, false, err
+		}
+		entry.LedgerID = transfer.LedgerID
+		entry.OccurredOn = transfer.OccurredOn
+		entry.Description = transfer.Note
+		entry.Postings = []models.Posting{
+			accountPosting(transfer.FromAccountID, transfer.Amount.Neg()),
+			accountPosting(transfer.ToAccountID, transfer.ReceivedAmount),
+		}
+		// what left one account and arrived in the other only differ across
+		// currencies, equity takes up the exchange so each currency balances
+		if transfer.Amount != transfer.ReceivedAmount {
+			entry.Postings = append(entry.Postings,
+				equityPosting(transfer.Amount),
+				equityPosting(transfer.ReceivedAmount.Neg()),
+			)
+		}
+
+	case models.JournalTrade:
+		var trade models.Trade
+		err := tx.Model(models.Trade{}).Preload("Security").First(&trade, id).Error
+		if err != nil {
+			return entry, false, err
+		}
+		// a dividend is posted as its income
+		if trade.Kind == models.TradeDividend {
+			return entry, false, nil
+		}
+		entry.LedgerID = trade.LedgerID
+		entry.OccurredOn = trade.TradedOn
+		entry.Description = trade.Kind + " " + trade.Security.Symbol
+		cash := trade.Amount.Neg()
+		if trade.Kind == models.TradeSell {
+			cash = trade.Amount
+		}
+		entry.Postings = []models.Posting{
+			accountPosting(trade.AccountID, cash),
+			investmentPosting(cash.Neg()),
+		}
+
+	case models.JournalOpening:
+		var account models.Account
+		err := tx.Model(models.Account{}).First(&account, id).Error
+		if err != nil {
+			return entry, false, err
+		}
+		if account.OpeningBalance.IsZero() {
+			return entry, false, nil
+		}
+		// dated at the start of time so it counts on every day
+		entry.LedgerID = account.LedgerID
+		entry.OccurredOn = time.Time{}
+		entry.Description = "Opening balance of " + account.Name
+		entry.Postings = []models.Posting{
+			accountPosting(account.ID, account.OpeningBalance),
+			equityPosting(account.OpeningBalance.Neg()),
+		}
+	}
+	return entry, true, nil
+}
+
+// journalSourceOf is the journal source for a pointer to an Expense or Income
+func journalSourceOf(model interface{}) string {
+	if _, ok := model.(*models.Expense); ok {
+		return models.JournalExpense
+	}
+	return models.JournalIncome
+}
+
+func accountPosting(accountID uint, amount money.Money) models.Posting {
+	return models.Posting{Type: models.PostingAccount, AccountID: accountID, Amount: amount}
+}
+
+func categoryPosting(categoryID uint, amount money.Money) models.Posting {
+	return models.Posting{Type: models.PostingCategory, CategoryID: categoryID, Amount: amount}
+}
+
+func equityPosting(amount money.Money) models.Posting {
+	return models.Posting{Type: models.PostingEquity, Amount: amount}
+}
+
+func investmentPosting(amount money.Money) models.Posting {
+	return models.Posting{Type: models.PostingInvestment, Amount: amount}
+}
+
+// postJournal replaces the journal entry for the record source/id with one
+// for how it is now, or just removes it when the record has been deleted. It
+// must run in the same transaction as the change to the record, and refuses
+// with ErrUnbalanced rather than write an entry that doesn't balance.
+func postJournal(tx *gorm.DB, source string, id uint) error {
+	err := tx.Where("journal_entry_id IN (?)",
+		tx.Model(models.JournalEntry{}).Select("id").Where("source = ? AND source_id = ?", source, id),
+	).Delete(&models.Posting{}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Where("source = ? AND source_id = ?", source, id).Delete(&models.JournalEntry{}).Error
+	if err != nil {
+		return err
+	}
+
+	entry, ok, err := journalEntry(tx, source, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !ok) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = entry.Validate()
+	if err != nil {
+		return err
+	}
+	return tx.Create(&entry).Error
+}
+
+// backfillJournal posts entries for records that don't have one yet, such as
+// everything entered before there was a journal, and puts transfers and
+// opening balances posted before accounts belonged to a ledger in their
+// record's ledger.
+func backfillJournal(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, src := range journalSources {
+			err := tx.Exec(
+				"UPDATE journal_entries SET ledger_id = (SELECT ledger_id FROM ? WHERE id = journal_entries.source_id) WHERE source = ? AND ledger_id = 0",
+				clause.Table{Name: src.table}, src.source,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, src := range journalSources {
+			var ids []uint
+			err := tx.Table(src.table).
+				Where("deleted_at IS NULL").
+				Where("id NOT IN (?)", tx.Model(models.JournalEntry{}).Select("source_id").Where("source = ?", src.source)).
+				Pluck("id", &ids).Error
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				err = postJournal(tx, src.source, id)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Gets every journal entry whose postings don't add up to zero in each
+// currency, with its postings. It should always be empty, as entries are
+// checked before they are written.
+func (d *SQLite) GetUnbalancedEntries() ([]models.JournalEntry, error) {
+	var ids []uint
+	tx := d.DB.Model(models.JournalEntry{}).
+		Where("id IN (?) OR id NOT IN (?)",
+			d.DB.Model(models.Posting{}).Select("journal_entry_id").Group("journal_entry_id, amount_currency").Having("SUM(amount_minor) != 0"),
+			d.DB.Model(models.Posting{}).Select("journal_entry_id").Group("journal_entry_id").Having("COUNT(*) >= 2"),
+		).
+		Pluck("id", &ids)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var entries []models.JournalEntry
+	tx = d.DB.Model(models.JournalEntry{}).Preload("Postings").Order("id").Find(&entries, ids)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return entries, nil
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+var (
+	ErrAlreadyMember = errors.New("user is already a member of the ledger")
+	ErrLastOwner     = errors.New("a ledger must keep at least one owner")
+)
+
+// seedLedgers gives every user without a ledger a personal one, then puts
+// records from before ledgers existed in their owner's personal ledger.
+// Budgets and goals had no owner, so they go to the first user's. The new
+// ledgers get their accounts from seedAccounts.
+func seedLedgers(db *gorm.DB) error {
+	var users []models.User
+	err := db.Model(models.User{}).
+		Where("NOT EXISTS (SELECT 1 FROM ledger_members WHERE ledger_members.user_id = users.id AND ledger_members.deleted_at IS NULL)").
+		Order("id").
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		err = createPersonalLedger(db, user, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{"expenses", "incomes", "recurrings"} {
+		err = db.Exec(
+			"UPDATE ? SET ledger_id = (SELECT MIN(ledger_id) FROM ledger_members WHERE ledger_members.user_id = ?.user_id AND ledger_members.role = ? AND ledger_members.deleted_at IS NULL) WHERE ledger_id IS NULL OR ledger_id = 0",
+			clause.Table{Name: table}, clause.Table{Name: table}, models.RoleOwner,
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, table := range []string{"budgets", "goals"} {
+		err = db.Exec(
+			"UPDATE ? SET ledger_id = (SELECT MIN(id) FROM ledgers WHERE deleted_at IS NULL) WHERE ledger_id IS NULL OR ledger_id = 0",
+			clause.Table{Name: table},
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPersonalLedger creates the user's own ledger with a main account in
+// currency, or none when currency is empty
+func createPersonalLedger(tx *gorm.DB, user models.User, currency string) error {
+	ledger := models.Ledger{Name: user.Username + "'s ledger"}
+	err := tx.Create(&ledger).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Omit("Ledger", "User").Create(&models.LedgerMember{
+		LedgerID: ledger.ID,
+		UserID:   user.ID,
+		Role:     models.RoleOwner,
+	}).Error
+	if err != nil || currency == "" {
+		return err
+	}
+	return addMainAccount(tx, ledger.ID, currency)
+}
+
+// Gets the user's memberships with their ledgers, oldest ledger first
+func (d *SQLite) GetLedgers(userID uint) ([]models.LedgerMember, error) {
+	var members []models.LedgerMember
+	tx := d.DB.Model(models.LedgerMember{}).
+		Where("user_id = ?", userID).
+		Preload("Ledger").
+		Order("ledger_id").
+		Find(&members)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return members, nil
+}
+
+// Gets the user's membership of a ledger, ErrNotFound if they aren't in it
+func (d *SQLite) GetMember(ledgerID, userID uint) (models.LedgerMember, error) {
+	var member models.LedgerMember
+	tx := d.DB.Model(models.LedgerMember{}).
+		Where("ledger_id = ? AND user_id = ?", ledgerID, userID).
+		Preload("Ledger").
+		First(&member)
+	if tx.Error != nil {
+		return models.LedgerMember{}, tx.Error
+	}
+	return member, nil
+}
+
+// Gets everyone in a ledger with their usernames
+func (d *SQLite) GetMembers(ledgerID uint) ([]models.LedgerMember, error) {
+	var members []models.LedgerMember
+	tx := d.DB.Model(models.LedgerMember{}).
+		Where("ledger_id = ?", ledgerID).
+		Preload("User").
+		Order("id").
+		Find(&members)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return members, nil
+}
+
+// Creates a ledger with ownerID as its first owner and a main account
+func (d *SQLite) AddLedger(ledger models.Ledger, ownerID uint) (models.Ledger, error) {
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Omit("Members").Create(&ledger).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Omit("Ledger", "User").Create(&models.LedgerMember{
+			LedgerID: ledger.ID,
+			UserID:   ownerID,
+			Role:     models.RoleOwner,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return addMainAccount(tx, ledger.ID, d.currency)
+	})
+	if err != nil {
+		return models.Ledger{}, err
+	}
+	return ledger, nil
+}
+
+func (d *SQLite) AddMember(member models.LedgerMember) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(models.LedgerMember{}).
+			Where("ledger_id = ? AND user_id = ?", member.LedgerID, member.UserID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyMember
+		}
+
+		return tx.Omit("Ledger", "User").Create(&member).Error
+	})
+}
+
+// Changes a member's role, refusing with ErrLastOwner to demote the only owner
+func (d *SQLite) SetMemberRole(ledgerID, userID uint, role string) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := keepsAnOwner(tx, ledgerID, userID, role)
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(models.LedgerMember{}).
+			Where("ledger_id = ? AND user_id = ?", ledgerID, userID).
+			Update("role", role).Error
+	})
+}
+
+// Removes someone from a ledger, refusing with ErrLastOwner to remove the only
+// owner. The records they entered stay in the ledger.
+func (d *SQLite) RemoveMember(ledgerID, userID uint) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := keepsAnOwner(tx, ledgerID, userID, "")
+		if err != nil {
+			return err
+		}
+
+		// removed outright so they can be invited back
+		return tx.Unscoped().
+			Where("ledger_id = ? AND user_id = ?", ledgerID, userID).
+			Delete(&models.LedgerMember{}).Error
+	})
+}
+
+// keepsAnOwner checks the ledger still has an owner once userID's role becomes
+// role, an empty role meaning they are leaving. It returns ErrNotFound if they
+// aren't a member.
+func keepsAnOwner(tx *gorm.DB, ledgerID, userID uint, role string) error {
+	var member models.LedgerMember
+	err := tx.Model(models.LedgerMember{}).
+		Where("ledger_id = ? AND user_id = ?", ledgerID, userID).
+		First(&member).Error
+	if err != nil {
+		return err
+	}
+	if !member.IsOwner() || role == models.RoleOwner {
+		return nil
+	}
+
+	var owners int64
+	err = tx.Model(models.LedgerMember{}).
+		Where("ledger_id = ? AND role = ?", ledgerID, models.RoleOwner).
+		Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners < 2 {
+		return ErrLastOwner
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var ErrLoanPaidOff = errors.New("loan is paid off")
+
+// withLoan loads a loan's accounts, category and payments. A payment only
+// counts while its transfer does, so deleting the transfer takes it back.
+// Payments without a transfer count while their expense does, and restoring
+// it from the trash pays them again.
+func withLoan(tx *gorm.DB) *gorm.DB {
+	ids := func(model interface{}) *gorm.DB {
+		return tx.Session(&gorm.Session{NewDB: true}).Model(model).Select("id")
+	}
+	return tx.Preload("Account").Preload("Category").Preload("LiabilityAccount").
+		Preload("Payments", func(db *gorm.DB) *gorm.DB {
+			return db.Where("transfer_id IN (?) OR (transfer_id = 0 AND expense_id IN (?))", ids(models.Transfer{}), ids(models.Expense{})).
+				Order("number")
+		})
+}
+
+// seedLoanAccounts opens an account for each loan from before loans had one,
+// owing what was still outstanding, and marks their payments as having no
+// transfer
+func seedLoanAccounts(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("UPDATE loan_payments SET transfer_id = 0 WHERE transfer_id IS NULL").Error
+		if err != nil {
+			return err
+		}
+
+		var loans []models.Loan
+		err = withLoan(tx.Model(models.Loan{})).
+			Where("liability_account_id IS NULL OR liability_account_id = 0").
+			Find(&loans).Error
+		if err != nil {
+			return err
+		}
+		for _, loan := range loans {
+			account, err := addLoanAccount(tx, loan, loan.Progress(time.Now()).Outstanding)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&loan).Update("liability_account_id", account.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addLoanAccount opens the account a loan's principal is paid off into, in
+// the loan's ledger, owing owed
+func addLoanAccount(tx *gorm.DB, loan models.Loan, owed money.Money) (models.Account, error) {
+	account := models.Account{
+		LedgerID:       loan.LedgerID,
+		Name:           loan.Name,
+		Type:           models.AccountTypeLoan,
+		OpeningBalance: owed.Neg(),
+	}
+	err := tx.Model(models.Account{}).Create(&account).Error
+	if err != nil {
+		return models.Account{}, err
+	}
+	return account, postJournal(tx, models.JournalOpening, account.ID)
+}
+
+func (d *SQLite) GetLoans(ledgerID uint) ([]models.Loan, error) {
+	var loans []models.Loan
+	tx := withLoan(d.DB.Model(models.Loan{})).
+		Where("ledger_id = ?", ledgerID).
+		Order("name").
+		Find(&loans)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return loans, nil
+}
+
+func (d *SQLite) GetLoan(ledgerID uint, id int) (models.Loan, error) {
+	return d.loan(d.DB, ledgerID, id)
+}
+
+func (d *SQLite) loan(tx *gorm.DB, ledgerID uint, id int) (models.Loan, error) {
+	err := inLedger(tx, &models.Loan{}, ledgerID, id)
+	if err != nil {
+		return models.Loan{}, err
+	}
+
+	var loan models.Loan
+	err = withLoan(tx.Model(models.Loan{})).First(&loan, id).Error
+	if err != nil {
+		return models.Loan{}, err
+	}
+	return loan, nil
+}
+
+func (d *SQLite) AddLoan(loan models.Loan) error {
+	err := loan.Validate()
+	if err != nil {
+		return err
+	}
+
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		account, err := addLoanAccount(tx, loan, loan.Principal)
+		if err != nil {
+			return err
+		}
+		loan.LiabilityAccountID = account.ID
+		return tx.Omit("Account", "Category", "LiabilityAccount").Create(&loan).Error
+	})
+}
+
+// Deletes a loan and its schedule, the expenses and transfers its payments
+// posted and its account, with what is still owed, are kept
+func (d *SQLite) DeleteLoan(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Loan{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("loan_id = ?", id).Delete(&models.LoanPayment{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.Loan{}, id).Error
+	})
+}
+
+// Pays a loan's next instalment on the day on from the loan's account,
+// entered by userID. The interest is posted as an expense and the principal as
+// a transfer to the loan's own account, converted when the accounts' currencies
+// differ. It refuses with ErrLoanPaidOff once there is nothing left to pay.
+func (d *SQLite) PayLoan(ledgerID uint, id int, userID uint, on time.Time) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		loan, err := d.loan(tx, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		progress := loan.Progress(on)
+		next := progress.Next
+		if next == nil {
+			return ErrLoanPaidOff
+		}
+
+		payment := models.LoanPayment{
+			LoanID:    loan.ID,
+			Number:    next.Number,
+			Interest:  next.Interest,
+			Principal: next.Principal,
+		}
+		note := fmt.Sprintf("Payment %d of %d", next.Number, len(progress.Schedule))
+
+		if !next.Interest.IsZero() {
+			expense := models.Expense{
+				LedgerID:   loan.LedgerID,
+				UserID:     userID,
+				Amount:     next.Interest,
+				Source:     loan.Name,
+				Notes:      note + ": interest",
+				OccurredOn: on,
+				CategoryID: loan.CategoryID,
+				AccountID:  loan.AccountID,
+			}
+			err = tx.Model(models.Expense{}).Create(&expense).Error
+			if err != nil {
+				return err
+			}
+			err = postJournal(tx, models.JournalExpense, expense.ID)
+			if err != nil {
+				return err
+			}
+			err = d.record(tx, models.AuditCreate, models.AuditExpense, expense.ID, "")
+			if err != nil {
+				return err
+			}
+			payment.ExpenseID = expense.ID
+		}
+
+		if !next.Principal.IsZero() {
+			amount, err := rates.NewConverter(&SQLite{DB: tx}).Convert(next.Principal, loan.Account.Currency(), on)
+			if err != nil {
+				return err
+			}
+			transfer := models.Transfer{
+				LedgerID:       loan.LedgerID,
+				FromAccountID:  loan.AccountID,
+				ToAccountID:    loan.LiabilityAccountID,
+				Amount:         amount,
+				ReceivedAmount: next.Principal,
+				OccurredOn:     on,
+				Note:           note + ": principal",
+			}
+			err = tx.Model(models.Transfer{}).Omit("FromAccount", "ToAccount").Create(&transfer).Error
+			if err != nil {
+				return err
+			}
+			err = postJournal(tx, models.JournalTransfer, transfer.ID)
+			if err != nil {
+				return err
+			}
+			payment.TransferID = transfer.ID
+		}
+
+		return tx.Create(&payment).Error
+	})
+}
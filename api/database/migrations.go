@@ -0,0 +1,86 @@
+package database
+
+import (
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+// migrateLegacyAmounts converts the old free-text `amount` column on expenses
+// and incomes into minor units, then drops it. Rows that can't be parsed are
+// stored as zero with the original text added to their notes, so they stay
+// visible and can be fixed by hand once the column is gone.
+func migrateLegacyAmounts(db *gorm.DB, currency string) error {
+	for _, table := range []string{"expenses", "incomes"} {
+		if !db.Migrator().HasColumn(table, "amount") {
+			continue
+		}
+
+		var rows []struct {
+			ID     uint
+			Amount string
+			Notes  string
+		}
+		err := db.Table(table).Select("id", "amount", "notes").Scan(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, row := range rows {
+				updates := map[string]interface{}{}
+				amount, err := money.Parse(row.Amount, currency)
+				if err != nil {
+					log.Printf("migrating %s %d: %v, storing as zero and keeping %q in its notes", table, row.ID, err, row.Amount)
+					amount = money.New(0, currency)
+					updates["notes"] = strings.TrimSpace(row.Notes + "\nOriginal amount: " + row.Amount)
+				}
+				updates["amount_minor"] = amount.Minor
+				updates["amount_currency"] = amount.Currency
+
+				err = tx.Table(table).Where("id = ?", row.ID).Updates(updates).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return tx.Exec("ALTER TABLE ? DROP COLUMN amount", clause.Table{Name: table}).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// backfillOccurredOn gives rows created before transactions had their own date
+// the day they were entered.
+func backfillOccurredOn(db *gorm.DB) error {
+	for _, table := range []string{"expenses", "incomes"} {
+		err := db.Exec(
+			"UPDATE ? SET occurred_on = substr(created_at, 1, 10) || ' 00:00:00+00:00' WHERE occurred_on IS NULL",
+			clause.Table{Name: table},
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropLegacyBudgetIndex removes the unique index on category and period from
+// before budgets belonged to a ledger, which stops two ledgers budgeting for
+// the same category.
+func dropLegacyBudgetIndex(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&models.Budget{}, "idx_budget") {
+		return nil
+	}
+	return db.Migrator().DropIndex(&models.Budget{}, "idx_budget")
+}
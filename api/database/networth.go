@@ -0,0 +1,177 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+// Gets the ledger's manual assets and liabilities, assets first
+func (d *SQLite) GetAssets(ledgerID uint) ([]models.Asset, error) {
+	var assets []models.Asset
+	tx := d.DB.Model(models.Asset{}).Where("ledger_id = ?", ledgerID).Order("kind, name").Find(&assets)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return assets, nil
+}
+
+func (d *SQLite) GetAsset(ledgerID uint, id int) (models.Asset, error) {
+	err := inLedger(d.DB, &models.Asset{}, ledgerID, id)
+	if err != nil {
+		return models.Asset{}, err
+	}
+
+	var asset models.Asset
+	tx := d.DB.Model(models.Asset{}).First(&asset, id)
+	if tx.Error != nil {
+		return models.Asset{}, tx.Error
+	}
+	return asset, nil
+}
+
+func (d *SQLite) AddAsset(asset models.Asset) error {
+	err := asset.Validate()
+	if err != nil {
+		return err
+	}
+	return d.DB.Create(&asset).Error
+}
+
+// Sets what an asset or liability is worth as of the day on, the value must
+// be in the asset's currency
+func (d *SQLite) RevalueAsset(ledgerID uint, id int, value money.Money, on time.Time) error {
+	asset, err := d.GetAsset(ledgerID, id)
+	if err != nil {
+		return err
+	}
+	if value.Currency != asset.Value.Currency {
+		return fmt.Errorf("%w: value must be in %s", models.ErrInvalidAsset, asset.Value.Currency)
+	}
+	asset.Value, asset.ValuedOn = value, on
+	err = asset.Validate()
+	if err != nil {
+		return err
+	}
+	return d.DB.Model(&asset).Select("value_minor", "value_currency", "valued_on").Updates(&asset).Error
+}
+
+func (d *SQLite) DeleteAsset(ledgerID uint, id int) error {
+	err := inLedger(d.DB, &models.Asset{}, ledgerID, id)
+	if err != nil {
+		return err
+	}
+	return d.DB.Delete(&models.Asset{}, id).Error
+}
+
+// Works out the ledger's net worth on the day on in currency. Account balances
+// and loans are as of that day, investments are priced at the latest price
+// on or before it, and manual assets count at their current value.
+func (d *SQLite) GetNetWorth(ledgerID uint, currency string, on time.Time) (models.NetWorth, error) {
+	zero := money.New(0, currency)
+	worth := models.NetWorth{Date: on, Accounts: zero, Investments: zero, Assets: zero, Liabilities: zero, Total: zero}
+	conv := rates.NewConverter(d)
+
+	add := func(total *money.Money, m money.Money) {
+		converted, err := conv.Convert(m, currency, on)
+		if err != nil {
+			worth.Incomplete = true
+			return
+		}
+		*total, _ = total.Add(converted)
+	}
+
+	balances, err := d.GetAccountBalances(ledgerID, on)
+	if err != nil {
+		return models.NetWorth{}, err
+	}
+	for _, balance := range balances {
+		worth.Incomplete = worth.Incomplete || balance.Incomplete
+		// a loan's account owes what is left to pay as a negative balance
+		if balance.Account.Type == models.AccountTypeLoan {
+			add(&worth.Liabilities, balance.Balance.Neg())
+		} else {
+			add(&worth.Accounts, balance.Balance)
+		}
+	}
+
+	// buying and selling moves money in and out of the account, so an
+	// investment account's balance is only the cash held alongside its holdings
+	trades, err := d.GetTrades(ledgerID)
+	if err != nil {
+		return models.NetWorth{}, err
+	}
+	prices, err := d.GetPrices()
+	if err != nil {
+		return models.NetWorth{}, err
+	}
+	portfolio := models.ValuePortfolio(models.Holdings(trades, models.PriceHistory(prices), on), conv.Convert, currency, on)
+	worth.Investments = portfolio.MarketValue
+	worth.Incomplete = worth.Incomplete || portfolio.Incomplete
+
+	assets, err := d.GetAssets(ledgerID)
+	if err != nil {
+		return models.NetWorth{}, err
+	}
+	for _, asset := range assets {
+		if asset.Kind == models.AssetKindLiability {
+			add(&worth.Liabilities, asset.Value)
+		} else {
+			add(&worth.Assets, asset.Value)
+		}
+	}
+
+	worth.Total, err = money.Sum(currency, worth.Accounts, worth.Investments, worth.Assets, worth.Liabilities.Neg())
+	if err != nil {
+		return models.NetWorth{}, err
+	}
+	return worth, nil
+}
+
+// Records every ledger's net worth on the day on in currency, replacing any
+// snapshot already taken that day. It returns how many were recorded and keeps
+// going past a ledger that fails.
+func (d *SQLite) SnapshotNetWorth(currency string, on time.Time) (int, error) {
+	var ledgerIDs []uint
+	err := d.DB.Model(models.Ledger{}).Pluck("id", &ledgerIDs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	taken := 0
+	var errs []error
+	for _, ledgerID := range ledgerIDs {
+		worth, err := d.GetNetWorth(ledgerID, currency, on)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ledger %d: %w", ledgerID, err))
+			continue
+		}
+
+		err = d.DB.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "ledger_id"}, {Name: "date"}},
+			UpdateAll: true,
+		}).Create(&models.NetWorthSnapshot{LedgerID: ledgerID, NetWorth: worth}).Error
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ledger %d: %w", ledgerID, err))
+			continue
+		}
+		taken++
+	}
+	return taken, errors.Join(errs...)
+}
+
+// Gets the ledger's net worth snapshots, oldest first
+func (d *SQLite) GetNetWorthSnapshots(ledgerID uint) ([]models.NetWorthSnapshot, error) {
+	var snapshots []models.NetWorthSnapshot
+	tx := d.DB.Model(models.NetWorthSnapshot{}).Where("ledger_id = ?", ledgerID).Order("date").Find(&snapshots)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// ErrOtherLedger is returned when a record exists but belongs to another
+// ledger. Handlers should treat it like ErrNotFound so nothing leaks, but log it.
+var ErrOtherLedger = errors.New("record belongs to another ledger")
+
+// inLedger checks the row with id in model's table belongs to ledgerID. model
+// is a pointer to an empty model with a LedgerID, e.g. &models.Expense{}.
+func inLedger(tx *gorm.DB, model interface{}, ledgerID uint, id int) error {
+	var row struct{ LedgerID uint }
+	res := tx.Model(model).Select("ledger_id").Where("id = ?", id).Limit(1).Scan(&row)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	if row.LedgerID != ledgerID {
+		return ErrOtherLedger
+	}
+	return nil
+}
+
+// claimUnownedTransactions gives transactions entered before they had an owner
+// to the first user. It runs on every start, so rows from before any user
+// existed are picked up once one has been created.
+func claimUnownedTransactions(db *gorm.DB) error {
+	var first models.User
+	res := db.Model(models.User{}).Order("id").Limit(1).Find(&first)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+
+	for _, table := range []string{"expenses", "incomes", "recurrings"} {
+		err := db.Exec(
+			"UPDATE ? SET user_id = ? WHERE user_id IS NULL OR user_id = 0",
+			clause.Table{Name: table}, first.ID,
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
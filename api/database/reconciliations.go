@@ -0,0 +1,469 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var (
+	// ErrReconciled is returned when changing a transaction that is part of a
+	// finished reconciliation, it has to be un-reconciled first
+	ErrReconciled             = errors.New("transaction is reconciled")
+	ErrNotReconciled          = errors.New("transaction isn't reconciled")
+	ErrReconciliationOpen     = errors.New("account already has an open reconciliation")
+	ErrReconciliationFinished = errors.New("reconciliation is finished")
+	ErrReconciliationOff      = errors.New("cleared balance doesn't match the statement")
+)
+
+// notReconciled refuses with ErrReconciled when the Expense or Income with id
+// in model's table has been reconciled
+func notReconciled(tx *gorm.DB, model interface{}, id int) error {
+	var count int64
+	err := tx.Model(model).Where("id = ? AND status = ?", id, models.StatusReconciled).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrReconciled
+	}
+	return nil
+}
+
+// Gets the ledger's reconciliations, newest statement first
+func (d *SQLite) GetReconciliations(ledgerID uint) ([]models.Reconciliation, error) {
+	var reconciliations []models.Reconciliation
+	tx := d.DB.Model(models.Reconciliation{}).
+		Where("ledger_id = ?", ledgerID).
+		Preload("Account").
+		Order("statement_date desc, id desc").
+		Find(&reconciliations)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return reconciliations, nil
+}
+
+// Starts reconciling an account against a statement, refusing with
+// ErrReconciliationOpen while the ledger has another open on the account
+func (d *SQLite) StartReconciliation(reconciliation models.Reconciliation) (models.Reconciliation, error) {
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(models.Reconciliation{}).
+			Where("ledger_id = ? AND account_id = ? AND finished_at IS NULL", reconciliation.LedgerID, reconciliation.AccountID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrReconciliationOpen
+		}
+		return tx.Omit("Account").Create(&reconciliation).Error
+	})
+	if err != nil {
+		return models.Reconciliation{}, err
+	}
+	return reconciliation, nil
+}
+
+// Gets a reconciliation with its balances and, while it's open, the lines up
+// to the statement date still to be reconciled, oldest first
+func (d *SQLite) GetReconciliation(ledgerID uint, id int) (models.ReconciliationProgress, error) {
+	return d.progress(d.DB, ledgerID, id)
+}
+
+// progress works out GetReconciliation in tx
+func (d *SQLite) progress(tx *gorm.DB, ledgerID uint, id int) (models.ReconciliationProgress, error) {
+	reconciliation, err := d.reconciliation(tx, ledgerID, id)
+	if err != nil {
+		return models.ReconciliationProgress{}, err
+	}
+
+	account := reconciliation.Account
+	progress := models.ReconciliationProgress{Reconciliation: reconciliation}
+	if reconciliation.FinishedAt != nil {
+		progress.Reconciled = reconciliation.ClosingBalance
+		progress.Cleared = reconciliation.ClosingBalance
+		progress.Difference = money.New(0, account.Currency())
+		return progress, nil
+	}
+
+	conv := rates.NewConverter(&SQLite{DB: tx})
+	convert := func(lines []models.ReconcileLine) []models.ReconcileLine {
+		converted := make([]models.ReconcileLine, 0, len(lines))
+		for _, line := range lines {
+			amount, err := conv.Convert(line.Amount, account.Currency(), line.OccurredOn)
+			if err != nil {
+				progress.Incomplete = true
+				continue
+			}
+			line.Amount = amount
+			converted = append(converted, line)
+		}
+		return converted
+	}
+
+	reconciled, err := reconcileLines(tx, ledgerID, account.ID, time.Time{}, models.StatusReconciled)
+	if err != nil {
+		return models.ReconciliationProgress{}, err
+	}
+	progress.Reconciled = account.OpeningBalance
+	for _, line := range convert(reconciled) {
+		progress.Reconciled, err = progress.Reconciled.Add(line.Amount)
+		if err != nil {
+			return models.ReconciliationProgress{}, err
+		}
+	}
+
+	open, err := reconcileLines(tx, ledgerID, account.ID, reconciliation.StatementDate, models.StatusPending, models.StatusCleared)
+	if err != nil {
+		return models.ReconciliationProgress{}, err
+	}
+	progress.Lines = convert(open)
+	progress.Cleared = progress.Reconciled
+	for _, line := range progress.Lines {
+		if line.Status != models.StatusCleared {
+			continue
+		}
+		progress.Cleared, err = progress.Cleared.Add(line.Amount)
+		if err != nil {
+			return models.ReconciliationProgress{}, err
+		}
+	}
+
+	progress.Difference, err = reconciliation.ClosingBalance.Sub(progress.Cleared)
+	if err != nil {
+		return models.ReconciliationProgress{}, err
+	}
+	return progress, nil
+}
+
+// reconciliation loads one of the ledger's reconciliations with its account
+func (d *SQLite) reconciliation(tx *gorm.DB, ledgerID uint, id int) (models.Reconciliation, error) {
+	err := inLedger(tx, &models.Reconciliation{}, ledgerID, id)
+	if err != nil {
+		return models.Reconciliation{}, err
+	}
+
+	var reconciliation models.Reconciliation
+	err = tx.Model(models.Reconciliation{}).Preload("Account").First(&reconciliation, id).Error
+	if err != nil {
+		return models.Reconciliation{}, err
+	}
+	return reconciliation, nil
+}
+
+// reconcileLines are the lines on the account with one of statuses, in the
+// currency they were entered in. A zero to doesn't limit the date.
+func reconcileLines(tx *gorm.DB, ledgerID, accountID uint, to time.Time, statuses ...string) ([]models.ReconcileLine, error) {
+	dated := func(q *gorm.DB) *gorm.DB {
+		if to.IsZero() {
+			return q
+		}
+		return q.Where("occurred_on <= ?", to.UTC())
+	}
+
+	var lines []models.ReconcileLine
+	var expenses []models.Expense
+	err := dated(tx.Model(models.Expense{}).Where("ledger_id = ? AND account_id = ? AND status IN ?", ledgerID, accountID, statuses)).
+		Find(&expenses).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range expenses {
+		lines = append(lines, models.ReconcileLine{Kind: models.JournalExpense, ID: e.ID, OccurredOn: e.OccurredOn, Description: e.Source, Amount: e.Amount.Neg(), Status: e.Status})
+	}
+
+	var incomes []models.Income
+	err = dated(tx.Model(models.Income{}).Where("ledger_id = ? AND account_id = ? AND status IN ?", ledgerID, accountID, statuses)).
+		Find(&incomes).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range incomes {
+		lines = append(lines, models.ReconcileLine{Kind: models.JournalIncome, ID: i.ID, OccurredOn: i.OccurredOn, Description: i.Source, Amount: i.Amount, Status: i.Status})
+	}
+
+	var transfers []models.Transfer
+	err = dated(tx.Model(models.Transfer{}).Where(
+		"(from_account_id = ? AND from_status IN ?) OR (to_account_id = ? AND to_status IN ?)",
+		accountID, statuses, accountID, statuses,
+	)).Find(&transfers).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range transfers {
+		line := models.ReconcileLine{Kind: models.JournalTransfer, ID: t.ID, OccurredOn: t.OccurredOn, Description: t.Note}
+		if t.FromAccountID == accountID {
+			line.Amount, line.Status = t.Amount.Neg(), t.FromStatus
+		} else {
+			line.Amount, line.Status = t.ReceivedAmount, t.ToStatus
+		}
+		if line.Description == "" {
+			line.Description = "Transfer"
+		}
+		lines = append(lines, line)
+	}
+
+	// dividends are reconciled as their incomes
+	var trades []models.Trade
+	q := tx.Model(models.Trade{}).Preload("Security").
+		Where("ledger_id = ? AND account_id = ? AND kind IN ? AND status IN ?", ledgerID, accountID, []string{models.TradeBuy, models.TradeSell}, statuses)
+	if !to.IsZero() {
+		q = q.Where("traded_on <= ?", to.UTC())
+	}
+	err = q.Find(&trades).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range trades {
+		amount := t.Amount.Neg()
+		if t.Kind == models.TradeSell {
+			amount = t.Amount
+		}
+		lines = append(lines, models.ReconcileLine{Kind: models.JournalTrade, ID: t.ID, OccurredOn: t.TradedOn, Description: t.Kind + " " + t.Security.Symbol, Amount: amount, Status: t.Status})
+	}
+
+	// oldest first, keeping kinds together on a day
+	slices.SortStableFunc(lines, func(a, b models.ReconcileLine) int {
+		return a.OccurredOn.Compare(b.OccurredOn)
+	})
+	return lines, nil
+}
+
+// Ticks a line off as cleared in an open reconciliation, or back to pending
+// when cleared is false. The line is the income, expense, transfer or trade
+// with lineID, which must be on the reconciliation's account.
+func (d *SQLite) SetCleared(ledgerID uint, id int, kind string, lineID int, cleared bool) error {
+	status := models.StatusPending
+	if cleared {
+		status = models.StatusCleared
+	}
+
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		reconciliation, err := d.reconciliation(tx, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		if reconciliation.FinishedAt != nil {
+			return ErrReconciliationFinished
+		}
+		return d.setStatus(tx, ledgerID, reconciliation.AccountID, kind, lineID, status)
+	})
+}
+
+// setStatus changes the status of a line on the account, refusing with
+// ErrReconciled once it's reconciled. Incomes and expenses must be in the
+// ledger, the change to them is recorded in the audit log.
+func (d *SQLite) setStatus(tx *gorm.DB, ledgerID, accountID uint, kind string, lineID int, status string) error {
+	if kind == models.JournalTransfer {
+		var transfer models.Transfer
+		err := tx.Model(models.Transfer{}).
+			Where("from_account_id = ? OR to_account_id = ?", accountID, accountID).
+			First(&transfer, lineID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		column, current := "to_status", transfer.ToStatus
+		if transfer.FromAccountID == accountID {
+			column, current = "from_status", transfer.FromStatus
+		}
+		if current == models.StatusReconciled {
+			return ErrReconciled
+		}
+		return tx.Model(models.Transfer{}).Where("id = ?", lineID).Update(column, status).Error
+	}
+
+	if kind == models.JournalTrade {
+		var trade models.Trade
+		err := tx.Model(models.Trade{}).
+			Where("ledger_id = ? AND account_id = ? AND kind IN ?", ledgerID, accountID, []string{models.TradeBuy, models.TradeSell}).
+			First(&trade, lineID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if trade.Status == models.StatusReconciled {
+			return ErrReconciled
+		}
+		return tx.Model(models.Trade{}).Where("id = ?", lineID).Update("status", status).Error
+	}
+
+	var model interface{} = &models.Income{}
+	if kind == models.JournalExpense {
+		model = &models.Expense{}
+	}
+	err := inLedger(tx, model, ledgerID, lineID)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(model).Where("account_id = ?", accountID).First(model, lineID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = notReconciled(tx, model, lineID)
+	if err != nil {
+		return err
+	}
+
+	entity := entityOf(model)
+	before, err := snapshot(tx, entity, uint(lineID))
+	if err != nil {
+		return err
+	}
+	err = tx.Model(model).Where("id = ?", lineID).Update("status", status).Error
+	if err != nil {
+		return err
+	}
+	return d.record(tx, models.AuditUpdate, entity, uint(lineID), before)
+}
+
+// Finishes an open reconciliation, refusing with ErrReconciliationOff unless
+// the cleared balance matches the statement. Every cleared line up to the
+// statement date becomes reconciled and is locked from edits.
+func (d *SQLite) FinishReconciliation(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		progress, err := d.progress(tx, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		if progress.Reconciliation.FinishedAt != nil {
+			return ErrReconciliationFinished
+		}
+		if !progress.Difference.IsZero() {
+			return ErrReconciliationOff
+		}
+
+		reconciliation := progress.Reconciliation
+		lines, err := reconcileLines(tx, ledgerID, reconciliation.AccountID, reconciliation.StatementDate, models.StatusCleared)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			err = d.setStatus(tx, ledgerID, reconciliation.AccountID, line.Kind, int(line.ID), models.StatusReconciled)
+			if err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		return tx.Model(models.Reconciliation{}).Where("id = ?", reconciliation.ID).Update("finished_at", &now).Error
+	})
+}
+
+// Drops an open reconciliation, lines already ticked off stay cleared for the next one
+func (d *SQLite) CancelReconciliation(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		reconciliation, err := d.reconciliation(tx, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		if reconciliation.FinishedAt != nil {
+			return ErrReconciliationFinished
+		}
+		return tx.Unscoped().Delete(&models.Reconciliation{}, id).Error
+	})
+}
+
+// Unlocks a reconciled Expense so it can be changed again, it goes back to cleared
+func (d *SQLite) UnreconcileExpense(ledgerID uint, id int) error {
+	return d.unreconcile(&models.Expense{}, ledgerID, id)
+}
+
+// Unlocks a reconciled Income so it can be changed again, it goes back to cleared
+func (d *SQLite) UnreconcileIncome(ledgerID uint, id int) error {
+	return d.unreconcile(&models.Income{}, ledgerID, id)
+}
+
+func (d *SQLite) unreconcile(model interface{}, ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, model, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = notReconciled(tx, model, id)
+		if err == nil {
+			return ErrNotReconciled
+		}
+		if !errors.Is(err, ErrReconciled) {
+			return err
+		}
+
+		entity := entityOf(model)
+		before, err := snapshot(tx, entity, uint(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model).Where("id = ?", id).Update("status", models.StatusCleared).Error
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, entity, uint(id), before)
+	})
+}
+
+// Unlocks both sides of a reconciled Transfer, they go back to cleared
+func (d *SQLite) UnreconcileTransfer(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Transfer{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		var transfer models.Transfer
+		err = tx.Model(models.Transfer{}).First(&transfer, id).Error
+		if err != nil {
+			return err
+		}
+		if transfer.FromStatus != models.StatusReconciled && transfer.ToStatus != models.StatusReconciled {
+			return ErrNotReconciled
+		}
+
+		before, err := snapshot(tx, models.AuditTransfer, uint(id))
+		if err != nil {
+			return err
+		}
+		for _, column := range []string{"from_status", "to_status"} {
+			err = tx.Model(models.Transfer{}).
+				Where("id = ? AND "+column+" = ?", id, models.StatusReconciled).
+				Update(column, models.StatusCleared).Error
+			if err != nil {
+				return err
+			}
+		}
+		return d.record(tx, models.AuditUpdate, models.AuditTransfer, uint(id), before)
+	})
+}
+
+// Unlocks a reconciled buy or sell so it can be deleted, it goes back to cleared
+func (d *SQLite) UnreconcileTrade(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, &models.Trade{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		updated := tx.Model(models.Trade{}).
+			Where("id = ? AND status = ?", id, models.StatusReconciled).
+			Update("status", models.StatusCleared)
+		if updated.Error != nil {
+			return updated.Error
+		}
+		if updated.RowsAffected == 0 {
+			return ErrNotReconciled
+		}
+		return nil
+	})
+}
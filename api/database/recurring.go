@@ -0,0 +1,131 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// Gets every one of the ledger's recurring templates, soonest first
+func (d *SQLite) GetRecurring(ledgerID uint) ([]models.Recurring, error) {
+	var recurring []models.Recurring
+	tx := d.DB.Model(models.Recurring{}).
+		Where("ledger_id = ?", ledgerID).
+		Preload("Category").Preload("Account").
+		Order("next_on IS NULL, next_on, source").
+		Find(&recurring)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return recurring, nil
+}
+
+// Gets the templates with an occurrence due on or before on
+func (d *SQLite) GetDueRecurring(on time.Time) ([]models.Recurring, error) {
+	var recurring []models.Recurring
+	tx := d.DB.Model(models.Recurring{}).
+		Where("next_on IS NOT NULL AND next_on <= ?", on).
+		Order("next_on").
+		Find(&recurring)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return recurring, nil
+}
+
+func (d *SQLite) AddRecurring(recurring models.Recurring) error {
+	tx := d.DB.Omit("Category", "Account").Create(&recurring)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Stops a template, transactions it already posted are kept
+func (d *SQLite) DeleteRecurring(ledgerID uint, id int) error {
+	err := inLedger(d.DB, &models.Recurring{}, ledgerID, id)
+	if err != nil {
+		return err
+	}
+
+	tx := d.DB.Delete(&models.Recurring{}, id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// PostRecurring creates the transaction for the occurrence on, with the
+// ledger's rules run over it, and moves the template on to next in one
+// database transaction. The move is conditional on next_on still being on, so
+// an occurrence is never posted twice.
+func (d *SQLite) PostRecurring(recurring models.Recurring, on time.Time, next *time.Time) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		moved := tx.Model(models.Recurring{}).
+			Where("id = ? AND next_on = ?", recurring.ID, on).
+			Update("next_on", next)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		if moved.RowsAffected == 0 {
+			return nil
+		}
+
+		ruled, err := applyRules(tx, recurring.LedgerID, recurring.Kind, ruleTarget{
+			Source:     recurring.Source,
+			Amount:     recurring.Amount,
+			AccountID:  recurring.AccountID,
+			CategoryID: recurring.CategoryID,
+		})
+		if err != nil {
+			return err
+		}
+		tags, err := findOrCreateTags(tx, withTags(nil, ruled.Tags))
+		if err != nil {
+			return err
+		}
+
+		if recurring.Kind == models.CategoryKindIncome {
+			income := models.Income{
+				LedgerID:   recurring.LedgerID,
+				UserID:     recurring.UserID,
+				Amount:     recurring.Amount,
+				Source:     ruled.Source,
+				OccurredOn: on,
+				CategoryID: ruled.CategoryID,
+				Tags:       tags,
+				AccountID:  recurring.AccountID,
+			}
+			err = tx.Create(&income).Error
+			if err != nil {
+				return err
+			}
+			err = postJournal(tx, models.JournalIncome, income.ID)
+			if err != nil {
+				return err
+			}
+			return d.record(tx, models.AuditCreate, models.AuditIncome, income.ID, "")
+		}
+		expense := models.Expense{
+			LedgerID:   recurring.LedgerID,
+			UserID:     recurring.UserID,
+			Amount:     recurring.Amount,
+			Source:     ruled.Source,
+			OccurredOn: on,
+			CategoryID: ruled.CategoryID,
+			Tags:       tags,
+			AccountID:  recurring.AccountID,
+		}
+		err = tx.Create(&expense).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, models.JournalExpense, expense.ID)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditCreate, models.AuditExpense, expense.ID, "")
+	})
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"slices"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+// Gets the ledger's rules, expenses first, each kind in the order they run
+func (d *SQLite) GetRules(ledgerID uint) ([]models.Rule, error) {
+	var rules []models.Rule
+	tx := d.DB.Model(models.Rule{}).
+		Where("ledger_id = ?", ledgerID).
+		Preload("Category").Preload("Account").
+		Order("kind, priority, id").
+		Find(&rules)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return rules, nil
+}
+
+func (d *SQLite) GetRule(ledgerID uint, id int) (models.Rule, error) {
+	err := inLedger(d.DB, &models.Rule{}, ledgerID, id)
+	if err != nil {
+		return models.Rule{}, err
+	}
+
+	var rule models.Rule
+	tx := d.DB.Model(models.Rule{}).Preload("Category").Preload("Account").First(&rule, id)
+	if tx.Error != nil {
+		return models.Rule{}, tx.Error
+	}
+	return rule, nil
+}
+
+func (d *SQLite) AddRule(rule models.Rule) error {
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+
+	tx := d.DB.Omit("Category", "Account").Create(&rule)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Replaces the conditions and actions of a rule in rule.LedgerID
+func (d *SQLite) UpdateRule(rule models.Rule) error {
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+	err = inLedger(d.DB, &models.Rule{}, rule.LedgerID, int(rule.ID))
+	if err != nil {
+		return err
+	}
+
+	tx := d.DB.Model(&models.Rule{}).
+		Where("id = ?", rule.ID).
+		Updates(map[string]interface{}{
+			"kind":                rule.Kind,
+			"priority":            rule.Priority,
+			"match":               rule.Match,
+			"pattern":             rule.Pattern,
+			"min_amount_minor":    rule.MinAmount.Minor,
+			"min_amount_currency": rule.MinAmount.Currency,
+			"max_amount_minor":    rule.MaxAmount.Minor,
+			"max_amount_currency": rule.MaxAmount.Currency,
+			"account_id":          rule.AccountID,
+			"rename_to":           rule.RenameTo,
+			"category_id":         rule.CategoryID,
+			"tag":                 rule.Tag,
+		})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// Rules are removed for good, what they already changed is kept
+func (d *SQLite) DeleteRule(ledgerID uint, id int) error {
+	err := inLedger(d.DB, &models.Rule{}, ledgerID, id)
+	if err != nil {
+		return err
+	}
+
+	tx := d.DB.Unscoped().Delete(&models.Rule{}, id)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	return nil
+}
+
+// ledgerRules are the ledger's rules for kind in the order they run
+func ledgerRules(tx *gorm.DB, ledgerID uint, kind string) ([]models.Rule, error) {
+	var rules []models.Rule
+	err := tx.Model(models.Rule{}).
+		Where("ledger_id = ? AND kind = ?", ledgerID, kind).
+		Order("priority, id").
+		Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ruleTarget is the part of an income or expense the rules look at and change
+type ruleTarget struct {
+	ID         uint
+	Source     string
+	Amount     money.Money
+	AccountID  uint
+	CategoryID uint
+	Tags       []string
+}
+
+// ruleChanges works out what the rules do to t, returning the changed target
+// and whether anything changed. A rule's category only replaces fallbackID,
+// the kind's Uncategorised, so a category someone picked is never overridden.
+func ruleChanges(rules []models.Rule, kind string, fallbackID uint, t ruleTarget) (ruleTarget, bool) {
+	out := models.ApplyRules(rules, kind, t.Source, t.Amount, t.AccountID)
+
+	changed := t
+	changed.Tags = nil
+	if out.CategoryID != 0 && (t.CategoryID == 0 || t.CategoryID == fallbackID) {
+		changed.CategoryID = out.CategoryID
+	}
+	changed.Source = out.Source
+	for _, tag := range out.Tags {
+		if !slices.Contains(t.Tags, tag) {
+			changed.Tags = append(changed.Tags, tag)
+		}
+	}
+	return changed, changed.Source != t.Source || changed.CategoryID != t.CategoryID || len(changed.Tags) > 0
+}
+
+// applyRules runs the ledger's rules over a transaction about to be created,
+// returning its source and category after them and any tags they add
+func applyRules(tx *gorm.DB, ledgerID uint, kind string, t ruleTarget) (ruleTarget, error) {
+	rules, err := ledgerRules(tx, ledgerID, kind)
+	if err != nil || len(rules) == 0 {
+		return ruleTarget{Source: t.Source, CategoryID: t.CategoryID}, err
+	}
+	fallback, err := uncategorised(tx, kind)
+	if err != nil {
+		return ruleTarget{}, err
+	}
+
+	changed, _ := ruleChanges(rules, kind, fallback.ID, t)
+	return changed, nil
+}
+
+// withTags adds the tags named in names that aren't already in tags
+func withTags(tags []models.Tag, names []string) []models.Tag {
+	for _, name := range names {
+		if !slices.ContainsFunc(tags, func(tag models.Tag) bool { return tag.Name == name }) {
+			tags = append(tags, models.Tag{Name: name})
+		}
+	}
+	return tags
+}
+
+// Runs the ledger's rules over every income and expense already entered,
+// recording each change in the audit log, and returns how many were changed.
+// It's all or nothing, so a failure part way leaves history as it was.
+func (d *SQLite) ReapplyRules(ledgerID uint) (int, error) {
+	changed := 0
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		changed = 0
+		for _, kind := range []string{models.CategoryKindExpense, models.CategoryKindIncome} {
+			rules, err := ledgerRules(tx, ledgerID, kind)
+			if err != nil {
+				return err
+			}
+			if len(rules) == 0 {
+				continue
+			}
+			fallback, err := uncategorised(tx, kind)
+			if err != nil {
+				return err
+			}
+
+			targets, err := ruleTargets(tx, ledgerID, kind)
+			if err != nil {
+				return err
+			}
+			for _, t := range targets {
+				after, ok := ruleChanges(rules, kind, fallback.ID, t)
+				if !ok {
+					continue
+				}
+				err = d.applyRuleChange(tx, kind, t, after)
+				if err != nil {
+					return err
+				}
+				changed++
+			}
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// ruleTargets loads the ledger's incomes or expenses for the rules to look at,
+// leaving out reconciled ones as they're locked from edits
+func ruleTargets(tx *gorm.DB, ledgerID uint, kind string) ([]ruleTarget, error) {
+	var targets []ruleTarget
+	if kind == models.CategoryKindExpense {
+		var expenses []models.Expense
+		err := tx.Model(models.Expense{}).Where("ledger_id = ? AND status != ?", ledgerID, models.StatusReconciled).Preload("Tags").Order("id").Find(&expenses).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range expenses {
+			targets = append(targets, ruleTarget{e.ID, e.Source, e.Amount, e.AccountID, e.CategoryID, tagNames(e.Tags)})
+		}
+		return targets, nil
+	}
+
+	var incomes []models.Income
+	err := tx.Model(models.Income{}).Where("ledger_id = ? AND status != ?", ledgerID, models.StatusReconciled).Preload("Tags").Order("id").Find(&incomes).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range incomes {
+		targets = append(targets, ruleTarget{i.ID, i.Source, i.Amount, i.AccountID, i.CategoryID, tagNames(i.Tags)})
+	}
+	return targets, nil
+}
+
+func tagNames(tags []models.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+// applyRuleChange saves what ruleChanges made of an existing income or
+// expense, after.Tags being the ones to add
+func (d *SQLite) applyRuleChange(tx *gorm.DB, kind string, before, after ruleTarget) error {
+	var model interface{} = &models.Income{}
+	if kind == models.CategoryKindExpense {
+		model = &models.Expense{}
+	}
+	entity := entityOf(model)
+
+	snap, err := snapshot(tx, entity, before.ID)
+	if err != nil {
+		return err
+	}
+	err = tx.Model(model).
+		Where("id = ?", before.ID).
+		Updates(map[string]interface{}{"source": after.Source, "category_id": after.CategoryID}).
+		Error
+	if err != nil {
+		return err
+	}
+
+	if len(after.Tags) > 0 {
+		tags, err := findOrCreateTags(tx, withTags(nil, after.Tags))
+		if err != nil {
+			return err
+		}
+		err = tx.First(model, before.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model).Association("Tags").Append(tags)
+		if err != nil {
+			return err
+		}
+	}
+	err = postJournal(tx, journalSourceOf(model), before.ID)
+	if err != nil {
+		return err
+	}
+	return d.record(tx, models.AuditUpdate, entity, before.ID, snap)
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// ErrSearchUnavailable is returned by Search when the app was built without
+// SQLite's FTS5 module, which needs the sqlite_fts5 build tag
+var ErrSearchUnavailable = errors.New("search needs a build with the sqlite_fts5 tag")
+
+// snippets from Search wrap the matched words in these, so they can be
+// highlighted once the rest of the text is escaped
+const (
+	SearchMatchStart = "\x02"
+	SearchMatchEnd   = "\x03"
+)
+
+// searchSources are the tables mirrored into transaction_search, with the
+// many2many table linking each to its tags
+var searchSources = []struct {
+	kind, table, tagTable, tagColumn string
+}{
+	{models.CategoryKindExpense, "expenses", "expense_tags", "expense_id"},
+	{models.CategoryKindIncome, "incomes", "income_tags", "income_id"},
+}
+
+// searchAvailable reports whether SQLite was built with FTS5. Without it the
+// search triggers are dropped before anything else runs, so migrations never
+// write through triggers on a table this build can't open.
+func searchAvailable(db *gorm.DB) (bool, error) {
+	var fts5 bool
+	err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error
+	if err != nil {
+		return false, err
+	}
+	if fts5 {
+		return true, nil
+	}
+
+	log.Print("SQLite was built without FTS5, search is turned off")
+	for _, src := range searchSources {
+		for _, name := range searchTriggerNames(src.table, src.tagTable) {
+			err := db.Exec("DROP TRIGGER IF EXISTS " + name).Error
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// createSearchIndex sets up the transaction_search full-text table and the
+// triggers that keep it in step with every write. The table is only filled
+// when it's created or a trigger was missing, as when a build without FTS5
+// has run since, or a migration rebuilt one of the tables, since rows may have
+// changed while it wasn't kept up. Otherwise the index is left as it is, so
+// opening the database again is cheap. It needs FTS5, see searchAvailable.
+func createSearchIndex(db *gorm.DB) error {
+	var triggers []string
+	for _, src := range searchSources {
+		triggers = append(triggers, searchTriggerNames(src.table, src.tagTable)...)
+	}
+	var found int64
+	err := db.Raw(
+		"SELECT COUNT(*) FROM sqlite_master WHERE (type = 'table' AND name = 'transaction_search') OR (type = 'trigger' AND name IN ?)",
+		triggers,
+	).Scan(&found).Error
+	if err != nil {
+		return err
+	}
+	if found == int64(len(triggers))+1 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(
+			"CREATE VIRTUAL TABLE IF NOT EXISTS transaction_search USING fts5(" +
+				"source, notes, tags, kind UNINDEXED, transaction_id UNINDEXED, ledger_id UNINDEXED, " +
+				"tokenize = 'porter unicode61')",
+		).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("DELETE FROM transaction_search").Error
+		if err != nil {
+			return err
+		}
+
+		for _, src := range searchSources {
+			err = tx.Exec(searchRefresh(src.kind, src.table, src.tagTable, src.tagColumn, "")).Error
+			if err != nil {
+				return err
+			}
+
+			names := searchTriggerNames(src.table, src.tagTable)
+			triggers := []string{
+				"CREATE TRIGGER IF NOT EXISTS " + names[0] + " AFTER INSERT ON " + src.table + " BEGIN " +
+					searchRefresh(src.kind, src.table, src.tagTable, src.tagColumn, "NEW.id") + "; END",
+				"CREATE TRIGGER IF NOT EXISTS " + names[1] + " AFTER UPDATE ON " + src.table + " BEGIN " +
+					searchRefresh(src.kind, src.table, src.tagTable, src.tagColumn, "NEW.id") + "; END",
+				"CREATE TRIGGER IF NOT EXISTS " + names[2] + " AFTER DELETE ON " + src.table + " BEGIN " +
+					searchRefresh(src.kind, src.table, src.tagTable, src.tagColumn, "OLD.id") + "; END",
+				"CREATE TRIGGER IF NOT EXISTS " + names[3] + " AFTER INSERT ON " + src.tagTable + " BEGIN " +
+					searchRefresh(src.kind, src.table, src.tagTable, src.tagColumn, "NEW."+src.tagColumn) + "; END",
+				"CREATE TRIGGER IF NOT EXISTS " + names[4] + " AFTER DELETE ON " + src.tagTable + " BEGIN " +
+					searchRefresh(src.kind, src.table, src.tagTable, src.tagColumn, "OLD."+src.tagColumn) + "; END",
+			}
+			for _, trigger := range triggers {
+				err = tx.Exec(trigger).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func searchTriggerNames(table, tagTable string) []string {
+	return []string{
+		table + "_search_insert",
+		table + "_search_update",
+		table + "_search_delete",
+		tagTable + "_search_insert",
+		tagTable + "_search_delete",
+	}
+}
+
+// searchRefresh is the SQL that replaces the index rows for the transaction
+// with id, deleted transactions are left out. An empty id refreshes the whole
+// table, assuming the index has been emptied.
+func searchRefresh(kind, table, tagTable, tagColumn, id string) string {
+	insert := "INSERT INTO transaction_search (source, notes, tags, kind, transaction_id, ledger_id) " +
+		"SELECT t.source, t.notes, " +
+		"(SELECT group_concat(tags.name, ' ') FROM " + tagTable + " JOIN tags ON tags.id = " + tagTable + ".tag_id WHERE " + tagTable + "." + tagColumn + " = t.id), " +
+		"'" + kind + "', t.id, t.ledger_id FROM " + table + " t WHERE t.deleted_at IS NULL"
+	if id == "" {
+		return insert
+	}
+	return "DELETE FROM transaction_search WHERE kind = '" + kind + "' AND transaction_id = " + id + "; " +
+		insert + " AND t.id = " + id
+}
+
+// searchQuery turns what was typed into an FTS5 query that matches every word,
+// the last as a prefix so results show while the word is being typed. It's
+// empty when there are no words.
+func searchQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// Searches the source, notes and tags of the ledger's incomes and expenses,
+// best match first
+func (d *SQLite) Search(ledgerID uint, text string, limit int) ([]models.SearchResult, error) {
+	if !d.search {
+		return nil, ErrSearchUnavailable
+	}
+	query := searchQuery(text)
+	if query == "" {
+		return nil, nil
+	}
+
+	var matches []struct {
+		Kind          string
+		TransactionID uint
+		Snippet       string
+	}
+	tx := d.DB.Raw(
+		"SELECT kind, transaction_id, snippet(transaction_search, -1, ?, ?, '…', 12) AS snippet "+
+			"FROM transaction_search WHERE transaction_search MATCH ? AND ledger_id = ? "+
+			"ORDER BY rank LIMIT ?",
+		SearchMatchStart, SearchMatchEnd, query, ledgerID, limit,
+	).Scan(&matches)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+
+	var expenseIDs, incomeIDs []uint
+	for _, m := range matches {
+		if m.Kind == models.CategoryKindExpense {
+			expenseIDs = append(expenseIDs, m.TransactionID)
+		} else {
+			incomeIDs = append(incomeIDs, m.TransactionID)
+		}
+	}
+	var expenses []models.Expense
+	var incomes []models.Income
+	if len(expenseIDs) > 0 {
+		err := d.DB.Model(models.Expense{}).Preload("Category").Find(&expenses, expenseIDs).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(incomeIDs) > 0 {
+		err := d.DB.Model(models.Income{}).Preload("Category").Find(&incomes, incomeIDs).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// keyed by kind and id, as an expense and an income can share an id
+	found := map[string]models.SearchResult{}
+	for _, e := range expenses {
+		found[models.CategoryKindExpense+strconv.Itoa(int(e.ID))] = models.SearchResult{
+			Kind:       models.CategoryKindExpense,
+			ID:         e.ID,
+			Source:     e.Source,
+			Amount:     e.Amount,
+			OccurredOn: e.OccurredOn,
+			Category:   e.Category.Name,
+		}
+	}
+	for _, i := range incomes {
+		found[models.CategoryKindIncome+strconv.Itoa(int(i.ID))] = models.SearchResult{
+			Kind:       models.CategoryKindIncome,
+			ID:         i.ID,
+			Source:     i.Source,
+			Amount:     i.Amount,
+			OccurredOn: i.OccurredOn,
+			Category:   i.Category.Name,
+		}
+	}
+
+	results := make([]models.SearchResult, 0, len(matches))
+	for _, m := range matches {
+		result, ok := found[m.Kind+strconv.Itoa(int(m.TransactionID))]
+		if !ok {
+			continue
+		}
+		result.Snippet = m.Snippet
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// findOrCreateTags swaps tags that only have a name for the stored row,
+// creating it when it's new
+func findOrCreateTags(tx *gorm.DB, tags []models.Tag) ([]models.Tag, error) {
+	stored := make([]models.Tag, 0, len(tags))
+	for _, tag := range tags {
+		err := tx.Model(models.Tag{}).Where(models.Tag{Name: tag.Name}).FirstOrCreate(&tag).Error
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, tag)
+	}
+	return stored, nil
+}
+
+// Gets every tag in use, sorted by name
+func (d *SQLite) GetTags() ([]models.Tag, error) {
+	var tags []models.Tag
+	tx := d.DB.Model(models.Tag{}).Order("name").Find(&tags)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return tags, nil
+}
+
+func (d *SQLite) AddExpenseTag(ledgerID uint, id int, tag string) error {
+	return d.attachTag(&models.Expense{}, ledgerID, id, tag)
+}
+
+func (d *SQLite) RemoveExpenseTag(ledgerID uint, id int, tag string) error {
+	return d.detachTag(&models.Expense{}, ledgerID, id, tag)
+}
+
+func (d *SQLite) AddIncomeTag(ledgerID uint, id int, tag string) error {
+	return d.attachTag(&models.Income{}, ledgerID, id, tag)
+}
+
+func (d *SQLite) RemoveIncomeTag(ledgerID uint, id int, tag string) error {
+	return d.detachTag(&models.Income{}, ledgerID, id, tag)
+}
+
+// model is a pointer to an empty Expense or Income, loaded by id once it is
+// known to belong to the ledger
+func (d *SQLite) attachTag(model interface{}, ledgerID uint, id int, name string) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, model, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = tx.First(model, id).Error
+		if err != nil {
+			return err
+		}
+		before, err := snapshot(tx, entityOf(model), uint(id))
+		if err != nil {
+			return err
+		}
+
+		tags, err := findOrCreateTags(tx, []models.Tag{{Name: name}})
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model).Association("Tags").Append(tags)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, entityOf(model), uint(id), before)
+	})
+}
+
+func (d *SQLite) detachTag(model interface{}, ledgerID uint, id int, name string) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inLedger(tx, model, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		err = tx.First(model, id).Error
+		if err != nil {
+			return err
+		}
+		before, err := snapshot(tx, entityOf(model), uint(id))
+		if err != nil {
+			return err
+		}
+
+		var tag models.Tag
+		err = tx.Model(models.Tag{}).Where("name = ?", name).First(&tag).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(model).Association("Tags").Delete(&tag)
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, entityOf(model), uint(id), before)
+	})
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// inTrash checks the row with id in model's table has been deleted and belongs
+// to ledgerID, rows that haven't been deleted are ErrNotFound.
+func inTrash(tx *gorm.DB, model interface{}, ledgerID uint, id int) error {
+	return inLedger(tx.Unscoped().Where("deleted_at IS NOT NULL"), model, ledgerID, id)
+}
+
+// Gets the ledger's deleted Expenses, most recently deleted first
+func (d *SQLite) GetDeletedExpenses(ledgerID uint) ([]models.Expense, error) {
+	var expenses []models.Expense
+	tx := d.DB.Unscoped().Model(models.Expense{}).
+		Where("ledger_id = ? AND deleted_at IS NOT NULL", ledgerID).
+		Preload("Category").Preload("Account").
+		Order("deleted_at desc").
+		Find(&expenses)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return expenses, nil
+}
+
+// Gets the ledger's deleted Incomes, most recently deleted first
+func (d *SQLite) GetDeletedIncomes(ledgerID uint) ([]models.Income, error) {
+	var incomes []models.Income
+	tx := d.DB.Unscoped().Model(models.Income{}).
+		Where("ledger_id = ? AND deleted_at IS NOT NULL", ledgerID).
+		Preload("Category").Preload("Account").
+		Order("deleted_at desc").
+		Find(&incomes)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return incomes, nil
+}
+
+// Takes an Expense out of the trash, with its tags and split lines as they were
+func (d *SQLite) RestoreExpense(ledgerID uint, id int) error {
+	return d.restore(&models.Expense{}, ledgerID, id)
+}
+
+// Takes an Income out of the trash, with its tags as they were
+func (d *SQLite) RestoreIncome(ledgerID uint, id int) error {
+	return d.restore(&models.Income{}, ledgerID, id)
+}
+
+func (d *SQLite) restore(model interface{}, ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inTrash(tx, model, ledgerID, id)
+		if err != nil {
+			return err
+		}
+
+		before, err := snapshot(tx, entityOf(model), uint(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(model).Where("id = ?", id).Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		err = postJournal(tx, journalSourceOf(model), uint(id))
+		if err != nil {
+			return err
+		}
+		return d.record(tx, models.AuditUpdate, entityOf(model), uint(id), before)
+	})
+}
+
+// Removes a deleted Expense for good, it can't be restored afterwards
+func (d *SQLite) PurgeExpense(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inTrash(tx, &models.Expense{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		return d.purgeExpenses(tx, []uint{uint(id)})
+	})
+}
+
+// Removes a deleted Income for good, it can't be restored afterwards
+func (d *SQLite) PurgeIncome(ledgerID uint, id int) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		err := inTrash(tx, &models.Income{}, ledgerID, id)
+		if err != nil {
+			return err
+		}
+		return d.purgeIncomes(tx, []uint{uint(id)})
+	})
+}
+
+// PurgeDeleted removes every income and expense, in any ledger, that was
+// deleted before before. It returns how many were removed.
+func (d *SQLite) PurgeDeleted(before time.Time) (int, error) {
+	purged := 0
+	err := d.DB.Transaction(func(tx *gorm.DB) error {
+		var expenses, incomes []uint
+		err := tx.Unscoped().Model(models.Expense{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", before.UTC()).
+			Pluck("id", &expenses).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(models.Income{}).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", before.UTC()).
+			Pluck("id", &incomes).Error
+		if err != nil {
+			return err
+		}
+
+		err = d.purgeExpenses(tx, expenses)
+		if err != nil {
+			return err
+		}
+		err = d.purgeIncomes(tx, incomes)
+		if err != nil {
+			return err
+		}
+		purged = len(expenses) + len(incomes)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// purgeExpenses hard deletes expenses along with their split lines and tags
+func (d *SQLite) purgeExpenses(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	befores, err := snapshots(tx, models.AuditExpense, ids)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Unscoped().Where("expense_id IN ?", ids).Delete(&models.ExpenseSplit{}).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Exec("DELETE FROM expense_tags WHERE expense_id IN ?", ids).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Unscoped().Delete(&models.Expense{}, ids).Error
+	if err != nil {
+		return err
+	}
+	return d.recordDeletes(tx, models.AuditExpense, ids, befores)
+}
+
+// purgeIncomes hard deletes incomes along with their tags
+func (d *SQLite) purgeIncomes(tx *gorm.DB, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	befores, err := snapshots(tx, models.AuditIncome, ids)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("DELETE FROM income_tags WHERE income_id IN ?", ids).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Unscoped().Delete(&models.Income{}, ids).Error
+	if err != nil {
+		return err
+	}
+	return d.recordDeletes(tx, models.AuditIncome, ids, befores)
+}
+
+// snapshots takes a snapshot of each of ids, in the same order
+func snapshots(tx *gorm.DB, entity string, ids []uint) ([]string, error) {
+	befores := make([]string, len(ids))
+	for i, id := range ids {
+		var err error
+		befores[i], err = snapshot(tx, entity, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return befores, nil
+}
+
+func (d *SQLite) recordDeletes(tx *gorm.DB, entity string, ids []uint, befores []string) error {
+	for i, id := range ids {
+		err := d.record(tx, models.AuditDelete, entity, id, befores[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
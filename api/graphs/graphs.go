@@ -0,0 +1,28 @@
+package graphs
+
+import (
+	"bytes"
+
+	"github.com/go-echarts/go-echarts/v2/render"
+	tpls "github.com/go-echarts/go-echarts/v2/templates"
+)
+
+// Chart is satisfied by every go-echarts chart type
+type Chart interface {
+	Validate()
+}
+
+// Render renders only the chart's container and script, leaving out the
+// <html>/<head> wrapper the chart's own Render adds, so several charts can
+// share one fragment. The echarts script itself is loaded by index.html.
+func Render(c Chart) (string, error) {
+	c.Validate()
+
+	tpl := render.MustTemplate("base", []string{tpls.BaseTpl})
+	buff := bytes.NewBuffer([]byte{})
+	err := tpl.ExecuteTemplate(buff, "base", c)
+	if err != nil {
+		return "", err
+	}
+	return buff.String(), nil
+}
@@ -1,10 +1,9 @@
 package api
 
 import (
-	"bytes"
 	"log/slog"
 	"net/http"
-	"strconv"
+	"sort"
 	"text/template"
 
 	"github.com/go-echarts/go-echarts/v2/charts"
@@ -12,6 +11,10 @@ import (
 
 	"github.com/Ewan-Greer09/finance-app/api/config"
 	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/graphs"
+	"github.com/Ewan-Greer09/finance-app/api/handlers"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
 )
 
 var parseTemplateError = "Failed to parse template"
@@ -22,76 +25,128 @@ var incomeError = "Failed to get incomes"
 type Handler struct {
 	*slog.Logger
 	database.Database
+	currency string
 }
 
-func NewHandler(logger *slog.Logger, cfg config.Config) *Handler {
+func NewHandler(logger *slog.Logger, db database.Database, cfg config.Config) *Handler {
 	return &Handler{
 		Logger:   logger,
-		Database: database.NewDatabase(cfg),
+		Database: db,
+		currency: cfg.API.DefaultCurrency,
 	}
 }
 
 func (h *Handler) HandleGetExpensesAndIncomesGraph(w http.ResponseWriter, r *http.Request) {
-	expenses, err := h.GetExpenses()
+	filter, _, err := handlers.ParseTransactionFilter(r, h.currency)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ledgerID := handlers.CurrentMember(r).LedgerID
+	// the totals cover every matching transaction, not just a page of them
+	expenses, err := h.FilterExpenses(ledgerID, filter)
 	if err != nil {
 		h.Logger.Error(expenseError, "error", err)
 		http.Error(w, expenseError, http.StatusInternalServerError)
 		return
 	}
 
-	incomes, err := h.GetIncomes()
+	incomes, err := h.FilterIncomes(ledgerID, filter)
 	if err != nil {
 		h.Logger.Error(incomeError, "error", err)
 		http.Error(w, incomeError, http.StatusInternalServerError)
 		return
 	}
 
-	expTotal, incTotal := 0.0, 0.0
+	currency := handlers.ReportingCurrency(r, h.currency)
+	conv := rates.NewConverter(h.Database)
+
+	// a row that can't be converted is skipped rather than failing the whole chart
+	expTotal, incTotal := money.New(0, currency), money.New(0, currency)
+	byCategory := map[string]money.Money{}
 	for _, expense := range expenses {
-		val, err := strconv.ParseFloat(expense.Amount, 64)
+		amount, err := conv.Convert(expense.Amount, currency, expense.OccurredOn)
 		if err != nil {
-			http.Error(w, "Failed to parse expense amount", http.StatusInternalServerError)
-			return
+			h.Logger.Warn("Skipping expense in graph", "id", expense.ID, "error", err)
+			continue
+		}
+		expTotal, _ = expTotal.Add(amount)
+
+		// split lines are counted under their own categories
+		if len(expense.Splits) == 0 {
+			byCategory[expense.Category.Name], _ = byCategory[expense.Category.Name].Add(amount)
+			continue
+		}
+		for _, split := range expense.Splits {
+			amount, err := conv.Convert(split.Amount, currency, expense.OccurredOn)
+			if err != nil {
+				h.Logger.Warn("Skipping split in graph", "id", split.ID, "error", err)
+				continue
+			}
+			byCategory[split.Category.Name], _ = byCategory[split.Category.Name].Add(amount)
 		}
-		expTotal += val
 	}
 	for _, income := range incomes {
-		val, err := strconv.ParseFloat(income.Amount, 64)
+		amount, err := conv.Convert(income.Amount, currency, income.OccurredOn)
 		if err != nil {
-			http.Error(w, "Failed to parse expense amount", http.StatusInternalServerError)
-			return
+			h.Logger.Warn("Skipping income in graph", "id", income.ID, "error", err)
+			continue
 		}
-		incTotal += val
+		incTotal, _ = incTotal.Add(amount)
 	}
 
 	bar := charts.NewBar()
 	bar.SetGlobalOptions(charts.WithTitleOpts(opts.Title{
 		Title:    "Expenses and Incomes",
-		Subtitle: "Your Expenses and Incomes",
+		Subtitle: "Your Expenses and Incomes in " + currency,
 	}))
 
 	bar.SetXAxis([]string{"Expenses vs Incomes"}).
 		AddSeries("Expenses", []opts.BarData{
-			{Value: expTotal},
+			{Value: expTotal.Float64()},
 		}).
 		AddSeries("Incomes", []opts.BarData{
-			{Value: incTotal},
+			{Value: incTotal.Float64()},
 		})
 
-	buff := bytes.NewBuffer([]byte{})
-	err = bar.Render(buff)
-	if err != nil {
-		h.Logger.Error("Failed to render graph", "error", err)
-		http.Error(w, "Failed to render graph", http.StatusInternalServerError)
+	names := make([]string, 0, len(byCategory))
+	for name := range byCategory {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data := make([]opts.PieData, 0, len(names))
+	for _, name := range names {
+		data = append(data, opts.PieData{Name: name, Value: byCategory[name].Float64()})
+	}
+
+	pie := charts.NewPie()
+	pie.SetGlobalOptions(charts.WithTitleOpts(opts.Title{
+		Title:    "Expenses by Category",
+		Subtitle: "Split expenses are counted per line, in " + currency,
+	}))
+	pie.AddSeries("Categories", data)
+
+	var snippets []string
+	for _, c := range []graphs.Chart{bar, pie} {
+		snippet, err := graphs.Render(c)
+		if err != nil {
+			h.Logger.Error("Failed to render graph", "error", err)
+			http.Error(w, "Failed to render graph", http.StatusInternalServerError)
+			return
+		}
+		snippets = append(snippets, snippet)
 	}
 
-	// load graph and pass to template
+	// load graphs and pass to template
 	tmpl, err := template.ParseFS(webFS, "web/components/graph.html")
 	if err != nil {
 		h.Logger.Error(parseTemplateError, "error", err)
 		http.Error(w, parseTemplateError, http.StatusInternalServerError)
 		return
 	}
-	buff.Next(200) //remove the <head /> from the graph
-	err = tmpl.Execute(w, buff.String())
+	err = tmpl.Execute(w, snippets)
+	if err != nil {
+		h.Logger.Error(executeTemplateError, "error", err)
+	}
 }
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var accountError = "Failed to get accounts"
+
+var accountTypes = []string{
+	models.AccountTypeCurrent,
+	models.AccountTypeCreditCard,
+	models.AccountTypeCash,
+	models.AccountTypeSavings,
+	models.AccountTypeBrokerage,
+	models.AccountTypeISA,
+}
+
+type AccountHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS    embed.FS
+	currency string
+}
+
+func NewAccountHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *AccountHandler {
+	return &AccountHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+		currency: currency,
+	}
+}
+
+func (a *AccountHandler) Routes(r chi.Router) {
+	// api/v1/account
+	r.Use(RequireUser(a.Database), RequireLedger(a.Database), RequireEditor)
+	r.Get("/", a.HandleGetAccounts)
+	r.Get("/options", a.HandleGetAccountOptions)
+	r.Post("/", a.HandleAddAccount)
+	r.Delete("/{id}", a.HandleDeleteAccount)
+	r.Post("/transfer", a.HandleAddTransfer)
+	r.Delete("/transfer/{id}", a.HandleDeleteTransfer)
+	r.Post("/transfer/{id}/unreconcile", a.HandleUnreconcileTransfer)
+}
+
+// the accounts panel: balances, a page of transfers and the forms for both
+type accountsView struct {
+	Balances  []models.AccountBalance
+	Transfers pageView
+	Types     []string
+}
+
+type accountOptionsView struct {
+	Accounts []models.Account
+	Selected uint
+}
+
+// renders the ledger's accounts and its newest transfers, ?cursor= renders
+// only the next page of transfers
+func (a *AccountHandler) HandleGetAccounts(w http.ResponseWriter, r *http.Request) {
+	err := executeGetAccounts(w, r, a)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+	}
+}
+
+// renders <option>s for the account pickers, with ?selected=id picked
+func (a *AccountHandler) HandleGetAccountOptions(w http.ResponseWriter, r *http.Request) {
+	accounts, err := a.GetAccounts(CurrentMember(r).LedgerID)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+		http.Error(w, accountError, http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFS(a.webFS, "web/components/account_options.html")
+	if err != nil {
+		a.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, accountOptionsView{
+		Accounts: accounts,
+		Selected: selectedOption(r),
+	})
+	if err != nil {
+		a.Logger.Error(executeTemplateError, "error", err)
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+	}
+}
+
+func (a *AccountHandler) HandleAddAccount(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	accountType := r.FormValue("type")
+	valid := false
+	for _, t := range accountTypes {
+		valid = valid || t == accountType
+	}
+	if !valid {
+		http.Error(w, "Invalid account type", http.StatusBadRequest)
+		return
+	}
+
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = a.currency
+	}
+	opening := r.FormValue("opening_balance")
+	if opening == "" {
+		opening = "0"
+	}
+	balance, err := money.Parse(opening, currency)
+	if err != nil {
+		http.Error(w, "Invalid opening balance", http.StatusBadRequest)
+		return
+	}
+
+	err = a.AddAccount(models.Account{
+		LedgerID:       CurrentMember(r).LedgerID,
+		Name:           name,
+		Type:           accountType,
+		OpeningBalance: balance,
+	})
+	if err != nil {
+		a.Logger.Error("Failed to add account", "error", err)
+		http.Error(w, "Failed to add account", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetAccounts(w, r, a)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+	}
+}
+
+func (a *AccountHandler) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	err = a.DeleteAccount(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, a.Logger, err, "Account not found") {
+		return
+	}
+	if errors.Is(err, database.ErrAccountInUse) {
+		http.Error(w, "Account still has transactions, transfers, trades, recurring items, bills, goals, loans or reconciliations", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.Logger.Error("Failed to delete account", "error", err)
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetAccounts(w, r, a)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+	}
+}
+
+// moves money between two accounts. The amount is in the sending account's
+// currency, received_amount is only needed across currencies and defaults to
+// the amount converted at the day's rate.
+func (a *AccountHandler) HandleAddTransfer(w http.ResponseWriter, r *http.Request) {
+	from, err := a.formAccount(r, "from_account_id")
+	if err != nil {
+		http.Error(w, "Invalid from account", http.StatusBadRequest)
+		return
+	}
+	to, err := a.formAccount(r, "to_account_id")
+	if err != nil {
+		http.Error(w, "Invalid to account", http.StatusBadRequest)
+		return
+	}
+	if from.ID == to.ID {
+		http.Error(w, "Can't transfer to the same account", http.StatusBadRequest)
+		return
+	}
+
+	amount, err := money.Parse(r.FormValue("amount"), from.Currency())
+	if err != nil || amount.IsNegative() || amount.IsZero() {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	day, err := occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+
+	received := amount
+	if value := r.FormValue("received_amount"); value != "" {
+		received, err = money.Parse(value, to.Currency())
+		if err != nil || received.IsNegative() || received.IsZero() {
+			http.Error(w, "Invalid received amount", http.StatusBadRequest)
+			return
+		}
+	} else if to.Currency() != from.Currency() {
+		received, err = rates.NewConverter(a.Database).Convert(amount, to.Currency(), day)
+		if err != nil {
+			http.Error(w, "No exchange rate, enter the received amount", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = a.AddTransfer(models.Transfer{
+		LedgerID:       CurrentMember(r).LedgerID,
+		FromAccountID:  from.ID,
+		ToAccountID:    to.ID,
+		Amount:         amount,
+		ReceivedAmount: received,
+		OccurredOn:     day,
+		Note:           strings.TrimSpace(r.FormValue("note")),
+	})
+	if err != nil {
+		a.Logger.Error("Failed to add transfer", "error", err)
+		http.Error(w, "Failed to add transfer", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetAccounts(w, r, a)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+	}
+}
+
+func (a *AccountHandler) HandleDeleteTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid transfer ID", http.StatusBadRequest)
+		return
+	}
+
+	err = a.DeleteTransfer(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, a.Logger, err, "Transfer not found") {
+		return
+	}
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Transfer is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		a.Logger.Error("Failed to delete transfer", "error", err)
+		http.Error(w, "Failed to delete transfer", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetAccounts(w, r, a)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+	}
+}
+
+// unlocks a reconciled transfer so it can be deleted, both sides go back to cleared
+func (a *AccountHandler) HandleUnreconcileTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid transfer ID", http.StatusBadRequest)
+		return
+	}
+
+	err = a.As(auditActor(r)).UnreconcileTransfer(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrNotReconciled) {
+		http.Error(w, "Transfer isn't reconciled", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, a.Logger, err, "Transfer not found") {
+		return
+	}
+	if err != nil {
+		a.Logger.Error("Failed to un-reconcile transfer", "error", err)
+		http.Error(w, "Failed to un-reconcile transfer", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetAccounts(w, r, a)
+	if err != nil {
+		a.Logger.Error(accountError, "error", err)
+	}
+}
+
+func (a *AccountHandler) formAccount(r *http.Request, field string) (models.Account, error) {
+	id, err := strconv.Atoi(r.FormValue(field))
+	if err != nil {
+		return models.Account{}, err
+	}
+	return a.GetAccount(CurrentMember(r).LedgerID, id)
+}
+
+// renders account balances as of today and a page of the latest transfers
+func executeGetAccounts(w http.ResponseWriter, r *http.Request, a *AccountHandler) error {
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return err
+	}
+	ledgerID := CurrentMember(r).LedgerID
+	transfers, next, err := a.GetTransfers(ledgerID, page)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid page: "+err.Error(), http.StatusBadRequest)
+		return err
+	}
+	if err != nil {
+		http.Error(w, accountError, http.StatusInternalServerError)
+		return err
+	}
+	view := accountsView{
+		Transfers: pageView{Items: transfers, More: nextPageURL(r, "/api/v1/account", page, next)},
+		Types:     accountTypes,
+	}
+
+	tmpl, err := template.ParseFS(a.webFS, "web/components/accounts.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+
+	// later pages are only the transfer rows, they're appended to the table already shown
+	if isNextPage(r) {
+		err = tmpl.ExecuteTemplate(w, "transfer-rows", view.Transfers)
+	} else {
+		view.Balances, err = a.GetAccountBalances(ledgerID, Today())
+		if err != nil {
+			http.Error(w, accountError, http.StatusInternalServerError)
+			return err
+		}
+		err = tmpl.ExecuteTemplate(w, "accounts.html", view)
+	}
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
+
+// accountFromForm reads the account_id form value for a new transaction,
+// falling back to the ledger's default account when none was picked
+func accountFromForm(r *http.Request, db database.Database) (models.Account, error) {
+	ledgerID := CurrentMember(r).LedgerID
+	value := r.FormValue("account_id")
+	if value == "" {
+		return db.GetDefaultAccount(ledgerID)
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return models.Account{}, err
+	}
+	return db.GetAccount(ledgerID, id)
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"embed"
 	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
@@ -13,6 +17,9 @@ import (
 
 	"github.com/Ewan-Greer09/finance-app/api/database"
 	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/prices"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
 )
 
 type AdminHandler struct {
@@ -32,12 +39,17 @@ func NewAdminHandler(log *slog.Logger, db database.Database, fs embed.FS) *Admin
 func (a *AdminHandler) Routes(r chi.Router) {
 	r.Post("/login", a.Login)
 	r.Get("/logout", a.Logout)
+	r.Post("/currency", a.SetReportingCurrency)
 
 	// Protected routes
 	r.Group(func(r chi.Router) {
 		r.Use(a.IsAdmin) // JWT middleware
 		r.Get("/user", a.GetUser)
 		r.Post("/user", a.CreateUser)
+		r.Post("/rates", a.ImportRates)
+		r.Post("/prices", a.ImportPrices)
+		r.Get("/audit", a.GetAuditLog)
+		r.Get("/journal/check", a.CheckJournal)
 	})
 }
 
@@ -83,7 +95,7 @@ func (a *AdminHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := a.DB.CreateUser(user)
+	err := a.DB.As(auditActor(r)).CreateUser(user)
 	if err != nil {
 		render.HTML(w, r, "<h1>Failed to create user</h1>")
 		render.Status(r, http.StatusInternalServerError)
@@ -149,6 +161,180 @@ func (a *AdminHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	render.HTML(w, r, "<h1>Logged out</h1>")
 }
 
+// sets the currency the logged in user's totals and graphs are shown in
+func (a *AdminHandler) SetReportingCurrency(w http.ResponseWriter, r *http.Request) {
+	username, err := UsernameFromRequest(r)
+	if err != nil {
+		render.Status(r, http.StatusUnauthorized)
+		render.HTML(w, r, "<h1>Not logged in</h1>")
+		return
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(r.FormValue("currency")))
+	if !money.ValidCurrency(currency) {
+		render.Status(r, http.StatusBadRequest)
+		render.HTML(w, r, "<h1>Invalid currency</h1>")
+		return
+	}
+
+	err = a.DB.As(auditActor(r)).SetReportingCurrency(username, currency)
+	if err != nil {
+		a.Logger.Error("Failed to set reporting currency", "error", err)
+		render.Status(r, http.StatusInternalServerError)
+		render.HTML(w, r, "<h1>Failed to set reporting currency</h1>")
+		return
+	}
+
+	render.HTML(w, r, "<h1>Reporting currency set to "+currency+"</h1>")
+}
+
+// imports an uploaded ECB-style XML or CSV rates file
+func (a *AdminHandler) ImportRates(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.HTML(w, r, "<h1>A rates file is required</h1>")
+		return
+	}
+	defer file.Close()
+
+	parsed, err := rates.Parse(header.Filename, file)
+	if err != nil {
+		a.Logger.Error("Failed to parse rates file", "error", err)
+		render.Status(r, http.StatusBadRequest)
+		render.HTML(w, r, "<h1>Failed to parse rates file</h1>")
+		return
+	}
+
+	err = a.DB.AddRates(parsed)
+	if err != nil {
+		a.Logger.Error("Failed to import rates", "error", err)
+		render.Status(r, http.StatusInternalServerError)
+		render.HTML(w, r, "<h1>Failed to import rates</h1>")
+		return
+	}
+
+	render.HTML(w, r, fmt.Sprintf("<h1>Imported %d rates</h1>", len(parsed)))
+}
+
+// imports a CSV of security prices, rows for symbols that aren't set up as a
+// security are skipped
+func (a *AdminHandler) ImportPrices(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.HTML(w, r, "<h1>A prices file is required</h1>")
+		return
+	}
+	defer file.Close()
+
+	quotes, err := prices.ParseCSV(file)
+	if err != nil {
+		a.Logger.Error("Failed to parse prices file", "error", err)
+		render.Status(r, http.StatusBadRequest)
+		render.HTML(w, r, "<h1>Failed to parse prices file</h1>")
+		return
+	}
+
+	count, err := a.DB.AddPrices(quotes)
+	if err != nil {
+		a.Logger.Error("Failed to import prices", "error", err)
+		render.Status(r, http.StatusInternalServerError)
+		render.HTML(w, r, "<h1>Failed to import prices</h1>")
+		return
+	}
+
+	render.HTML(w, r, fmt.Sprintf("<h1>Imported %d prices</h1>", count))
+}
+
+// auditLimit is how many audit entries are shown when the request doesn't say
+const auditLimit = 100
+
+// lists audit entries, newest first, narrowed by the entity, entity_id,
+// action, actor, request_id, from, to and limit query parameters
+func (a *AdminHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		Entity:    query.Get("entity"),
+		Action:    query.Get("action"),
+		Actor:     strings.TrimSpace(query.Get("actor")),
+		RequestID: strings.TrimSpace(query.Get("request_id")),
+		Limit:     auditLimit,
+	}
+
+	if v := query.Get("entity_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.HTML(w, r, "<h1>Invalid entity ID</h1>")
+			return
+		}
+		filter.EntityID = uint(id)
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.HTML(w, r, "<h1>Invalid limit</h1>")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	var err error
+	filter.From, filter.To, _, err = ParseDateRange(r)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.HTML(w, r, "<h1>Invalid date range</h1>")
+		return
+	}
+
+	entries, err := a.DB.GetAuditEntries(filter)
+	if err != nil {
+		a.Logger.Error("Failed to get audit log", "error", err)
+		render.Status(r, http.StatusInternalServerError)
+		render.HTML(w, r, "<h1>Failed to get audit log</h1>")
+		return
+	}
+
+	tmpl, err := template.ParseFS(a.FS, "web/components/audit.html")
+	if err != nil {
+		a.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, entries)
+	if err != nil {
+		a.Logger.Error(executeTemplateError, "error", err)
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+	}
+}
+
+// checks every journal entry balances, answering with a 500 that lists the
+// ones that don't
+func (a *AdminHandler) CheckJournal(w http.ResponseWriter, r *http.Request) {
+	unbalanced, err := a.DB.GetUnbalancedEntries()
+	if err != nil {
+		a.Logger.Error("Failed to check journal", "error", err)
+		render.Status(r, http.StatusInternalServerError)
+		render.HTML(w, r, "<h1>Failed to check journal</h1>")
+		return
+	}
+	if len(unbalanced) == 0 {
+		render.HTML(w, r, "<h1>Every journal entry balances</h1>")
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h1>%d journal entries don't balance</h1><ul>", len(unbalanced))
+	for _, entry := range unbalanced {
+		fmt.Fprintf(&b, "<li>entry %d, %s %d</li>", entry.ID, entry.Source, entry.SourceID)
+	}
+	b.WriteString("</ul>")
+	render.Status(r, http.StatusInternalServerError)
+	render.HTML(w, r, b.String())
+}
+
 // middleware to check if user is admin
 func (a *AdminHandler) IsAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -185,7 +371,8 @@ func (a *AdminHandler) IsAdmin(next http.Handler) http.Handler {
 					return
 				}
 
-				next.ServeHTTP(w, r)
+				ctx := context.WithValue(r.Context(), userContextKey{}, user)
+				next.ServeHTTP(w, r.WithContext(ctx))
 			}
 		}
 	})
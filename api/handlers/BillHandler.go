@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/recurring"
+)
+
+var billError = "Failed to get bills"
+
+// how many days ahead the calendar of due dates looks
+const billCalendarDays = 31
+
+type BillHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS      embed.FS
+	currency   string
+	remindDays int
+}
+
+func NewBillHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string, remindDays int) *BillHandler {
+	return &BillHandler{
+		Logger:     logger,
+		Database:   db,
+		webFS:      webFS,
+		currency:   currency,
+		remindDays: remindDays,
+	}
+}
+
+func (b *BillHandler) Routes(r chi.Router) {
+	// api/v1/bill
+	r.Use(RequireUser(b.Database), RequireLedger(b.Database), RequireEditor)
+	r.Get("/", b.HandleGetBills)
+	r.Post("/", b.HandleAddBill)
+	r.Delete("/{id}", b.HandleDeleteBill)
+	r.Post("/{id}/pay", b.HandlePayBill)
+}
+
+// billDate is a day a bill falls due in the calendar
+type billDate struct {
+	On   time.Time
+	Bill models.Bill
+}
+
+type billsView struct {
+	Bills       []models.Bill
+	Calendar    []billDate
+	Today       time.Time
+	RemindDays  int
+	Frequencies []string
+}
+
+// renders every bill with whether it is overdue, due soon or upcoming, and a
+// calendar of the due dates over the next month
+func (b *BillHandler) HandleGetBills(w http.ResponseWriter, r *http.Request) {
+	err := executeGetBills(w, r, b)
+	if err != nil {
+		b.Logger.Error(billError, "error", err)
+	}
+}
+
+func (b *BillHandler) HandleAddBill(w http.ResponseWriter, r *http.Request) {
+	category, err := categoryFromForm(r, b.Database, models.CategoryKindExpense)
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	account, err := accountFromForm(r, b.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+
+	// the amount defaults to the paying account's currency
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = account.Currency()
+	}
+	amount, err := money.Parse(r.FormValue("amount"), currency)
+	if err != nil {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	estimated := r.FormValue("estimated")
+
+	bill := models.Bill{
+		LedgerID:   CurrentMember(r).LedgerID,
+		UserID:     CurrentUser(r).ID,
+		Payee:      strings.TrimSpace(r.FormValue("payee")),
+		Amount:     amount,
+		Estimated:  estimated == "true" || estimated == "on",
+		CategoryID: category.ID,
+		AccountID:  account.ID,
+		Frequency:  r.FormValue("frequency"),
+		RRule:      strings.TrimSpace(r.FormValue("rrule")),
+	}
+	if value := r.FormValue("interval"); value != "" {
+		bill.Interval, err = strconv.Atoi(value)
+		if err != nil || bill.Interval < 1 {
+			http.Error(w, "Invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the first due date is the schedule's first occurrence from start_on
+	bill.StartOn, err = ParseDay(r.FormValue("start_on"))
+	if err != nil {
+		http.Error(w, "Invalid first due date", http.StatusBadRequest)
+		return
+	}
+	if value := r.FormValue("end_on"); value != "" {
+		end, err := ParseDay(value)
+		if err != nil || end.Before(bill.StartOn) {
+			http.Error(w, "Invalid end date", http.StatusBadRequest)
+			return
+		}
+		bill.EndOn = &end
+	}
+
+	schedule, err := recurring.ScheduleFor(bill.Recurrence())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	first, ok := schedule.First(bill.StartOn)
+	if !ok {
+		http.Error(w, "Schedule has no due dates", http.StatusBadRequest)
+		return
+	}
+	bill.DueOn = &first
+
+	err = b.AddBill(bill)
+	if errors.Is(err, models.ErrInvalidBill) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		b.Logger.Error("Failed to add bill", "error", err)
+		http.Error(w, "Failed to add bill", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetBills(w, r, b)
+	if err != nil {
+		b.Logger.Error(billError, "error", err)
+	}
+}
+
+func (b *BillHandler) HandleDeleteBill(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid bill ID", http.StatusBadRequest)
+		return
+	}
+
+	err = b.DeleteBill(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, b.Logger, err, "Bill not found") {
+		return
+	}
+	if err != nil {
+		b.Logger.Error("Failed to delete bill", "error", err)
+		http.Error(w, "Failed to delete bill", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "billsChanged")
+	err = executeGetBills(w, r, b)
+	if err != nil {
+		b.Logger.Error(billError, "error", err)
+	}
+}
+
+// marks the bill's current due date paid, booking an expense of amount, or
+// the bill's amount when it is left blank, on occurred_on or today. The bill
+// moves on to its next due date and the expense list reloads to show it.
+func (b *BillHandler) HandlePayBill(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid bill ID", http.StatusBadRequest)
+		return
+	}
+	bill, err := b.GetBill(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, b.Logger, err, "Bill not found") {
+		return
+	}
+	if err != nil {
+		b.Logger.Error(billError, "error", err)
+		http.Error(w, billError, http.StatusInternalServerError)
+		return
+	}
+	if bill.DueOn == nil {
+		http.Error(w, "Bill has no payment due", http.StatusConflict)
+		return
+	}
+
+	amount := bill.Amount
+	if value := r.FormValue("amount"); value != "" {
+		amount, err = money.Parse(value, bill.Amount.Currency)
+		if err != nil || amount.IsNegative() || amount.IsZero() {
+			http.Error(w, "Invalid amount", http.StatusBadRequest)
+			return
+		}
+	}
+	day, err := occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := recurring.ScheduleFor(bill.Recurrence())
+	if err != nil {
+		b.Logger.Error("Failed to schedule bill", "id", bill.ID, "error", err)
+		http.Error(w, "Failed to pay bill", http.StatusInternalServerError)
+		return
+	}
+	var next *time.Time
+	if t, ok := schedule.Next(bill.StartOn, *bill.DueOn); ok {
+		next = &t
+	}
+
+	err = b.As(auditActor(r)).PayBill(bill, CurrentUser(r).ID, amount, day, next)
+	if errors.Is(err, database.ErrBillNotDue) {
+		http.Error(w, "Bill was already paid", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		b.Logger.Error("Failed to pay bill", "error", err)
+		http.Error(w, "Failed to pay bill", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "billPaid")
+	err = executeGetBills(w, r, b)
+	if err != nil {
+		b.Logger.Error(billError, "error", err)
+	}
+}
+
+// calendar lists the days bills fall due from their current due date until
+// to, overdue ones first
+func calendar(bills []models.Bill, to time.Time) []billDate {
+	var dates []billDate
+	for _, bill := range bills {
+		if bill.DueOn == nil {
+			continue
+		}
+		schedule, err := recurring.ScheduleFor(bill.Recurrence())
+		if err != nil {
+			continue
+		}
+		for _, on := range schedule.Between(bill.StartOn, *bill.DueOn, to) {
+			dates = append(dates, billDate{On: on, Bill: bill})
+		}
+	}
+	sort.SliceStable(dates, func(i, j int) bool { return dates[i].On.Before(dates[j].On) })
+	return dates
+}
+
+func executeGetBills(w http.ResponseWriter, r *http.Request, b *BillHandler) error {
+	bills, err := b.GetBills(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, billError, http.StatusInternalServerError)
+		return err
+	}
+
+	today := Today()
+	tmpl, err := template.ParseFS(b.webFS, "web/components/bills.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, billsView{
+		Bills:       bills,
+		Calendar:    calendar(bills, today.AddDate(0, 0, billCalendarDays)),
+		Today:       today,
+		RemindDays:  b.remindDays,
+		Frequencies: frequencies,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var budgetError = "Failed to get budgets"
+
+var budgetPeriods = []string{
+	models.BudgetPeriodMonthly,
+	models.BudgetPeriodWeekly,
+	models.BudgetPeriodYearly,
+}
+
+var rolloverOptions = []string{
+	models.RolloverNone,
+	models.RolloverUnspent,
+	models.RolloverAll,
+}
+
+type BudgetHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS    embed.FS
+	currency string
+}
+
+func NewBudgetHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *BudgetHandler {
+	return &BudgetHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+		currency: currency,
+	}
+}
+
+func (b *BudgetHandler) Routes(r chi.Router) {
+	// api/v1/budget
+	r.Use(RequireUser(b.Database), RequireLedger(b.Database), RequireEditor)
+	r.Get("/", b.HandleGetBudgets)
+	r.Post("/", b.HandleAddBudget)
+	r.Put("/{id}", b.HandleUpdateBudget)
+	r.Delete("/{id}", b.HandleDeleteBudget)
+}
+
+type budgetsView struct {
+	Progress  []models.BudgetProgress
+	Periods   []string
+	Rollovers []string
+}
+
+// renders spent vs budget for the current period of every budget
+func (b *BudgetHandler) HandleGetBudgets(w http.ResponseWriter, r *http.Request) {
+	err := executeGetBudgets(w, r, b)
+	if err != nil {
+		b.Logger.Error(budgetError, "error", err)
+	}
+}
+
+func (b *BudgetHandler) HandleAddBudget(w http.ResponseWriter, r *http.Request) {
+	category, err := categoryFromForm(r, b.Database, models.CategoryKindExpense)
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+
+	period := r.FormValue("period")
+	if !slices.Contains(budgetPeriods, period) {
+		http.Error(w, "Invalid period", http.StatusBadRequest)
+		return
+	}
+
+	amount, rollover, err := b.budgetFromForm(r)
+	if err != nil {
+		http.Error(w, "Invalid budget: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start := Today()
+	if value := r.FormValue("start_on"); value != "" {
+		start, err = ParseDay(value)
+		if err != nil {
+			http.Error(w, "Invalid start date", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = b.AddBudget(models.Budget{
+		LedgerID:   CurrentMember(r).LedgerID,
+		CategoryID: category.ID,
+		Period:     period,
+		Amount:     amount,
+		Rollover:   rollover,
+		StartOn:    start,
+	})
+	if errors.Is(err, database.ErrBudgetExists) {
+		http.Error(w, "Category already has a "+period+" budget", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		b.Logger.Error("Failed to add budget", "error", err)
+		http.Error(w, "Failed to add budget", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetBudgets(w, r, b)
+	if err != nil {
+		b.Logger.Error(budgetError, "error", err)
+	}
+}
+
+func (b *BudgetHandler) HandleUpdateBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid budget ID", http.StatusBadRequest)
+		return
+	}
+
+	amount, rollover, err := b.budgetFromForm(r)
+	if err != nil {
+		http.Error(w, "Invalid budget: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	budget := models.Budget{LedgerID: CurrentMember(r).LedgerID, Amount: amount, Rollover: rollover}
+	budget.ID = uint(id)
+	err = b.UpdateBudget(budget)
+	if notFound(w, r, b.Logger, err, "Budget not found") {
+		return
+	}
+	if err != nil {
+		b.Logger.Error("Failed to update budget", "error", err)
+		http.Error(w, "Failed to update budget", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetBudgets(w, r, b)
+	if err != nil {
+		b.Logger.Error(budgetError, "error", err)
+	}
+}
+
+func (b *BudgetHandler) HandleDeleteBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid budget ID", http.StatusBadRequest)
+		return
+	}
+
+	err = b.DeleteBudget(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, b.Logger, err, "Budget not found") {
+		return
+	}
+	if err != nil {
+		b.Logger.Error("Failed to delete budget", "error", err)
+		http.Error(w, "Failed to delete budget", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetBudgets(w, r, b)
+	if err != nil {
+		b.Logger.Error(budgetError, "error", err)
+	}
+}
+
+// budgetFromForm reads the amount and rollover shared by add and update
+func (b *BudgetHandler) budgetFromForm(r *http.Request) (money.Money, string, error) {
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = b.currency
+	}
+	amount, err := money.Parse(r.FormValue("amount"), currency)
+	if err != nil || amount.IsNegative() || amount.IsZero() {
+		return money.Money{}, "", errors.New("invalid amount")
+	}
+
+	rollover := r.FormValue("rollover")
+	if rollover == "" {
+		rollover = models.RolloverNone
+	}
+	if !slices.Contains(rolloverOptions, rollover) {
+		return money.Money{}, "", errors.New("invalid rollover")
+	}
+	return amount, rollover, nil
+}
+
+func executeGetBudgets(w http.ResponseWriter, r *http.Request, b *BudgetHandler) error {
+	progress, err := b.GetBudgetProgress(CurrentMember(r).LedgerID, Today())
+	if err != nil {
+		http.Error(w, budgetError, http.StatusInternalServerError)
+		return err
+	}
+
+	tmpl, err := template.ParseFS(b.webFS, "web/components/budgets.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, budgetsView{
+		Progress:  progress,
+		Periods:   budgetPeriods,
+		Rollovers: rolloverOptions,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
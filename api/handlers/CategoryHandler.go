@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+var categoryError = "Failed to get categories"
+
+type CategoryHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewCategoryHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *CategoryHandler {
+	return &CategoryHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (c *CategoryHandler) Routes(r chi.Router) {
+	// api/v1/category
+	r.Use(RequireUser(c.Database), RequireLedger(c.Database), RequireEditor)
+	r.Get("/", c.HandleGetCategories)
+	r.Get("/options", c.HandleGetCategoryOptions)
+	r.Post("/", c.HandleAddCategory)
+	r.Put("/{id}", c.HandleUpdateCategory)
+	r.Delete("/{id}", c.HandleDeleteCategory)
+}
+
+// categoryOption is a category flattened into a <select>, indented by depth
+type categoryOption struct {
+	ID       uint
+	Label    string
+	Selected bool
+}
+
+func (c *CategoryHandler) HandleGetCategories(w http.ResponseWriter, r *http.Request) {
+	err := executeGetCategories(w, c)
+	if err != nil {
+		c.Logger.Error(categoryError, "error", err)
+	}
+}
+
+// renders <option>s for the category picker, ?kind=expense or ?kind=income,
+// with ?selected=id picked
+func (c *CategoryHandler) HandleGetCategoryOptions(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if !validCategoryKind(kind) {
+		http.Error(w, "Invalid category kind", http.StatusBadRequest)
+		return
+	}
+
+	categories, err := c.GetCategories(kind)
+	if err != nil {
+		c.Logger.Error(categoryError, "error", err)
+		http.Error(w, categoryError, http.StatusInternalServerError)
+		return
+	}
+
+	selected := selectedOption(r)
+	var options []categoryOption
+	var flatten func(nodes []models.Category, depth int)
+	flatten = func(nodes []models.Category, depth int) {
+		for _, node := range nodes {
+			options = append(options, categoryOption{
+				ID:       node.ID,
+				Label:    strings.Repeat("— ", depth) + node.Name,
+				Selected: node.ID == selected,
+			})
+			flatten(node.Children, depth+1)
+		}
+	}
+	flatten(categoryTree(categories), 0)
+
+	tmpl, err := template.ParseFS(c.webFS, "web/components/category_options.html")
+	if err != nil {
+		c.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, options)
+	if err != nil {
+		c.Logger.Error(executeTemplateError, "error", err)
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+	}
+}
+
+func (c *CategoryHandler) HandleAddCategory(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	category := models.Category{Name: name, Kind: r.FormValue("kind")}
+	if parentID := r.FormValue("parent_id"); parentID != "" {
+		parent, err := c.parentCategory(parentID)
+		if err != nil {
+			http.Error(w, "Invalid parent category", http.StatusBadRequest)
+			return
+		}
+		// subcategories always share the kind of their parent
+		category.ParentID = &parent.ID
+		category.Kind = parent.Kind
+	}
+	if !validCategoryKind(category.Kind) {
+		http.Error(w, "Invalid category kind", http.StatusBadRequest)
+		return
+	}
+
+	err := c.AddCategory(category)
+	if err != nil {
+		c.Logger.Error("Failed to add category", "error", err)
+		http.Error(w, "Failed to add category", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetCategories(w, c)
+	if err != nil {
+		c.Logger.Error(categoryError, "error", err)
+	}
+}
+
+// renames a category and/or moves it under a new parent, an empty parent_id
+// moves it to the top level
+func (c *CategoryHandler) HandleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	category, err := c.GetCategory(id)
+	if err != nil {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if isUncategorised(category) {
+		http.Error(w, "The Uncategorised category can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		category.Name = name
+	}
+
+	category.ParentID = nil
+	if parentID := r.FormValue("parent_id"); parentID != "" {
+		parent, err := c.parentCategory(parentID)
+		if err != nil || parent.Kind != category.Kind {
+			http.Error(w, "Invalid parent category", http.StatusBadRequest)
+			return
+		}
+		cycle, err := c.isDescendant(parent, category.ID)
+		if err != nil {
+			c.Logger.Error(categoryError, "error", err)
+			http.Error(w, categoryError, http.StatusInternalServerError)
+			return
+		}
+		if cycle {
+			http.Error(w, "A category can't be moved under itself", http.StatusBadRequest)
+			return
+		}
+		category.ParentID = &parent.ID
+	}
+
+	err = c.UpdateCategory(category)
+	if err != nil {
+		c.Logger.Error("Failed to update category", "error", err)
+		http.Error(w, "Failed to update category", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetCategories(w, c)
+	if err != nil {
+		c.Logger.Error(categoryError, "error", err)
+	}
+}
+
+func (c *CategoryHandler) HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	category, err := c.GetCategory(id)
+	if err != nil {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	if isUncategorised(category) {
+		http.Error(w, "The Uncategorised category can't be deleted", http.StatusBadRequest)
+		return
+	}
+
+	err = c.DeleteCategory(id)
+	if errors.Is(err, database.ErrCategoryInUse) {
+		http.Error(w, "Category still has subcategories, transactions or budgets", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		c.Logger.Error("Failed to delete category", "error", err)
+		http.Error(w, "Failed to delete category", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetCategories(w, c)
+	if err != nil {
+		c.Logger.Error(categoryError, "error", err)
+	}
+}
+
+func (c *CategoryHandler) parentCategory(value string) (models.Category, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return models.Category{}, err
+	}
+	return c.GetCategory(id)
+}
+
+// isDescendant walks up from category and reports whether it reaches id
+func (c *CategoryHandler) isDescendant(category models.Category, id uint) (bool, error) {
+	for {
+		if category.ID == id {
+			return true, nil
+		}
+		if category.ParentID == nil {
+			return false, nil
+		}
+
+		var err error
+		category, err = c.GetCategory(int(*category.ParentID))
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// renders the whole category tree
+func executeGetCategories(w http.ResponseWriter, c *CategoryHandler) error {
+	categories, err := c.GetCategories("")
+	if err != nil {
+		http.Error(w, categoryError, http.StatusInternalServerError)
+		return err
+	}
+
+	tree := map[string][]models.Category{}
+	for _, node := range categoryTree(categories) {
+		tree[node.Kind] = append(tree[node.Kind], node)
+	}
+
+	tmpl, err := template.ParseFS(c.webFS, "web/components/categories.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, tree)
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
+
+// categoryTree nests a flat list of categories under their parents, returning
+// the top level with Uncategorised first so it's the default in pickers
+func categoryTree(flat []models.Category) []models.Category {
+	children := map[uint][]models.Category{}
+	var roots []models.Category
+	for _, category := range flat {
+		if isUncategorised(category) {
+			roots = append([]models.Category{category}, roots...)
+			continue
+		}
+		if category.ParentID == nil {
+			roots = append(roots, category)
+			continue
+		}
+		children[*category.ParentID] = append(children[*category.ParentID], category)
+	}
+
+	var attach func(nodes []models.Category) []models.Category
+	attach = func(nodes []models.Category) []models.Category {
+		for i := range nodes {
+			nodes[i].Children = attach(children[nodes[i].ID])
+		}
+		return nodes
+	}
+	return attach(roots)
+}
+
+// categoryFromForm reads the category_id form value for a new transaction,
+// falling back to Uncategorised when none was picked
+func categoryFromForm(r *http.Request, db database.Database, kind string) (models.Category, error) {
+	value := r.FormValue("category_id")
+	if value == "" {
+		return db.GetUncategorised(kind)
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return models.Category{}, err
+	}
+	category, err := db.GetCategory(id)
+	if err != nil {
+		return models.Category{}, err
+	}
+	if category.Kind != kind {
+		return models.Category{}, errors.New("category is for " + category.Kind + "s")
+	}
+	return category, nil
+}
+
+// selectedOption is the ?selected= id for an options list being used to edit
+// something, 0 when there isn't one
+func selectedOption(r *http.Request) uint {
+	id, err := strconv.Atoi(r.URL.Query().Get("selected"))
+	if err != nil || id < 0 {
+		return 0
+	}
+	return uint(id)
+}
+
+func isUncategorised(category models.Category) bool {
+	return category.ParentID == nil && category.Name == database.Uncategorised
+}
+
+func validCategoryKind(kind string) bool {
+	return kind == models.CategoryKindExpense || kind == models.CategoryKindIncome
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"embed"
+	"errors"
 	"html/template"
 	"log/slog"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/Ewan-Greer09/finance-app/api/database"
 	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
 )
 
 var parseTemplateError = "Failed to parse template"
@@ -20,47 +22,199 @@ var expenseError = "Failed to get expenses"
 type ExpenseHandler struct {
 	Logger *slog.Logger
 	database.Database
-	webFS embed.FS
+	webFS    embed.FS
+	currency string
 }
 
-func NewExpenseHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *ExpenseHandler {
+func NewExpenseHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *ExpenseHandler {
 	return &ExpenseHandler{
 		Logger:   logger,
 		Database: db,
 		webFS:    webFS,
+		currency: currency,
 	}
 }
 
 func (e *ExpenseHandler) Routes(r chi.Router) {
 	// api/v1/expense
+	r.Use(RequireUser(e.Database), RequireLedger(e.Database), RequireEditor)
 	r.Get("/", e.HandleGetExpenses)
 	r.Post("/", e.HandleAddExpense)
+	r.Put("/{id}", e.HandleUpdateExpense)
+	r.Patch("/{id}", e.HandleUpdateExpense)
 	r.Delete("/{id}", e.HandleDeleteExpense)
+	r.Get("/{id}/edit", e.HandleEditExpense)
+	r.Post("/{id}/tag", e.HandleAddExpenseTag)
+	r.Delete("/{id}/tag/{tag}", e.HandleRemoveExpenseTag)
+	r.Put("/{id}/split", e.HandleSetExpenseSplits)
+	r.Post("/{id}/unreconcile", e.HandleUnreconcileExpense)
 }
 
 func (e *ExpenseHandler) HandleAddExpense(w http.ResponseWriter, r *http.Request) {
-	amount := r.FormValue("amount")
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = e.currency
+	}
+	amount, err := money.Parse(r.FormValue("amount"), currency)
+	if err != nil {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
 	source := r.FormValue("expense")
+	day, err := occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+	category, err := categoryFromForm(r, e.Database, models.CategoryKindExpense)
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	account, err := accountFromForm(r, e.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+	tags, err := parseTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, "Invalid tags: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	splits, err := splitsFromForm(r, e.Database, amount.Currency)
+	if err != nil {
+		http.Error(w, "Invalid split: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// add expense to database
-	err := e.AddExpense(models.Expense{
-		Amount: amount,
-		Source: source,
+	err = e.As(auditActor(r)).AddExpense(models.Expense{
+		LedgerID:   CurrentMember(r).LedgerID,
+		UserID:     CurrentUser(r).ID,
+		Amount:     amount,
+		Source:     source,
+		Notes:      r.FormValue("notes"),
+		OccurredOn: day,
+		CategoryID: category.ID,
+		Tags:       tagModels(tags),
+		AccountID:  account.ID,
+		Splits:     splits,
 	})
+	if errors.Is(err, models.ErrInvalidSplits) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		e.Logger.Error("Failed to add expense", "error", err)
 		http.Error(w, "Failed to add expense", http.StatusInternalServerError)
 		return
 	}
 
-	err = executeGetExpenses(w, e)
+	err = executeGetExpenses(w, r, e)
 }
 
 func (e *ExpenseHandler) HandleGetExpenses(w http.ResponseWriter, r *http.Request) {
-	err := executeGetExpenses(w, e)
+	_, _, err := listQuery(r, e.currency)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// executeGetExpenses has already written the error response
+	err = executeGetExpenses(w, r, e)
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+	}
+}
+
+// renders the inline edit form that replaces an expense's card
+func (e *ExpenseHandler) HandleEditExpense(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+	expense, err := e.GetExpense(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, e.Logger, err, "Expense not found") {
+		return
+	}
 	if err != nil {
 		e.Logger.Error(expenseError, "error", err)
 		http.Error(w, expenseError, http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFS(e.webFS, "web/components/expense_edit.html")
+	if err != nil {
+		e.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, expense)
+	if err != nil {
+		e.Logger.Error(executeTemplateError, "error", err)
+	}
+}
+
+// PUT replaces an expense's source, notes, amount, date, category and account, PATCH
+// changes only the ones sent. Tags and split lines have their own routes.
+func (e *ExpenseHandler) HandleUpdateExpense(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+	ledgerID := CurrentMember(r).LedgerID
+	expense, err := e.GetExpense(ledgerID, id)
+	if notFound(w, r, e.Logger, err, "Expense not found") {
+		return
+	}
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+		http.Error(w, expenseError, http.StatusInternalServerError)
+		return
+	}
+
+	edited, err := editFromForm(r, e.Database, "expense", models.CategoryKindExpense, transactionFields{
+		Source:     expense.Source,
+		Notes:      expense.Notes,
+		Amount:     expense.Amount,
+		OccurredOn: expense.OccurredOn,
+		CategoryID: expense.CategoryID,
+		AccountID:  expense.AccountID,
+	})
+	if err != nil {
+		http.Error(w, "Invalid expense: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	expense.Source = edited.Source
+	expense.Notes = edited.Notes
+	expense.Amount = edited.Amount
+	expense.OccurredOn = edited.OccurredOn
+	expense.CategoryID = edited.CategoryID
+	expense.AccountID = edited.AccountID
+
+	err = e.As(auditActor(r)).UpdateExpense(ledgerID, expense)
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Expense is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, models.ErrInvalidSplits) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if notFound(w, r, e.Logger, err, "Expense not found") {
+		return
+	}
+	if err != nil {
+		e.Logger.Error("Failed to update expense", "error", err)
+		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetExpenses(w, r, e)
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
 	}
 }
 
@@ -72,32 +226,221 @@ func (e *ExpenseHandler) HandleDeleteExpense(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// delete expense from database
-	err = e.DeleteExpense(expID)
+	err = e.As(auditActor(r)).DeleteExpense(CurrentMember(r).LedgerID, expID)
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Expense is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, e.Logger, err, "Expense not found") {
+		return
+	}
 	if err != nil {
 		e.Logger.Error("Failed to delete expense", "error", err)
 		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
 		return
 	}
 
-	err = executeGetExpenses(w, e)
+	err = executeGetExpenses(w, r, e)
+}
+
+// attaches the comma separated tags in the tag form value
+func (e *ExpenseHandler) HandleAddExpenseTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+	tags, err := parseTags(r.FormValue("tag"))
+	if err != nil || len(tags) == 0 {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	for _, tag := range tags {
+		err = e.As(auditActor(r)).AddExpenseTag(CurrentMember(r).LedgerID, id, tag)
+		if notFound(w, r, e.Logger, err, "Expense not found") {
+			return
+		}
+		if err != nil {
+			e.Logger.Error("Failed to tag expense", "error", err)
+			http.Error(w, "Failed to tag expense", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = executeGetExpenses(w, r, e)
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+	}
 }
 
-func executeGetExpenses(w http.ResponseWriter, e *ExpenseHandler) error {
-	expenses, err := e.GetExpenses()
+func (e *ExpenseHandler) HandleRemoveExpenseTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+	tag, err := normalizeTag(chi.URLParam(r, "tag"))
+	if err != nil {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	err = e.As(auditActor(r)).RemoveExpenseTag(CurrentMember(r).LedgerID, id, tag)
+	if notFound(w, r, e.Logger, err, "Expense or tag not found") {
+		return
+	}
+	if err != nil {
+		e.Logger.Error("Failed to untag expense", "error", err)
+		http.Error(w, "Failed to untag expense", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetExpenses(w, r, e)
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+	}
+}
+
+// replaces the split lines of an expense, posting no lines removes the split
+func (e *ExpenseHandler) HandleSetExpenseSplits(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+	expense, err := e.GetExpense(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, e.Logger, err, "Expense not found") {
+		return
+	}
 	if err != nil {
 		e.Logger.Error(expenseError, "error", err)
 		http.Error(w, expenseError, http.StatusInternalServerError)
+		return
+	}
+
+	splits, err := splitsFromForm(r, e.Database, expense.Amount.Currency)
+	if err != nil {
+		http.Error(w, "Invalid split: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = e.As(auditActor(r)).SetExpenseSplits(CurrentMember(r).LedgerID, id, splits)
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Expense is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, models.ErrInvalidSplits) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		e.Logger.Error("Failed to split expense", "error", err)
+		http.Error(w, "Failed to split expense", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetExpenses(w, r, e)
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+	}
+}
+
+// unlocks a reconciled expense so it can be changed again, it goes back to cleared
+func (e *ExpenseHandler) HandleUnreconcileExpense(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	err = e.As(auditActor(r)).UnreconcileExpense(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrNotReconciled) {
+		http.Error(w, "Expense isn't reconciled", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, e.Logger, err, "Expense not found") {
+		return
+	}
+	if err != nil {
+		e.Logger.Error("Failed to un-reconcile expense", "error", err)
+		http.Error(w, "Failed to un-reconcile expense", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetExpenses(w, r, e)
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+	}
+}
+
+// splitsFromForm reads the repeated split_amount and split_category_id form
+// values, skipping lines left blank
+func splitsFromForm(r *http.Request, db database.Database, currency string) ([]models.ExpenseSplit, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	amounts, categories := r.Form["split_amount"], r.Form["split_category_id"]
+	if len(amounts) != len(categories) {
+		return nil, errors.New("every line needs an amount and a category")
+	}
+
+	var splits []models.ExpenseSplit
+	for i, value := range amounts {
+		if value == "" {
+			continue
+		}
+		amount, err := money.Parse(value, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := strconv.Atoi(categories[i])
+		if err != nil {
+			return nil, errors.New("every line needs a category")
+		}
+		category, err := db.GetCategory(id)
+		if err != nil || category.Kind != models.CategoryKindExpense {
+			return nil, errors.New("invalid category")
+		}
+
+		splits = append(splits, models.ExpenseSplit{Amount: amount, CategoryID: category.ID})
+	}
+	return splits, nil
+}
+
+func executeGetExpenses(w http.ResponseWriter, r *http.Request, e *ExpenseHandler) error {
+	filter, page, err := listQuery(r, e.currency)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
 		return err
 	}
 
-	// pass expenses to template
+	expenses, next, err := e.GetExpenses(CurrentMember(r).LedgerID, filter, page)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return err
+	}
+	if err != nil {
+		e.Logger.Error(expenseError, "error", err)
+		http.Error(w, expenseError, http.StatusInternalServerError)
+		return err
+	}
+	view := pageView{Items: expenses, More: nextPageURL(r, "/api/v1/expense", page, next)}
+
+	// later pages are only the cards, they're appended to the list already shown
 	tmpl, err := template.ParseFS(e.webFS, "web/components/expenses.html")
 	if err != nil {
 		e.Logger.Error(parseTemplateError, "error", err)
 		http.Error(w, parseTemplateError, http.StatusInternalServerError)
 		return err
 	}
-	err = tmpl.Execute(w, expenses)
+	name := "expenses.html"
+	if isNextPage(r) {
+		name = "expense-cards"
+	}
+	err = tmpl.ExecuteTemplate(w, name, view)
 	if err != nil {
 		e.Logger.Error(executeTemplateError, "error", err)
 		http.Error(w, executeTemplateError, http.StatusInternalServerError)
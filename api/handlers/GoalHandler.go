@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/graphs"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var goalError = "Failed to get goals"
+
+type GoalHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewGoalHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *GoalHandler {
+	return &GoalHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (g *GoalHandler) Routes(r chi.Router) {
+	// api/v1/goal
+	r.Use(RequireUser(g.Database), RequireLedger(g.Database), RequireEditor)
+	r.Get("/", g.HandleGetGoals)
+	r.Post("/", g.HandleAddGoal)
+	r.Delete("/{id}", g.HandleDeleteGoal)
+	r.Get("/{id}/graph", g.HandleGetGoalGraph)
+	r.Post("/{id}/contribution", g.HandleAddContribution)
+	r.Delete("/contribution/{id}", g.HandleDeleteContribution)
+}
+
+// renders every goal with its percentage, required monthly contribution and
+// projected completion date
+func (g *GoalHandler) HandleGetGoals(w http.ResponseWriter, r *http.Request) {
+	err := executeGetGoals(w, r, g)
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+	}
+}
+
+func (g *GoalHandler) HandleAddGoal(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	account, err := accountFromForm(r, g.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+
+	// the target defaults to the linked account's currency
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = account.Currency()
+	}
+	target, err := money.Parse(r.FormValue("target"), currency)
+	if err != nil || target.IsNegative() || target.IsZero() {
+		http.Error(w, "Invalid target", http.StatusBadRequest)
+		return
+	}
+
+	goal := models.Goal{
+		LedgerID:  CurrentMember(r).LedgerID,
+		Name:      name,
+		Target:    target,
+		AccountID: account.ID,
+	}
+	if value := r.FormValue("target_date"); value != "" {
+		date, err := ParseDay(value)
+		if err != nil || !date.After(Today()) {
+			http.Error(w, "Target date must be in the future", http.StatusBadRequest)
+			return
+		}
+		goal.TargetDate = &date
+	}
+
+	err = g.AddGoal(goal)
+	if err != nil {
+		g.Logger.Error("Failed to add goal", "error", err)
+		http.Error(w, "Failed to add goal", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetGoals(w, r, g)
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+	}
+}
+
+func (g *GoalHandler) HandleDeleteGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid goal ID", http.StatusBadRequest)
+		return
+	}
+
+	err = g.DeleteGoal(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, g.Logger, err, "Goal not found") {
+		return
+	}
+	if err != nil {
+		g.Logger.Error("Failed to delete goal", "error", err)
+		http.Error(w, "Failed to delete goal", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetGoals(w, r, g)
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+	}
+}
+
+// records money put towards a goal, in the goal's currency. Negative amounts
+// are withdrawals.
+func (g *GoalHandler) HandleAddContribution(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid goal ID", http.StatusBadRequest)
+		return
+	}
+	goal, err := g.GetGoal(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, g.Logger, err, "Goal not found") {
+		return
+	}
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+		http.Error(w, goalError, http.StatusInternalServerError)
+		return
+	}
+
+	amount, err := money.Parse(r.FormValue("amount"), goal.Target.Currency)
+	if err != nil || amount.IsZero() {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	day, err := occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+
+	err = g.AddContribution(models.GoalContribution{
+		GoalID:     goal.ID,
+		Amount:     amount,
+		OccurredOn: day,
+	})
+	if err != nil {
+		g.Logger.Error("Failed to add contribution", "error", err)
+		http.Error(w, "Failed to add contribution", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetGoals(w, r, g)
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+	}
+}
+
+func (g *GoalHandler) HandleDeleteContribution(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid contribution ID", http.StatusBadRequest)
+		return
+	}
+
+	err = g.DeleteContribution(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, g.Logger, err, "Contribution not found") {
+		return
+	}
+	if err != nil {
+		g.Logger.Error("Failed to delete contribution", "error", err)
+		http.Error(w, "Failed to delete contribution", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetGoals(w, r, g)
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+	}
+}
+
+// charts the amount saved over time against the target, with a dashed line
+// from today to the projected completion date
+func (g *GoalHandler) HandleGetGoalGraph(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid goal ID", http.StatusBadRequest)
+		return
+	}
+	goal, err := g.GetGoal(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, g.Logger, err, "Goal not found") {
+		return
+	}
+	if err != nil {
+		g.Logger.Error(goalError, "error", err)
+		http.Error(w, goalError, http.StatusInternalServerError)
+		return
+	}
+
+	today := Today()
+	progress := goal.Progress(today)
+
+	point := func(t time.Time, m money.Money) opts.LineData {
+		return opts.LineData{Value: []interface{}{t.Format(time.DateOnly), m.Float64()}}
+	}
+
+	start, saved := today, money.New(0, goal.Target.Currency)
+	var history []opts.LineData
+	for _, c := range goal.Contributions {
+		if c.OccurredOn.After(today) {
+			continue
+		}
+		if c.OccurredOn.Before(start) {
+			start = c.OccurredOn
+		}
+		saved = money.New(saved.Minor+c.Amount.Minor, saved.Currency)
+		history = append(history, point(c.OccurredOn, saved))
+	}
+	history = append(history, point(today, progress.Saved))
+
+	end := today
+	if progress.Projected != nil && progress.Projected.After(end) {
+		end = *progress.Projected
+	}
+	if goal.TargetDate != nil && goal.TargetDate.After(end) {
+		end = *goal.TargetDate
+	}
+
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title:    goal.Name,
+			Subtitle: "Saved towards " + goal.Target.Format(),
+		}),
+		charts.WithXAxisOpts(opts.XAxis{Type: "time"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+	)
+	line.AddSeries("Saved", history, charts.WithLineChartOpts(opts.LineChart{Step: "end"}))
+	line.AddSeries("Target", []opts.LineData{
+		point(start, goal.Target),
+		point(end, goal.Target),
+	})
+	if progress.Projected != nil && progress.Projected.After(today) {
+		line.AddSeries("Projected", []opts.LineData{
+			point(today, progress.Saved),
+			point(*progress.Projected, goal.Target),
+		}, charts.WithLineStyleOpts(opts.LineStyle{Type: "dashed"}))
+	}
+
+	snippet, err := graphs.Render(line)
+	if err != nil {
+		g.Logger.Error("Failed to render graph", "error", err)
+		http.Error(w, "Failed to render graph", http.StatusInternalServerError)
+		return
+	}
+
+	// the snippet is trusted script, so it goes through text/template like graph.html
+	tmpl, err := textTemplate.ParseFS(g.webFS, "web/components/goal_graph.html")
+	if err != nil {
+		g.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, snippet)
+	if err != nil {
+		g.Logger.Error(executeTemplateError, "error", err)
+	}
+}
+
+func executeGetGoals(w http.ResponseWriter, r *http.Request, g *GoalHandler) error {
+	goals, err := g.GetGoals(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, goalError, http.StatusInternalServerError)
+		return err
+	}
+
+	today := Today()
+	progress := make([]models.GoalProgress, 0, len(goals))
+	for _, goal := range goals {
+		progress = append(progress, goal.Progress(today))
+	}
+
+	tmpl, err := template.ParseFS(g.webFS, "web/components/goals.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, progress)
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"embed"
+	"errors"
 	"html/template"
 	"log/slog"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/Ewan-Greer09/finance-app/api/database"
 	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
 )
 
 var incomeError = "Failed to get incomes"
@@ -18,32 +20,76 @@ var incomeError = "Failed to get incomes"
 type IncomeHandler struct {
 	Logger *slog.Logger
 	database.Database
-	webFS embed.FS
+	webFS    embed.FS
+	currency string
 }
 
-func NewIncomeHandler(logger *slog.Logger, db database.Database, fs embed.FS) *IncomeHandler {
+func NewIncomeHandler(logger *slog.Logger, db database.Database, fs embed.FS, currency string) *IncomeHandler {
 	return &IncomeHandler{
 		Logger:   logger,
 		Database: db,
 		webFS:    fs,
+		currency: currency,
 	}
 }
 
 func (h *IncomeHandler) Routes(r chi.Router) {
 	// api/v1/income
+	r.Use(RequireUser(h.Database), RequireLedger(h.Database), RequireEditor)
 	r.Post("/", h.HandleAddIncome)
 	r.Get("/", h.HandleGetIncomes)
+	r.Put("/{id}", h.HandleUpdateIncome)
+	r.Patch("/{id}", h.HandleUpdateIncome)
 	r.Delete("/{id}", h.HandleDeleteIncome)
+	r.Get("/{id}/edit", h.HandleEditIncome)
+	r.Post("/{id}/tag", h.HandleAddIncomeTag)
+	r.Delete("/{id}/tag/{tag}", h.HandleRemoveIncomeTag)
+	r.Post("/{id}/unreconcile", h.HandleUnreconcileIncome)
 }
 
 func (h *IncomeHandler) HandleAddIncome(w http.ResponseWriter, r *http.Request) {
-	amount := r.FormValue("amount")
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = h.currency
+	}
+	amount, err := money.Parse(r.FormValue("amount"), currency)
+	if err != nil {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
 	source := r.FormValue("income")
+	day, err := occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+	category, err := categoryFromForm(r, h.Database, models.CategoryKindIncome)
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	account, err := accountFromForm(r, h.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+	tags, err := parseTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, "Invalid tags: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// add income to database
-	err := h.AddIncome(models.Income{
-		Amount: amount,
-		Source: source,
+	err = h.As(auditActor(r)).AddIncome(models.Income{
+		LedgerID:   CurrentMember(r).LedgerID,
+		UserID:     CurrentUser(r).ID,
+		Amount:     amount,
+		Source:     source,
+		Notes:      r.FormValue("notes"),
+		OccurredOn: day,
+		CategoryID: category.ID,
+		Tags:       tagModels(tags),
+		AccountID:  account.ID,
 	})
 	if err != nil {
 		h.Logger.Error("Failed to add income", "error", err)
@@ -51,7 +97,7 @@ func (h *IncomeHandler) HandleAddIncome(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = executeGetIncomes(w, h)
+	err = executeGetIncomes(w, r, h)
 	if err != nil {
 		h.Logger.Error(incomeError, "error", err)
 		http.Error(w, incomeError, http.StatusInternalServerError)
@@ -59,10 +105,103 @@ func (h *IncomeHandler) HandleAddIncome(w http.ResponseWriter, r *http.Request)
 }
 
 func (h *IncomeHandler) HandleGetIncomes(w http.ResponseWriter, r *http.Request) {
-	err := executeGetIncomes(w, h)
+	_, _, err := listQuery(r, h.currency)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// executeGetIncomes has already written the error response
+	err = executeGetIncomes(w, r, h)
+	if err != nil {
+		h.Logger.Error(incomeError, "error", err)
+	}
+}
+
+// renders the inline edit form that replaces an income's card
+func (h *IncomeHandler) HandleEditIncome(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid income ID", http.StatusBadRequest)
+		return
+	}
+	income, err := h.GetIncome(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, h.Logger, err, "Income not found") {
+		return
+	}
 	if err != nil {
 		h.Logger.Error(incomeError, "error", err)
 		http.Error(w, incomeError, http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFS(h.webFS, "web/components/income_edit.html")
+	if err != nil {
+		h.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, income)
+	if err != nil {
+		h.Logger.Error(executeTemplateError, "error", err)
+	}
+}
+
+// PUT replaces an income's source, notes, amount, date, category and account, PATCH
+// changes only the ones sent. Tags have their own routes.
+func (h *IncomeHandler) HandleUpdateIncome(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid income ID", http.StatusBadRequest)
+		return
+	}
+	ledgerID := CurrentMember(r).LedgerID
+	income, err := h.GetIncome(ledgerID, id)
+	if notFound(w, r, h.Logger, err, "Income not found") {
+		return
+	}
+	if err != nil {
+		h.Logger.Error(incomeError, "error", err)
+		http.Error(w, incomeError, http.StatusInternalServerError)
+		return
+	}
+
+	edited, err := editFromForm(r, h.Database, "income", models.CategoryKindIncome, transactionFields{
+		Source:     income.Source,
+		Notes:      income.Notes,
+		Amount:     income.Amount,
+		OccurredOn: income.OccurredOn,
+		CategoryID: income.CategoryID,
+		AccountID:  income.AccountID,
+	})
+	if err != nil {
+		http.Error(w, "Invalid income: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	income.Source = edited.Source
+	income.Notes = edited.Notes
+	income.Amount = edited.Amount
+	income.OccurredOn = edited.OccurredOn
+	income.CategoryID = edited.CategoryID
+	income.AccountID = edited.AccountID
+
+	err = h.As(auditActor(r)).UpdateIncome(ledgerID, income)
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Income is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, h.Logger, err, "Income not found") {
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Failed to update income", "error", err)
+		http.Error(w, "Failed to update income", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetIncomes(w, r, h)
+	if err != nil {
+		h.Logger.Error(incomeError, "error", err)
 	}
 }
 
@@ -74,14 +213,21 @@ func (h *IncomeHandler) HandleDeleteIncome(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = h.DeleteIncome(incID)
+	err = h.As(auditActor(r)).DeleteIncome(CurrentMember(r).LedgerID, incID)
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Income is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, h.Logger, err, "Income not found") {
+		return
+	}
 	if err != nil {
 		h.Logger.Error("Failed to delete income", "error", err)
 		http.Error(w, "Failed to delete income", http.StatusInternalServerError)
 		return
 	}
 
-	err = executeGetIncomes(w, h)
+	err = executeGetIncomes(w, r, h)
 	if err != nil {
 		h.Logger.Error(incomeError, "error", err)
 		http.Error(w, incomeError, http.StatusInternalServerError)
@@ -89,22 +235,124 @@ func (h *IncomeHandler) HandleDeleteIncome(w http.ResponseWriter, r *http.Reques
 }
 
 // reads incomes from database and passes them to the template
-func executeGetIncomes(w http.ResponseWriter, h *IncomeHandler) error {
-	incomes, err := h.GetIncomes()
+// attaches the comma separated tags in the tag form value
+func (h *IncomeHandler) HandleAddIncomeTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid income ID", http.StatusBadRequest)
+		return
+	}
+	tags, err := parseTags(r.FormValue("tag"))
+	if err != nil || len(tags) == 0 {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	for _, tag := range tags {
+		err = h.As(auditActor(r)).AddIncomeTag(CurrentMember(r).LedgerID, id, tag)
+		if notFound(w, r, h.Logger, err, "Income not found") {
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Failed to tag income", "error", err)
+			http.Error(w, "Failed to tag income", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = executeGetIncomes(w, r, h)
+	if err != nil {
+		h.Logger.Error(incomeError, "error", err)
+	}
+}
+
+func (h *IncomeHandler) HandleRemoveIncomeTag(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid income ID", http.StatusBadRequest)
+		return
+	}
+	tag, err := normalizeTag(chi.URLParam(r, "tag"))
+	if err != nil {
+		http.Error(w, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	err = h.As(auditActor(r)).RemoveIncomeTag(CurrentMember(r).LedgerID, id, tag)
+	if notFound(w, r, h.Logger, err, "Income or tag not found") {
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Failed to untag income", "error", err)
+		http.Error(w, "Failed to untag income", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetIncomes(w, r, h)
+	if err != nil {
+		h.Logger.Error(incomeError, "error", err)
+	}
+}
+
+// unlocks a reconciled income so it can be changed again, it goes back to cleared
+func (h *IncomeHandler) HandleUnreconcileIncome(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid income ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.As(auditActor(r)).UnreconcileIncome(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrNotReconciled) {
+		http.Error(w, "Income isn't reconciled", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, h.Logger, err, "Income not found") {
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Failed to un-reconcile income", "error", err)
+		http.Error(w, "Failed to un-reconcile income", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetIncomes(w, r, h)
+	if err != nil {
+		h.Logger.Error(incomeError, "error", err)
+	}
+}
+
+func executeGetIncomes(w http.ResponseWriter, r *http.Request, h *IncomeHandler) error {
+	filter, page, err := listQuery(r, h.currency)
+	if err != nil {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	incomes, next, err := h.GetIncomes(CurrentMember(r).LedgerID, filter, page)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		http.Error(w, "Invalid filter: "+err.Error(), http.StatusBadRequest)
+		return err
+	}
 	if err != nil {
 		h.Logger.Error(incomeError, "error", err)
 		http.Error(w, incomeError, http.StatusInternalServerError)
 		return err
 	}
+	view := pageView{Items: incomes, More: nextPageURL(r, "/api/v1/income", page, next)}
 
+	// later pages are only the cards, they're appended to the list already shown
 	tmpl, err := template.ParseFS(h.webFS, "web/components/incomes.html")
 	if err != nil {
 		h.Logger.Error(parseTemplateError, "error", err)
 		http.Error(w, parseTemplateError, http.StatusInternalServerError)
 		return err
 	}
-
-	err = tmpl.Execute(w, incomes)
+	name := "incomes.html"
+	if isNextPage(r) {
+		name = "income-cards"
+	}
+	err = tmpl.ExecuteTemplate(w, name, view)
 	if err != nil {
 		h.Logger.Error(executeTemplateError, "error", err)
 		http.Error(w, executeTemplateError, http.StatusInternalServerError)
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/graphs"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var investmentError = "Failed to get investments"
+
+var tradeKinds = []string{
+	models.TradeBuy,
+	models.TradeSell,
+	models.TradeDividend,
+}
+
+// how many of the latest trades the panel lists
+const recentTrades = 20
+
+type InvestmentHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS    embed.FS
+	currency string
+}
+
+func NewInvestmentHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *InvestmentHandler {
+	return &InvestmentHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+		currency: currency,
+	}
+}
+
+func (ih *InvestmentHandler) Routes(r chi.Router) {
+	// api/v1/investment
+	r.Use(RequireUser(ih.Database), RequireLedger(ih.Database), RequireEditor)
+	r.Get("/", ih.HandleGetInvestments)
+	r.Get("/graph", ih.HandleGetInvestmentGraph)
+	r.Post("/security", ih.HandleAddSecurity)
+	r.Post("/trade", ih.HandleAddTrade)
+	r.Delete("/trade/{id}", ih.HandleDeleteTrade)
+	r.Post("/trade/{id}/unreconcile", ih.HandleUnreconcileTrade)
+}
+
+type investmentsView struct {
+	Holdings   []models.Holding
+	Totals     models.Portfolio
+	Currency   string
+	Trades     []models.Trade
+	Securities []models.Security
+	Kinds      []string
+}
+
+// renders each holding with its market value, cost basis and gains, the
+// portfolio's totals in the user's reporting currency and the latest trades
+func (ih *InvestmentHandler) HandleGetInvestments(w http.ResponseWriter, r *http.Request) {
+	err := executeGetInvestments(w, r, ih)
+	if err != nil {
+		ih.Logger.Error(investmentError, "error", err)
+	}
+}
+
+func (ih *InvestmentHandler) HandleAddSecurity(w http.ResponseWriter, r *http.Request) {
+	symbol := strings.TrimSpace(r.FormValue("symbol"))
+	if symbol == "" {
+		http.Error(w, "Symbol is required", http.StatusBadRequest)
+		return
+	}
+	currency := strings.ToUpper(r.FormValue("currency"))
+	if currency == "" {
+		currency = ih.currency
+	}
+	if !money.ValidCurrency(currency) {
+		http.Error(w, "Invalid currency", http.StatusBadRequest)
+		return
+	}
+
+	err := ih.AddSecurity(models.Security{
+		Symbol:   symbol,
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		Currency: currency,
+	})
+	if errors.Is(err, database.ErrSecurityExists) {
+		http.Error(w, "Security "+strings.ToUpper(symbol)+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ih.Logger.Error("Failed to add security", "error", err)
+		http.Error(w, "Failed to add security", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetInvestments(w, r, ih)
+	if err != nil {
+		ih.Logger.Error(investmentError, "error", err)
+	}
+}
+
+// records a buy, sell or dividend in a brokerage or ISA account. The amount
+// is the total in the security's currency, a dividend is also booked as an
+// income under category_id.
+func (ih *InvestmentHandler) HandleAddTrade(w http.ResponseWriter, r *http.Request) {
+	account, err := accountFromForm(r, ih.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+	if account.Type != models.AccountTypeBrokerage && account.Type != models.AccountTypeISA {
+		http.Error(w, "Investments are held in brokerage and ISA accounts", http.StatusBadRequest)
+		return
+	}
+	securityID, err := strconv.Atoi(r.FormValue("security_id"))
+	if err != nil {
+		http.Error(w, "Invalid security", http.StatusBadRequest)
+		return
+	}
+	security, err := ih.GetSecurity(securityID)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "Security not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		ih.Logger.Error("Failed to get security", "error", err)
+		http.Error(w, "Failed to get security", http.StatusInternalServerError)
+		return
+	}
+
+	trade := models.Trade{
+		LedgerID:   CurrentMember(r).LedgerID,
+		AccountID:  account.ID,
+		SecurityID: security.ID,
+		Security:   security,
+		Kind:       r.FormValue("kind"),
+	}
+	if value := r.FormValue("quantity"); value != "" {
+		trade.Quantity, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, "Invalid quantity", http.StatusBadRequest)
+			return
+		}
+	}
+	trade.Amount, err = money.Parse(r.FormValue("amount"), security.Currency)
+	if err != nil {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+	trade.TradedOn, err = occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+
+	var categoryID uint
+	if trade.Kind == models.TradeDividend {
+		category, err := categoryFromForm(r, ih.Database, models.CategoryKindIncome)
+		if err != nil {
+			http.Error(w, "Invalid category", http.StatusBadRequest)
+			return
+		}
+		categoryID = category.ID
+	}
+
+	err = ih.As(auditActor(r)).AddTrade(trade, categoryID, CurrentUser(r).ID)
+	if errors.Is(err, models.ErrInvalidTrade) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, database.ErrNotEnoughHeld) {
+		http.Error(w, "Can't sell more "+security.Symbol+" than the account held", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ih.Logger.Error("Failed to add trade", "error", err)
+		http.Error(w, "Failed to add trade", http.StatusInternalServerError)
+		return
+	}
+
+	if trade.Kind == models.TradeDividend {
+		w.Header().Set("HX-Trigger", "dividendBooked")
+	}
+	err = executeGetInvestments(w, r, ih)
+	if err != nil {
+		ih.Logger.Error(investmentError, "error", err)
+	}
+}
+
+func (ih *InvestmentHandler) HandleDeleteTrade(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid trade ID", http.StatusBadRequest)
+		return
+	}
+
+	err = ih.As(auditActor(r)).DeleteTrade(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrNotEnoughHeld) {
+		http.Error(w, "Later sells need what this trade bought", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "The trade or its dividend's income is reconciled, un-reconcile it first", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, ih.Logger, err, "Trade not found") {
+		return
+	}
+	if err != nil {
+		ih.Logger.Error("Failed to delete trade", "error", err)
+		http.Error(w, "Failed to delete trade", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "dividendBooked")
+	err = executeGetInvestments(w, r, ih)
+	if err != nil {
+		ih.Logger.Error(investmentError, "error", err)
+	}
+}
+
+// unlocks a reconciled buy or sell so it can be deleted, it goes back to cleared
+func (ih *InvestmentHandler) HandleUnreconcileTrade(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid trade ID", http.StatusBadRequest)
+		return
+	}
+
+	err = ih.UnreconcileTrade(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrNotReconciled) {
+		http.Error(w, "Trade isn't reconciled", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, ih.Logger, err, "Trade not found") {
+		return
+	}
+	if err != nil {
+		ih.Logger.Error("Failed to un-reconcile trade", "error", err)
+		http.Error(w, "Failed to un-reconcile trade", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetInvestments(w, r, ih)
+	if err != nil {
+		ih.Logger.Error(investmentError, "error", err)
+	}
+}
+
+// charts the portfolio's market value against what was paid for it each week
+// since the first trade, and how the value is split between holdings today
+func (ih *InvestmentHandler) HandleGetInvestmentGraph(w http.ResponseWriter, r *http.Request) {
+	trades, lookup, err := ih.portfolio(r)
+	if err != nil {
+		ih.Logger.Error(investmentError, "error", err)
+		http.Error(w, investmentError, http.StatusInternalServerError)
+		return
+	}
+
+	today := Today()
+	currency := ReportingCurrency(r, ih.currency)
+	conv := rates.NewConverter(ih.Database)
+
+	var value, cost []opts.LineData
+	point := func(t time.Time, m money.Money) opts.LineData {
+		return opts.LineData{Value: []interface{}{t.Format(time.DateOnly), m.Float64()}}
+	}
+	if len(trades) > 0 {
+		for day := trades[0].TradedOn; ; day = day.AddDate(0, 0, 7) {
+			if day.After(today) {
+				day = today
+			}
+			totals := models.ValuePortfolio(models.Holdings(trades, lookup, day), conv.Convert, currency, day)
+			value = append(value, point(day, totals.MarketValue))
+			cost = append(cost, point(day, totals.CostBasis))
+			if !day.Before(today) {
+				break
+			}
+		}
+	}
+
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title:    "Investments",
+			Subtitle: "Market value against cost, in " + currency,
+		}),
+		charts.WithXAxisOpts(opts.XAxis{Type: "time"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+	)
+	line.AddSeries("Market value", value)
+	line.AddSeries("Cost", cost, charts.WithLineChartOpts(opts.LineChart{Step: "end"}))
+
+	byHolding := map[string]money.Money{}
+	for _, h := range models.Holdings(trades, lookup, today) {
+		if !h.Priced {
+			continue
+		}
+		amount, err := conv.Convert(h.MarketValue, currency, today)
+		if err != nil {
+			ih.Logger.Warn("Skipping holding in graph", "symbol", h.Security.Symbol, "error", err)
+			continue
+		}
+		byHolding[h.Security.Symbol], _ = byHolding[h.Security.Symbol].Add(amount)
+	}
+	symbols := make([]string, 0, len(byHolding))
+	for symbol := range byHolding {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	data := make([]opts.PieData, 0, len(symbols))
+	for _, symbol := range symbols {
+		data = append(data, opts.PieData{Name: symbol, Value: byHolding[symbol].Float64()})
+	}
+
+	pie := charts.NewPie()
+	pie.SetGlobalOptions(charts.WithTitleOpts(opts.Title{
+		Title:    "Holdings",
+		Subtitle: "Market value today, in " + currency,
+	}))
+	pie.AddSeries("Holdings", data)
+
+	var snippets []string
+	for _, c := range []graphs.Chart{line, pie} {
+		snippet, err := graphs.Render(c)
+		if err != nil {
+			ih.Logger.Error("Failed to render graph", "error", err)
+			http.Error(w, "Failed to render graph", http.StatusInternalServerError)
+			return
+		}
+		snippets = append(snippets, snippet)
+	}
+
+	// the snippets are trusted script, so they go through text/template like graph.html
+	tmpl, err := textTemplate.ParseFS(ih.webFS, "web/components/investment_graph.html")
+	if err != nil {
+		ih.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, snippets)
+	if err != nil {
+		ih.Logger.Error(executeTemplateError, "error", err)
+	}
+}
+
+// portfolio loads the ledger's trades and the prices to value them with
+func (ih *InvestmentHandler) portfolio(r *http.Request) ([]models.Trade, models.PriceLookup, error) {
+	trades, err := ih.GetTrades(CurrentMember(r).LedgerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	prices, err := ih.GetPrices()
+	if err != nil {
+		return nil, nil, err
+	}
+	return trades, models.PriceHistory(prices), nil
+}
+
+func executeGetInvestments(w http.ResponseWriter, r *http.Request, ih *InvestmentHandler) error {
+	trades, lookup, err := ih.portfolio(r)
+	if err != nil {
+		http.Error(w, investmentError, http.StatusInternalServerError)
+		return err
+	}
+	securities, err := ih.GetSecurities()
+	if err != nil {
+		http.Error(w, investmentError, http.StatusInternalServerError)
+		return err
+	}
+
+	today := Today()
+	currency := ReportingCurrency(r, ih.currency)
+	holdings := models.Holdings(trades, lookup, today)
+
+	// newest first
+	recent := make([]models.Trade, 0, recentTrades)
+	for i := len(trades) - 1; i >= 0 && len(recent) < recentTrades; i-- {
+		recent = append(recent, trades[i])
+	}
+
+	tmpl, err := template.ParseFS(ih.webFS, "web/components/investments.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, investmentsView{
+		Holdings:   holdings,
+		Totals:     models.ValuePortfolio(holdings, rates.NewConverter(ih.Database).Convert, currency, today),
+		Currency:   currency,
+		Trades:     recent,
+		Securities: securities,
+		Kinds:      tradeKinds,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
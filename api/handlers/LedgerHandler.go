@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+var ledgerError = "Failed to get ledgers"
+
+var ledgerRoles = []string{
+	models.RoleViewer,
+	models.RoleEditor,
+	models.RoleOwner,
+}
+
+type LedgerHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewLedgerHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *LedgerHandler {
+	return &LedgerHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (l *LedgerHandler) Routes(r chi.Router) {
+	// api/v1/ledger
+	r.Use(RequireUser(l.Database), RequireLedger(l.Database))
+	r.Get("/", l.HandleGetLedgers)
+	r.Post("/", l.HandleAddLedger)
+	r.Post("/switch", l.HandleSwitchLedger)
+	r.Post("/member", l.HandleAddMember)
+	r.Put("/member/{id}", l.HandleSetMemberRole)
+	r.Delete("/member/{id}", l.HandleRemoveMember)
+}
+
+type ledgersView struct {
+	Current models.LedgerMember
+	Ledgers []models.LedgerMember
+	Members []models.LedgerMember
+	Roles   []string
+	UserID  uint
+}
+
+// renders the ledger switcher and the current ledger's members
+func (l *LedgerHandler) HandleGetLedgers(w http.ResponseWriter, r *http.Request) {
+	err := executeGetLedgers(w, l, CurrentUser(r), CurrentMember(r))
+	if err != nil {
+		l.Logger.Error(ledgerError, "error", err)
+	}
+}
+
+// creates a ledger owned by the current user and switches to it
+func (l *LedgerHandler) HandleAddLedger(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	user := CurrentUser(r)
+	ledger, err := l.AddLedger(models.Ledger{Name: name}, user.ID)
+	if err != nil {
+		l.Logger.Error("Failed to add ledger", "error", err)
+		http.Error(w, "Failed to add ledger", http.StatusInternalServerError)
+		return
+	}
+
+	l.switchTo(w, user, ledger.ID)
+}
+
+func (l *LedgerHandler) HandleSwitchLedger(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("ledger_id"))
+	if err != nil {
+		http.Error(w, "Invalid ledger ID", http.StatusBadRequest)
+		return
+	}
+
+	l.switchTo(w, CurrentUser(r), uint(id))
+}
+
+// switchTo remembers the ledger and has HTMX reload the page, since every
+// section shows the current ledger's records
+func (l *LedgerHandler) switchTo(w http.ResponseWriter, user models.User, ledgerID uint) {
+	member, err := l.GetMember(ledgerID, user.ID)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "Ledger not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		l.Logger.Error(ledgerError, "error", err)
+		http.Error(w, ledgerError, http.StatusInternalServerError)
+		return
+	}
+
+	setLedgerCookie(member.LedgerID, w)
+	w.Header().Set("HX-Refresh", "true")
+
+	err = executeGetLedgers(w, l, user, member)
+	if err != nil {
+		l.Logger.Error(ledgerError, "error", err)
+	}
+}
+
+// invites a user to the current ledger by username, only owners manage members
+func (l *LedgerHandler) HandleAddMember(w http.ResponseWriter, r *http.Request) {
+	current := CurrentMember(r)
+	if !current.IsOwner() {
+		http.Error(w, "Only owners can manage members", http.StatusForbidden)
+		return
+	}
+
+	role, ok := roleFromForm(r)
+	if !ok {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	user, err := l.GetUser(strings.TrimSpace(r.FormValue("username")))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = l.AddMember(models.LedgerMember{
+		LedgerID: current.LedgerID,
+		UserID:   user.ID,
+		Role:     role,
+	})
+	if errors.Is(err, database.ErrAlreadyMember) {
+		http.Error(w, user.Username+" is already a member", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		l.Logger.Error("Failed to add member", "error", err)
+		http.Error(w, "Failed to add member", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetLedgers(w, l, CurrentUser(r), current)
+	if err != nil {
+		l.Logger.Error(ledgerError, "error", err)
+	}
+}
+
+func (l *LedgerHandler) HandleSetMemberRole(w http.ResponseWriter, r *http.Request) {
+	current := CurrentMember(r)
+	if !current.IsOwner() {
+		http.Error(w, "Only owners can manage members", http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	role, ok := roleFromForm(r)
+	if !ok {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	err = l.SetMemberRole(current.LedgerID, uint(userID), role)
+	if !l.memberUpdated(w, err) {
+		return
+	}
+
+	// owners demoting themselves lose the member controls
+	user := CurrentUser(r)
+	if uint(userID) == user.ID {
+		current.Role = role
+	}
+	err = executeGetLedgers(w, l, user, current)
+	if err != nil {
+		l.Logger.Error(ledgerError, "error", err)
+	}
+}
+
+// removes a member from the current ledger. Owners can remove anyone, everyone
+// else can only leave.
+func (l *LedgerHandler) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	current := CurrentMember(r)
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user := CurrentUser(r)
+	leaving := uint(userID) == user.ID
+	if !leaving && !current.IsOwner() {
+		http.Error(w, "Only owners can manage members", http.StatusForbidden)
+		return
+	}
+
+	err = l.RemoveMember(current.LedgerID, uint(userID))
+	if !l.memberUpdated(w, err) {
+		return
+	}
+
+	if leaving {
+		// the ledger cookie is no longer valid, so RequireLedger falls back
+		w.Header().Set("HX-Refresh", "true")
+		return
+	}
+	err = executeGetLedgers(w, l, user, current)
+	if err != nil {
+		l.Logger.Error(ledgerError, "error", err)
+	}
+}
+
+// memberUpdated writes the response for a failed change to a member and
+// reports whether it succeeded
+func (l *LedgerHandler) memberUpdated(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrNotFound):
+		http.Error(w, "Member not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrLastOwner):
+		http.Error(w, "A ledger must keep at least one owner", http.StatusConflict)
+	default:
+		l.Logger.Error("Failed to update member", "error", err)
+		http.Error(w, "Failed to update member", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// roleFromForm reads the role, defaulting to viewer
+func roleFromForm(r *http.Request) (string, bool) {
+	role := r.FormValue("role")
+	if role == "" {
+		return models.RoleViewer, true
+	}
+	return role, slices.Contains(ledgerRoles, role)
+}
+
+func executeGetLedgers(w http.ResponseWriter, l *LedgerHandler, user models.User, current models.LedgerMember) error {
+	ledgers, err := l.GetLedgers(user.ID)
+	if err != nil {
+		http.Error(w, ledgerError, http.StatusInternalServerError)
+		return err
+	}
+	members, err := l.GetMembers(current.LedgerID)
+	if err != nil {
+		http.Error(w, ledgerError, http.StatusInternalServerError)
+		return err
+	}
+
+	tmpl, err := template.ParseFS(l.webFS, "web/components/ledgers.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, ledgersView{
+		Current: current,
+		Ledgers: ledgers,
+		Members: members,
+		Roles:   ledgerRoles,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var loanError = "Failed to get loans"
+
+var loanFrequencies = []string{
+	models.FrequencyMonthly,
+	models.FrequencyWeekly,
+	models.FrequencyYearly,
+}
+
+type LoanHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewLoanHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *LoanHandler {
+	return &LoanHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (l *LoanHandler) Routes(r chi.Router) {
+	// api/v1/loan
+	r.Use(RequireUser(l.Database), RequireLedger(l.Database), RequireEditor)
+	r.Get("/", l.HandleGetLoans)
+	r.Post("/", l.HandleAddLoan)
+	r.Delete("/{id}", l.HandleDeleteLoan)
+	r.Get("/{id}/schedule", l.HandleGetLoanSchedule)
+	r.Post("/{id}/payment", l.HandlePayLoan)
+}
+
+type loansView struct {
+	Loans       []models.LoanProgress
+	Frequencies []string
+}
+
+// renders every loan with what is still owed, the next payment and the payoff date
+func (l *LoanHandler) HandleGetLoans(w http.ResponseWriter, r *http.Request) {
+	err := executeGetLoans(w, r, l)
+	if err != nil {
+		l.Logger.Error(loanError, "error", err)
+	}
+}
+
+func (l *LoanHandler) HandleAddLoan(w http.ResponseWriter, r *http.Request) {
+	account, err := accountFromForm(r, l.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+	category, err := categoryFromForm(r, l.Database, models.CategoryKindExpense)
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+
+	// the principal defaults to the paying account's currency
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = account.Currency()
+	}
+	principal, err := money.Parse(r.FormValue("principal"), currency)
+	if err != nil {
+		http.Error(w, "Invalid principal", http.StatusBadRequest)
+		return
+	}
+	rate, err := strconv.ParseFloat(r.FormValue("annual_rate"), 64)
+	if err != nil {
+		http.Error(w, "Invalid rate", http.StatusBadRequest)
+		return
+	}
+	term, err := strconv.Atoi(r.FormValue("term"))
+	if err != nil {
+		http.Error(w, "Invalid term", http.StatusBadRequest)
+		return
+	}
+	interval := 1
+	if value := r.FormValue("interval"); value != "" {
+		interval, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+	first, err := ParseDay(r.FormValue("first_payment_on"))
+	if err != nil {
+		http.Error(w, "Invalid first payment date", http.StatusBadRequest)
+		return
+	}
+
+	err = l.AddLoan(models.Loan{
+		LedgerID:       CurrentMember(r).LedgerID,
+		Name:           strings.TrimSpace(r.FormValue("name")),
+		Principal:      principal,
+		AnnualRate:     rate,
+		Term:           term,
+		Frequency:      r.FormValue("frequency"),
+		Interval:       interval,
+		FirstPaymentOn: first,
+		AccountID:      account.ID,
+		CategoryID:     category.ID,
+	})
+	if errors.Is(err, models.ErrInvalidLoan) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		l.Logger.Error("Failed to add loan", "error", err)
+		http.Error(w, "Failed to add loan", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetLoans(w, r, l)
+	if err != nil {
+		l.Logger.Error(loanError, "error", err)
+	}
+}
+
+func (l *LoanHandler) HandleDeleteLoan(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid loan ID", http.StatusBadRequest)
+		return
+	}
+
+	err = l.DeleteLoan(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, l.Logger, err, "Loan not found") {
+		return
+	}
+	if err != nil {
+		l.Logger.Error("Failed to delete loan", "error", err)
+		http.Error(w, "Failed to delete loan", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetLoans(w, r, l)
+	if err != nil {
+		l.Logger.Error(loanError, "error", err)
+	}
+}
+
+// renders the amortization schedule, each payment split into interest and principal
+func (l *LoanHandler) HandleGetLoanSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid loan ID", http.StatusBadRequest)
+		return
+	}
+	loan, err := l.GetLoan(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, l.Logger, err, "Loan not found") {
+		return
+	}
+	if err != nil {
+		l.Logger.Error(loanError, "error", err)
+		http.Error(w, loanError, http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFS(l.webFS, "web/components/loan_schedule.html")
+	if err != nil {
+		l.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, loan.Progress(Today()))
+	if err != nil {
+		l.Logger.Error(executeTemplateError, "error", err)
+	}
+}
+
+// pays the next instalment, posting it as an expense on occurred_on or today,
+// and has the expense list reload to show it
+func (l *LoanHandler) HandlePayLoan(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid loan ID", http.StatusBadRequest)
+		return
+	}
+	day, err := occurredOn(r)
+	if err != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+
+	err = l.As(auditActor(r)).PayLoan(CurrentMember(r).LedgerID, id, CurrentUser(r).ID, day)
+	if errors.Is(err, database.ErrLoanPaidOff) {
+		http.Error(w, "Loan is already paid off", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, rates.ErrNoRate) {
+		http.Error(w, "No exchange rate from the account's currency to the loan's", http.StatusBadRequest)
+		return
+	}
+	if notFound(w, r, l.Logger, err, "Loan not found") {
+		return
+	}
+	if err != nil {
+		l.Logger.Error("Failed to pay loan", "error", err)
+		http.Error(w, "Failed to pay loan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "loanPaid")
+	err = executeGetLoans(w, r, l)
+	if err != nil {
+		l.Logger.Error(loanError, "error", err)
+	}
+}
+
+func executeGetLoans(w http.ResponseWriter, r *http.Request, l *LoanHandler) error {
+	loans, err := l.GetLoans(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, loanError, http.StatusInternalServerError)
+		return err
+	}
+
+	today := Today()
+	progress := make([]models.LoanProgress, 0, len(loans))
+	for _, loan := range loans {
+		progress = append(progress, loan.Progress(today))
+	}
+
+	tmpl, err := template.ParseFS(l.webFS, "web/components/loans.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, loansView{
+		Loans:       progress,
+		Frequencies: loanFrequencies,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/graphs"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/rates"
+)
+
+var netWorthError = "Failed to get net worth"
+
+var assetKinds = []string{
+	models.AssetKindAsset,
+	models.AssetKindLiability,
+}
+
+type NetWorthHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS    embed.FS
+	currency string
+}
+
+func NewNetWorthHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *NetWorthHandler {
+	return &NetWorthHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+		currency: currency,
+	}
+}
+
+func (n *NetWorthHandler) Routes(r chi.Router) {
+	// api/v1/networth
+	r.Use(RequireUser(n.Database), RequireLedger(n.Database), RequireEditor)
+	r.Get("/", n.HandleGetNetWorth)
+	r.Get("/graph", n.HandleGetNetWorthGraph)
+	r.Post("/asset", n.HandleAddAsset)
+	r.Post("/asset/{id}", n.HandleRevalueAsset)
+	r.Delete("/asset/{id}", n.HandleDeleteAsset)
+}
+
+type netWorthView struct {
+	NetWorth models.NetWorth
+	Assets   []models.Asset
+	Kinds    []string
+}
+
+// renders today's net worth in the user's reporting currency, broken down
+// into accounts, investments, assets and liabilities, with the manual assets
+// and liabilities that go into it
+func (n *NetWorthHandler) HandleGetNetWorth(w http.ResponseWriter, r *http.Request) {
+	err := executeGetNetWorth(w, r, n)
+	if err != nil {
+		n.Logger.Error(netWorthError, "error", err)
+	}
+}
+
+func (n *NetWorthHandler) HandleAddAsset(w http.ResponseWriter, r *http.Request) {
+	currency := strings.ToUpper(r.FormValue("currency"))
+	if currency == "" {
+		currency = ReportingCurrency(r, n.currency)
+	}
+	value, err := money.Parse(r.FormValue("value"), currency)
+	if err != nil {
+		http.Error(w, "Invalid value", http.StatusBadRequest)
+		return
+	}
+
+	err = n.AddAsset(models.Asset{
+		LedgerID: CurrentMember(r).LedgerID,
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		Kind:     r.FormValue("kind"),
+		Value:    value,
+		ValuedOn: Today(),
+	})
+	if errors.Is(err, models.ErrInvalidAsset) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		n.Logger.Error("Failed to add asset", "error", err)
+		http.Error(w, "Failed to add asset", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetNetWorth(w, r, n)
+	if err != nil {
+		n.Logger.Error(netWorthError, "error", err)
+	}
+}
+
+// sets what an asset or liability is worth today, in its own currency
+func (n *NetWorthHandler) HandleRevalueAsset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid asset ID", http.StatusBadRequest)
+		return
+	}
+	ledgerID := CurrentMember(r).LedgerID
+	asset, err := n.GetAsset(ledgerID, id)
+	if notFound(w, r, n.Logger, err, "Asset not found") {
+		return
+	}
+	if err != nil {
+		n.Logger.Error("Failed to get asset", "error", err)
+		http.Error(w, "Failed to get asset", http.StatusInternalServerError)
+		return
+	}
+	value, err := money.Parse(r.FormValue("value"), asset.Value.Currency)
+	if err != nil {
+		http.Error(w, "Invalid value", http.StatusBadRequest)
+		return
+	}
+
+	err = n.RevalueAsset(ledgerID, id, value, Today())
+	if errors.Is(err, models.ErrInvalidAsset) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		n.Logger.Error("Failed to revalue asset", "error", err)
+		http.Error(w, "Failed to revalue asset", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetNetWorth(w, r, n)
+	if err != nil {
+		n.Logger.Error(netWorthError, "error", err)
+	}
+}
+
+func (n *NetWorthHandler) HandleDeleteAsset(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid asset ID", http.StatusBadRequest)
+		return
+	}
+
+	err = n.DeleteAsset(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, n.Logger, err, "Asset not found") {
+		return
+	}
+	if err != nil {
+		n.Logger.Error("Failed to delete asset", "error", err)
+		http.Error(w, "Failed to delete asset", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetNetWorth(w, r, n)
+	if err != nil {
+		n.Logger.Error(netWorthError, "error", err)
+	}
+}
+
+// charts the daily snapshots of net worth, assets and liabilities, with
+// today's figure worked out live so the line always reaches today
+func (n *NetWorthHandler) HandleGetNetWorthGraph(w http.ResponseWriter, r *http.Request) {
+	ledgerID := CurrentMember(r).LedgerID
+	snapshots, err := n.GetNetWorthSnapshots(ledgerID)
+	if err != nil {
+		n.Logger.Error(netWorthError, "error", err)
+		http.Error(w, netWorthError, http.StatusInternalServerError)
+		return
+	}
+
+	today := Today()
+	currency := ReportingCurrency(r, n.currency)
+	current, err := n.GetNetWorth(ledgerID, currency, today)
+	if err != nil {
+		n.Logger.Error(netWorthError, "error", err)
+		http.Error(w, netWorthError, http.StatusInternalServerError)
+		return
+	}
+
+	conv := rates.NewConverter(n.Database)
+	var total, owned, owed []opts.LineData
+	point := func(t time.Time, m money.Money) opts.LineData {
+		return opts.LineData{Value: []interface{}{t.Format(time.DateOnly), m.Float64()}}
+	}
+	add := func(worth models.NetWorth) {
+		t, err := conv.Convert(worth.Total, currency, worth.Date)
+		if err != nil {
+			n.Logger.Warn("Skipping snapshot in graph", "date", worth.Date, "error", err)
+			return
+		}
+		// everything owned is what's left once liabilities are added back
+		l, err := conv.Convert(worth.Liabilities, currency, worth.Date)
+		if err != nil {
+			n.Logger.Warn("Skipping snapshot in graph", "date", worth.Date, "error", err)
+			return
+		}
+		o, _ := t.Add(l)
+		total = append(total, point(worth.Date, t))
+		owned = append(owned, point(worth.Date, o))
+		owed = append(owed, point(worth.Date, l))
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Date.Before(today) {
+			add(snapshot.NetWorth)
+		}
+	}
+	add(current)
+
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title:    "Net Worth",
+			Subtitle: "Daily, in " + currency,
+		}),
+		charts.WithXAxisOpts(opts.XAxis{Type: "time"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+	)
+	line.AddSeries("Net worth", total)
+	line.AddSeries("Assets", owned)
+	line.AddSeries("Liabilities", owed)
+
+	snippet, err := graphs.Render(line)
+	if err != nil {
+		n.Logger.Error("Failed to render graph", "error", err)
+		http.Error(w, "Failed to render graph", http.StatusInternalServerError)
+		return
+	}
+
+	// the snippet is trusted script, so it goes through text/template like graph.html
+	tmpl, err := textTemplate.ParseFS(n.webFS, "web/components/networth_graph.html")
+	if err != nil {
+		n.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, snippet)
+	if err != nil {
+		n.Logger.Error(executeTemplateError, "error", err)
+	}
+}
+
+func executeGetNetWorth(w http.ResponseWriter, r *http.Request, n *NetWorthHandler) error {
+	ledgerID := CurrentMember(r).LedgerID
+	worth, err := n.GetNetWorth(ledgerID, ReportingCurrency(r, n.currency), Today())
+	if err != nil {
+		http.Error(w, netWorthError, http.StatusInternalServerError)
+		return err
+	}
+	assets, err := n.GetAssets(ledgerID)
+	if err != nil {
+		http.Error(w, netWorthError, http.StatusInternalServerError)
+		return err
+	}
+
+	tmpl, err := template.ParseFS(n.webFS, "web/components/networth.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, netWorthView{
+		NetWorth: worth,
+		Assets:   assets,
+		Kinds:    assetKinds,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
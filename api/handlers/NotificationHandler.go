@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+)
+
+var notificationError = "Failed to get notifications"
+
+type NotificationHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewNotificationHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *NotificationHandler {
+	return &NotificationHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (n *NotificationHandler) Routes(r chi.Router) {
+	// api/v1/notification
+	r.Use(RequireUser(n.Database), RequireLedger(n.Database), RequireEditor)
+	r.Get("/", n.HandleGetNotifications)
+	r.Post("/{id}/read", n.HandleReadNotification)
+}
+
+// renders the ledger's notifications that haven't been dismissed yet
+func (n *NotificationHandler) HandleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	err := executeGetNotifications(w, r, n)
+	if err != nil {
+		n.Logger.Error(notificationError, "error", err)
+	}
+}
+
+// dismisses a notification for everyone in the ledger
+func (n *NotificationHandler) HandleReadNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	err = n.ReadNotification(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, n.Logger, err, "Notification not found") {
+		return
+	}
+	if err != nil {
+		n.Logger.Error("Failed to dismiss notification", "error", err)
+		http.Error(w, "Failed to dismiss notification", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetNotifications(w, r, n)
+	if err != nil {
+		n.Logger.Error(notificationError, "error", err)
+	}
+}
+
+func executeGetNotifications(w http.ResponseWriter, r *http.Request, n *NotificationHandler) error {
+	notifications, err := n.GetNotifications(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, notificationError, http.StatusInternalServerError)
+		return err
+	}
+
+	tmpl, err := template.ParseFS(n.webFS, "web/components/notifications.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, notifications)
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var reconcileError = "Failed to get reconciliations"
+
+type ReconcileHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewReconcileHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *ReconcileHandler {
+	return &ReconcileHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (rh *ReconcileHandler) Routes(r chi.Router) {
+	// api/v1/reconcile
+	r.Use(RequireUser(rh.Database), RequireLedger(rh.Database), RequireEditor)
+	r.Get("/", rh.HandleGetReconciliations)
+	r.Post("/", rh.HandleStartReconciliation)
+	r.Get("/{id}", rh.HandleGetReconciliation)
+	r.Delete("/{id}", rh.HandleCancelReconciliation)
+	r.Post("/{id}/finish", rh.HandleFinishReconciliation)
+	r.Post("/{id}/{kind}/{line}", rh.HandleSetCleared)
+}
+
+// the reconcile panel shows either the ledger's reconciliations and the form
+// to start one, or the one being worked on when Progress is set
+type reconcileView struct {
+	Reconciliations []models.Reconciliation
+	Progress        *models.ReconciliationProgress
+}
+
+func (rh *ReconcileHandler) HandleGetReconciliations(w http.ResponseWriter, r *http.Request) {
+	err := executeGetReconciliations(w, r, rh)
+	if err != nil {
+		rh.Logger.Error(reconcileError, "error", err)
+	}
+}
+
+// starts reconciling account_id against a statement ending on statement_date
+// with closing_balance, in the account's currency
+func (rh *ReconcileHandler) HandleStartReconciliation(w http.ResponseWriter, r *http.Request) {
+	account, err := accountFromForm(r, rh.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+	statementDate, err := ParseDay(r.FormValue("statement_date"))
+	if err != nil {
+		http.Error(w, "Invalid statement date", http.StatusBadRequest)
+		return
+	}
+	closing, err := money.Parse(r.FormValue("closing_balance"), account.Currency())
+	if err != nil {
+		http.Error(w, "Invalid closing balance", http.StatusBadRequest)
+		return
+	}
+
+	reconciliation, err := rh.StartReconciliation(models.Reconciliation{
+		LedgerID:       CurrentMember(r).LedgerID,
+		AccountID:      account.ID,
+		StatementDate:  statementDate,
+		ClosingBalance: closing,
+	})
+	if errors.Is(err, database.ErrReconciliationOpen) {
+		http.Error(w, "Finish or cancel the open reconciliation of "+account.Name+" first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to start reconciliation", "error", err)
+		http.Error(w, "Failed to start reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetReconciliation(w, r, rh, int(reconciliation.ID))
+	if err != nil {
+		rh.Logger.Error(reconcileError, "error", err)
+	}
+}
+
+func (rh *ReconcileHandler) HandleGetReconciliation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid reconciliation ID", http.StatusBadRequest)
+		return
+	}
+
+	err = executeGetReconciliation(w, r, rh, id)
+	if err != nil {
+		rh.Logger.Error(reconcileError, "error", err)
+	}
+}
+
+// ticks a line off as cleared, or back to pending when cleared=false is sent
+func (rh *ReconcileHandler) HandleSetCleared(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid reconciliation ID", http.StatusBadRequest)
+		return
+	}
+	kind := chi.URLParam(r, "kind")
+	if kind != models.JournalExpense && kind != models.JournalIncome && kind != models.JournalTransfer && kind != models.JournalTrade {
+		http.Error(w, "Invalid kind", http.StatusBadRequest)
+		return
+	}
+	line, err := strconv.Atoi(chi.URLParam(r, "line"))
+	if err != nil {
+		http.Error(w, "Invalid line ID", http.StatusBadRequest)
+		return
+	}
+	cleared := r.FormValue("cleared") != "false"
+
+	err = rh.As(auditActor(r)).SetCleared(CurrentMember(r).LedgerID, id, kind, line, cleared)
+	if errors.Is(err, database.ErrReconciliationFinished) {
+		http.Error(w, "Reconciliation is finished", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrReconciled) {
+		http.Error(w, "Line is already reconciled", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, rh.Logger, err, "Reconciliation or line not found") {
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to clear line", "error", err)
+		http.Error(w, "Failed to clear line", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetReconciliation(w, r, rh, id)
+	if err != nil {
+		rh.Logger.Error(reconcileError, "error", err)
+	}
+}
+
+// reconciles the cleared lines once they match the statement, locking them,
+// and has the income and expense lists reload to show it
+func (rh *ReconcileHandler) HandleFinishReconciliation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid reconciliation ID", http.StatusBadRequest)
+		return
+	}
+
+	err = rh.As(auditActor(r)).FinishReconciliation(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrReconciliationOff) {
+		http.Error(w, "The cleared balance doesn't match the statement yet", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, database.ErrReconciliationFinished) {
+		http.Error(w, "Reconciliation is already finished", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, rh.Logger, err, "Reconciliation not found") {
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to finish reconciliation", "error", err)
+		http.Error(w, "Failed to finish reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "transactionsReconciled")
+	err = executeGetReconciliations(w, r, rh)
+	if err != nil {
+		rh.Logger.Error(reconcileError, "error", err)
+	}
+}
+
+func (rh *ReconcileHandler) HandleCancelReconciliation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid reconciliation ID", http.StatusBadRequest)
+		return
+	}
+
+	err = rh.CancelReconciliation(CurrentMember(r).LedgerID, id)
+	if errors.Is(err, database.ErrReconciliationFinished) {
+		http.Error(w, "Reconciliation is finished, un-reconcile its transactions instead", http.StatusConflict)
+		return
+	}
+	if notFound(w, r, rh.Logger, err, "Reconciliation not found") {
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to cancel reconciliation", "error", err)
+		http.Error(w, "Failed to cancel reconciliation", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetReconciliations(w, r, rh)
+	if err != nil {
+		rh.Logger.Error(reconcileError, "error", err)
+	}
+}
+
+func executeGetReconciliations(w http.ResponseWriter, r *http.Request, rh *ReconcileHandler) error {
+	reconciliations, err := rh.GetReconciliations(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, reconcileError, http.StatusInternalServerError)
+		return err
+	}
+	return executeReconcile(w, rh, reconcileView{Reconciliations: reconciliations})
+}
+
+func executeGetReconciliation(w http.ResponseWriter, r *http.Request, rh *ReconcileHandler, id int) error {
+	progress, err := rh.GetReconciliation(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, rh.Logger, err, "Reconciliation not found") {
+		return nil
+	}
+	if err != nil {
+		http.Error(w, reconcileError, http.StatusInternalServerError)
+		return err
+	}
+	return executeReconcile(w, rh, reconcileView{Progress: &progress})
+}
+
+func executeReconcile(w http.ResponseWriter, rh *ReconcileHandler, view reconcileView) error {
+	tmpl, err := template.ParseFS(rh.webFS, "web/components/reconcile.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, view)
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
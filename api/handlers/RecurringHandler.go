@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+	"github.com/Ewan-Greer09/finance-app/api/recurring"
+)
+
+var recurringError = "Failed to get recurring transactions"
+
+var frequencies = []string{
+	models.FrequencyDaily,
+	models.FrequencyWeekly,
+	models.FrequencyMonthly,
+	models.FrequencyYearly,
+	models.FrequencyCustom,
+}
+
+type RecurringHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS    embed.FS
+	currency string
+}
+
+func NewRecurringHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *RecurringHandler {
+	return &RecurringHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+		currency: currency,
+	}
+}
+
+func (rh *RecurringHandler) Routes(r chi.Router) {
+	// api/v1/recurring
+	r.Use(RequireUser(rh.Database), RequireLedger(rh.Database), RequireEditor)
+	r.Get("/", rh.HandleGetRecurring)
+	r.Post("/", rh.HandleAddRecurring)
+	r.Delete("/{id}", rh.HandleDeleteRecurring)
+}
+
+type recurringView struct {
+	Recurring   []models.Recurring
+	Frequencies []string
+}
+
+func (rh *RecurringHandler) HandleGetRecurring(w http.ResponseWriter, r *http.Request) {
+	err := executeGetRecurring(w, r, rh)
+	if err != nil {
+		rh.Logger.Error(recurringError, "error", err)
+	}
+}
+
+// adds a template, anything already due from a start date in the past is
+// posted straight away rather than waiting for the scheduler
+func (rh *RecurringHandler) HandleAddRecurring(w http.ResponseWriter, r *http.Request) {
+	kind := r.FormValue("kind")
+	if !validCategoryKind(kind) {
+		http.Error(w, "Invalid kind", http.StatusBadRequest)
+		return
+	}
+
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = rh.currency
+	}
+	amount, err := money.Parse(r.FormValue("amount"), currency)
+	if err != nil || amount.IsNegative() || amount.IsZero() {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	source := strings.TrimSpace(r.FormValue("source"))
+	if source == "" {
+		http.Error(w, "Source is required", http.StatusBadRequest)
+		return
+	}
+
+	category, err := categoryFromForm(r, rh.Database, kind)
+	if err != nil {
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	account, err := accountFromForm(r, rh.Database)
+	if err != nil {
+		http.Error(w, "Invalid account", http.StatusBadRequest)
+		return
+	}
+
+	rec := models.Recurring{
+		LedgerID:   CurrentMember(r).LedgerID,
+		UserID:     CurrentUser(r).ID,
+		Kind:       kind,
+		Amount:     amount,
+		Source:     source,
+		CategoryID: category.ID,
+		AccountID:  account.ID,
+		Frequency:  r.FormValue("frequency"),
+		RRule:      strings.TrimSpace(r.FormValue("rrule")),
+	}
+	if value := r.FormValue("interval"); value != "" {
+		rec.Interval, err = strconv.Atoi(value)
+		if err != nil || rec.Interval < 1 {
+			http.Error(w, "Invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rec.StartOn = Today()
+	if value := r.FormValue("start_on"); value != "" {
+		rec.StartOn, err = ParseDay(value)
+		if err != nil {
+			http.Error(w, "Invalid start date", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.FormValue("end_on"); value != "" {
+		end, err := ParseDay(value)
+		if err != nil || end.Before(rec.StartOn) {
+			http.Error(w, "Invalid end date", http.StatusBadRequest)
+			return
+		}
+		rec.EndOn = &end
+	}
+
+	schedule, err := recurring.ScheduleFor(rec)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	first, ok := schedule.First(rec.StartOn)
+	if !ok {
+		http.Error(w, "Schedule has no occurrences", http.StatusBadRequest)
+		return
+	}
+	rec.NextOn = &first
+
+	err = rh.AddRecurring(rec)
+	if err != nil {
+		rh.Logger.Error("Failed to add recurring transaction", "error", err)
+		http.Error(w, "Failed to add recurring transaction", http.StatusInternalServerError)
+		return
+	}
+
+	if !first.After(Today()) {
+		_, err = recurring.PostDue(r.Context(), rh.Database, Today())
+		if err != nil {
+			rh.Logger.Error("Failed to post recurring transactions", "error", err)
+		}
+	}
+
+	err = executeGetRecurring(w, r, rh)
+	if err != nil {
+		rh.Logger.Error(recurringError, "error", err)
+	}
+}
+
+func (rh *RecurringHandler) HandleDeleteRecurring(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid recurring ID", http.StatusBadRequest)
+		return
+	}
+
+	err = rh.DeleteRecurring(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, rh.Logger, err, "Recurring transaction not found") {
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to delete recurring transaction", "error", err)
+		http.Error(w, "Failed to delete recurring transaction", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetRecurring(w, r, rh)
+	if err != nil {
+		rh.Logger.Error(recurringError, "error", err)
+	}
+}
+
+func executeGetRecurring(w http.ResponseWriter, r *http.Request, rh *RecurringHandler) error {
+	list, err := rh.GetRecurring(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, recurringError, http.StatusInternalServerError)
+		return err
+	}
+
+	tmpl, err := template.ParseFS(rh.webFS, "web/components/recurring.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, recurringView{
+		Recurring:   list,
+		Frequencies: frequencies,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
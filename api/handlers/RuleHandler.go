@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var ruleError = "Failed to get rules"
+
+var ruleMatches = []string{
+	models.RuleMatchContains,
+	models.RuleMatchRegex,
+}
+
+type RuleHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS    embed.FS
+	currency string
+}
+
+func NewRuleHandler(logger *slog.Logger, db database.Database, webFS embed.FS, currency string) *RuleHandler {
+	return &RuleHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+		currency: currency,
+	}
+}
+
+func (rh *RuleHandler) Routes(r chi.Router) {
+	// api/v1/rule
+	r.Use(RequireUser(rh.Database), RequireLedger(rh.Database), RequireEditor)
+	r.Get("/", rh.HandleGetRules)
+	r.Post("/", rh.HandleAddRule)
+	r.Post("/apply", rh.HandleReapplyRules)
+	r.Put("/{id}", rh.HandleUpdateRule)
+	r.Delete("/{id}", rh.HandleDeleteRule)
+}
+
+type rulesView struct {
+	Rules   []models.Rule
+	Matches []string
+	// what the last action did, e.g. how many transactions a re-apply changed
+	Notice string
+}
+
+func (rh *RuleHandler) HandleGetRules(w http.ResponseWriter, r *http.Request) {
+	err := executeGetRules(w, r, rh, "")
+	if err != nil {
+		rh.Logger.Error(ruleError, "error", err)
+	}
+}
+
+func (rh *RuleHandler) HandleAddRule(w http.ResponseWriter, r *http.Request) {
+	rule, err := rh.ruleFromForm(r)
+	if err != nil {
+		http.Error(w, "Invalid rule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = rh.AddRule(rule)
+	if errors.Is(err, models.ErrInvalidRule) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to add rule", "error", err)
+		http.Error(w, "Failed to add rule", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetRules(w, r, rh, "")
+	if err != nil {
+		rh.Logger.Error(ruleError, "error", err)
+	}
+}
+
+// replaces every condition and action of a rule
+func (rh *RuleHandler) HandleUpdateRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := rh.ruleFromForm(r)
+	if err != nil {
+		http.Error(w, "Invalid rule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rule.ID = uint(id)
+
+	err = rh.UpdateRule(rule)
+	if errors.Is(err, models.ErrInvalidRule) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if notFound(w, r, rh.Logger, err, "Rule not found") {
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to update rule", "error", err)
+		http.Error(w, "Failed to update rule", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetRules(w, r, rh, "")
+	if err != nil {
+		rh.Logger.Error(ruleError, "error", err)
+	}
+}
+
+func (rh *RuleHandler) HandleDeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	err = rh.DeleteRule(CurrentMember(r).LedgerID, id)
+	if notFound(w, r, rh.Logger, err, "Rule not found") {
+		return
+	}
+	if err != nil {
+		rh.Logger.Error("Failed to delete rule", "error", err)
+		http.Error(w, "Failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetRules(w, r, rh, "")
+	if err != nil {
+		rh.Logger.Error(ruleError, "error", err)
+	}
+}
+
+// runs the rules over every transaction already in the ledger. It rewrites
+// history, so only owners can do it, and has the income and expense lists reload.
+func (rh *RuleHandler) HandleReapplyRules(w http.ResponseWriter, r *http.Request) {
+	member := CurrentMember(r)
+	if !member.IsOwner() {
+		http.Error(w, "Only owners can re-apply rules", http.StatusForbidden)
+		return
+	}
+
+	changed, err := rh.As(auditActor(r)).ReapplyRules(member.LedgerID)
+	if err != nil {
+		rh.Logger.Error("Failed to re-apply rules", "error", err)
+		http.Error(w, "Failed to re-apply rules", http.StatusInternalServerError)
+		return
+	}
+	rh.Logger.Info("Re-applied rules", "ledger", member.LedgerID, "changed", changed)
+
+	w.Header().Set("HX-Trigger", "rulesApplied")
+	err = executeGetRules(w, r, rh, fmt.Sprintf("Rules changed %d transactions", changed))
+	if err != nil {
+		rh.Logger.Error(ruleError, "error", err)
+	}
+}
+
+// ruleFromForm reads a rule for the current ledger. Conditions and actions
+// left blank aren't set, Rule.Validate checks enough of them are.
+func (rh *RuleHandler) ruleFromForm(r *http.Request) (models.Rule, error) {
+	rule := models.Rule{
+		LedgerID: CurrentMember(r).LedgerID,
+		Kind:     r.FormValue("kind"),
+		Match:    r.FormValue("match"),
+		Pattern:  strings.TrimSpace(r.FormValue("pattern")),
+		RenameTo: strings.TrimSpace(r.FormValue("rename_to")),
+	}
+	if !validCategoryKind(rule.Kind) {
+		return models.Rule{}, errors.New("invalid kind")
+	}
+	if rule.Pattern == "" {
+		rule.Match = ""
+	}
+
+	var err error
+	if value := r.FormValue("priority"); value != "" {
+		rule.Priority, err = strconv.Atoi(value)
+		if err != nil {
+			return models.Rule{}, errors.New("invalid priority")
+		}
+	}
+
+	currency := r.FormValue("currency")
+	if currency == "" {
+		currency = rh.currency
+	}
+	if value := r.FormValue("min_amount"); value != "" {
+		rule.MinAmount, err = money.Parse(value, currency)
+		if err != nil {
+			return models.Rule{}, errors.New("invalid minimum amount")
+		}
+	}
+	if value := r.FormValue("max_amount"); value != "" {
+		rule.MaxAmount, err = money.Parse(value, currency)
+		if err != nil {
+			return models.Rule{}, errors.New("invalid maximum amount")
+		}
+	}
+
+	if r.FormValue("account_id") != "" {
+		account, err := accountFromForm(r, rh.Database)
+		if err != nil {
+			return models.Rule{}, errors.New("invalid account")
+		}
+		rule.AccountID = account.ID
+	}
+	if r.FormValue("category_id") != "" {
+		category, err := categoryFromForm(r, rh.Database, rule.Kind)
+		if err != nil {
+			return models.Rule{}, errors.New("invalid category")
+		}
+		// filing under Uncategorised would do nothing, so it means leave the category be
+		if !isUncategorised(category) {
+			rule.CategoryID = category.ID
+		}
+	}
+	if value := r.FormValue("tag"); value != "" {
+		rule.Tag, err = normalizeTag(value)
+		if err != nil {
+			return models.Rule{}, err
+		}
+	}
+	return rule, nil
+}
+
+func executeGetRules(w http.ResponseWriter, r *http.Request, rh *RuleHandler, notice string) error {
+	rules, err := rh.GetRules(CurrentMember(r).LedgerID)
+	if err != nil {
+		http.Error(w, ruleError, http.StatusInternalServerError)
+		return err
+	}
+
+	tmpl, err := template.ParseFS(rh.webFS, "web/components/rules.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, rulesView{
+		Rules:   rules,
+		Matches: ruleMatches,
+		Notice:  notice,
+	})
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
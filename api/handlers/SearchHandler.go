@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"embed"
+	"errors"
+	"html"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+var searchError = "Failed to search"
+
+// searchLimit is how many results are shown when the request doesn't say
+const searchLimit = 20
+
+type SearchHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS embed.FS
+}
+
+func NewSearchHandler(logger *slog.Logger, db database.Database, webFS embed.FS) *SearchHandler {
+	return &SearchHandler{
+		Logger:   logger,
+		Database: db,
+		webFS:    webFS,
+	}
+}
+
+func (s *SearchHandler) Routes(r chi.Router) {
+	// api/v1/search
+	r.Use(RequireUser(s.Database), RequireLedger(s.Database))
+	r.Get("/", s.HandleSearch)
+}
+
+// searchView is a search result with its snippet escaped and the matched
+// words marked
+type searchView struct {
+	models.SearchResult
+	Highlighted template.HTML
+}
+
+// searches the current ledger's incomes and expenses for ?q=, returning at
+// most ?limit= results, best match first
+func (s *SearchHandler) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	limit := searchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > database.MaxPageSize {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := s.Search(CurrentMember(r).LedgerID, r.URL.Query().Get("q"), limit)
+	if errors.Is(err, database.ErrSearchUnavailable) {
+		http.Error(w, "Search is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		s.Logger.Error(searchError, "error", err)
+		http.Error(w, searchError, http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]searchView, len(results))
+	for i, result := range results {
+		views[i] = searchView{SearchResult: result, Highlighted: highlight(result.Snippet)}
+	}
+
+	tmpl, err := template.ParseFS(s.webFS, "web/components/search.html")
+	if err != nil {
+		s.Logger.Error(parseTemplateError, "error", err)
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.Execute(w, struct {
+		Query   string
+		Results []searchView
+	}{strings.TrimSpace(r.URL.Query().Get("q")), views})
+	if err != nil {
+		s.Logger.Error(executeTemplateError, "error", err)
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+	}
+}
+
+// highlight escapes a snippet and wraps its matched words in <mark>
+func highlight(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, database.SearchMatchStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, database.SearchMatchEnd, "</mark>")
+	return template.HTML(escaped)
+}
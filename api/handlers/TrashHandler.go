@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var trashError = "Failed to get trash"
+
+type TrashHandler struct {
+	Logger *slog.Logger
+	database.Database
+	webFS     embed.FS
+	retention time.Duration
+}
+
+func NewTrashHandler(logger *slog.Logger, db database.Database, webFS embed.FS, retention time.Duration) *TrashHandler {
+	return &TrashHandler{
+		Logger:    logger,
+		Database:  db,
+		webFS:     webFS,
+		retention: retention,
+	}
+}
+
+func (t *TrashHandler) Routes(r chi.Router) {
+	// api/v1/trash
+	r.Use(RequireUser(t.Database), RequireLedger(t.Database), RequireEditor)
+	r.Get("/", t.HandleGetTrash)
+	r.Post("/{kind}/{id}/restore", t.HandleRestore)
+	r.Delete("/{kind}/{id}", t.HandlePurge)
+}
+
+// trashItem is a deleted income or expense and the day it will be purged
+type trashItem struct {
+	Kind       string // CategoryKindExpense or CategoryKindIncome
+	ID         uint
+	Source     string
+	Amount     money.Money
+	OccurredOn time.Time
+	Category   string
+	DeletedAt  time.Time
+	PurgeOn    time.Time
+}
+
+// lists the current ledger's deleted incomes and expenses, newest first
+func (t *TrashHandler) HandleGetTrash(w http.ResponseWriter, r *http.Request) {
+	err := executeGetTrash(w, r, t)
+	if err != nil {
+		t.Logger.Error(trashError, "error", err)
+	}
+}
+
+// takes a transaction out of the trash and has the income and expense lists reload
+func (t *TrashHandler) HandleRestore(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := trashTarget(w, r)
+	if !ok {
+		return
+	}
+
+	ledgerID := CurrentMember(r).LedgerID
+	var err error
+	if kind == models.CategoryKindExpense {
+		err = t.As(auditActor(r)).RestoreExpense(ledgerID, id)
+	} else {
+		err = t.As(auditActor(r)).RestoreIncome(ledgerID, id)
+	}
+	if notFound(w, r, t.Logger, err, "Not in the trash") {
+		return
+	}
+	if err != nil {
+		t.Logger.Error("Failed to restore "+kind, "error", err)
+		http.Error(w, "Failed to restore "+kind, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("HX-Trigger", "transactionsRestored")
+	err = executeGetTrash(w, r, t)
+	if err != nil {
+		t.Logger.Error(trashError, "error", err)
+	}
+}
+
+// removes a transaction in the trash for good
+func (t *TrashHandler) HandlePurge(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := trashTarget(w, r)
+	if !ok {
+		return
+	}
+
+	ledgerID := CurrentMember(r).LedgerID
+	var err error
+	if kind == models.CategoryKindExpense {
+		err = t.As(auditActor(r)).PurgeExpense(ledgerID, id)
+	} else {
+		err = t.As(auditActor(r)).PurgeIncome(ledgerID, id)
+	}
+	if notFound(w, r, t.Logger, err, "Not in the trash") {
+		return
+	}
+	if err != nil {
+		t.Logger.Error("Failed to purge "+kind, "error", err)
+		http.Error(w, "Failed to purge "+kind, http.StatusInternalServerError)
+		return
+	}
+
+	err = executeGetTrash(w, r, t)
+	if err != nil {
+		t.Logger.Error(trashError, "error", err)
+	}
+}
+
+// trashTarget reads the kind and id URL params, writing a 400 if either is invalid
+func trashTarget(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	kind := chi.URLParam(r, "kind")
+	if !validCategoryKind(kind) {
+		http.Error(w, "Invalid kind", http.StatusBadRequest)
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid "+kind+" ID", http.StatusBadRequest)
+		return "", 0, false
+	}
+	return kind, id, true
+}
+
+func executeGetTrash(w http.ResponseWriter, r *http.Request, t *TrashHandler) error {
+	ledgerID := CurrentMember(r).LedgerID
+	expenses, err := t.GetDeletedExpenses(ledgerID)
+	if err != nil {
+		http.Error(w, trashError, http.StatusInternalServerError)
+		return err
+	}
+	incomes, err := t.GetDeletedIncomes(ledgerID)
+	if err != nil {
+		http.Error(w, trashError, http.StatusInternalServerError)
+		return err
+	}
+
+	items := make([]trashItem, 0, len(expenses)+len(incomes))
+	for _, e := range expenses {
+		items = append(items, trashItem{
+			Kind:       models.CategoryKindExpense,
+			ID:         e.ID,
+			Source:     e.Source,
+			Amount:     e.Amount,
+			OccurredOn: e.OccurredOn,
+			Category:   e.Category.Name,
+			DeletedAt:  e.DeletedAt.Time,
+			PurgeOn:    e.DeletedAt.Time.Add(t.retention),
+		})
+	}
+	for _, i := range incomes {
+		items = append(items, trashItem{
+			Kind:       models.CategoryKindIncome,
+			ID:         i.ID,
+			Source:     i.Source,
+			Amount:     i.Amount,
+			OccurredOn: i.OccurredOn,
+			Category:   i.Category.Name,
+			DeletedAt:  i.DeletedAt.Time,
+			PurgeOn:    i.DeletedAt.Time.Add(t.retention),
+		})
+	}
+	sort.SliceStable(items, func(a, b int) bool { return items[a].DeletedAt.After(items[b].DeletedAt) })
+
+	tmpl, err := template.ParseFS(t.webFS, "web/components/trash.html")
+	if err != nil {
+		http.Error(w, parseTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	err = tmpl.Execute(w, items)
+	if err != nil {
+		http.Error(w, executeTemplateError, http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/middleware"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+type userContextKey struct{}
+
+type ledgerContextKey struct{}
+
+// RequireUser refuses requests without a valid access token and puts the
+// logged in user on the request context for CurrentUser.
+func RequireUser(db database.Database) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, err := UsernameFromRequest(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			user, err := db.GetUser(username)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userContextKey{}, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CurrentUser is the user RequireUser found for the request.
+func CurrentUser(r *http.Request) models.User {
+	user, _ := r.Context().Value(userContextKey{}).(models.User)
+	return user
+}
+
+// ReportingCurrency is the current user's chosen currency, or fallback when
+// they haven't picked one
+func ReportingCurrency(r *http.Request, fallback string) string {
+	if currency := CurrentUser(r).ReportingCurrency; currency != "" {
+		return currency
+	}
+	return fallback
+}
+
+// RequireLedger puts the current user's membership of the ledger they picked
+// on the request context for CurrentMember, falling back to their first
+// ledger. It must come after RequireUser.
+func RequireLedger(db database.Database) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			member, err := pickedLedger(r, db)
+			if err != nil {
+				http.Error(w, "No ledger", http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ledgerContextKey{}, member)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func pickedLedger(r *http.Request, db database.Database) (models.LedgerMember, error) {
+	user := CurrentUser(r)
+	if cookie, err := r.Cookie(ledgerCookieName); err == nil {
+		id, err := strconv.Atoi(cookie.Value)
+		if err == nil {
+			member, err := db.GetMember(uint(id), user.ID)
+			if err == nil {
+				return member, nil
+			}
+			if !errors.Is(err, database.ErrNotFound) {
+				return models.LedgerMember{}, err
+			}
+		}
+	}
+
+	ledgers, err := db.GetLedgers(user.ID)
+	if err != nil {
+		return models.LedgerMember{}, err
+	}
+	if len(ledgers) == 0 {
+		return models.LedgerMember{}, database.ErrNotFound
+	}
+	return ledgers[0], nil
+}
+
+// RequireEditor only lets viewers of the current ledger read, it must come
+// after RequireLedger.
+func RequireEditor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !CurrentMember(r).CanEdit() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CurrentMember is the membership RequireLedger found for the request.
+func CurrentMember(r *http.Request) models.LedgerMember {
+	member, _ := r.Context().Value(ledgerContextKey{}).(models.LedgerMember)
+	return member
+}
+
+// auditActor is who the audit log records changes made by r against, falling
+// back to the access token's username for routes that don't look the user up.
+func auditActor(r *http.Request) database.Actor {
+	user := CurrentUser(r)
+	if user.Username == "" {
+		user.Username, _ = UsernameFromRequest(r)
+	}
+	return database.Actor{
+		UserID:    user.ID,
+		Username:  user.Username,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+}
+
+// notFound writes a 404 when err means the record doesn't exist in the current
+// ledger, logging attempts to reach another ledger's records. It reports
+// whether it handled err.
+func notFound(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error, msg string) bool {
+	if errors.Is(err, database.ErrOtherLedger) {
+		logger.Warn("Cross-ledger access attempt",
+			"user", CurrentUser(r).Username,
+			"ledger", CurrentMember(r).LedgerID,
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+	} else if !errors.Is(err, database.ErrNotFound) {
+		return false
+	}
+
+	http.Error(w, msg, http.StatusNotFound)
+	return true
+}
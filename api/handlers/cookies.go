@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Ewan-Greer09/finance-app/api/models"
@@ -10,6 +12,7 @@ import (
 
 var (
 	accessTokenCookieName = "access-token"
+	ledgerCookieName      = "ledger" // the ledger picked in the ledger switcher
 	jwtSecretKey          = "secret"
 )
 
@@ -24,6 +27,30 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
+// UsernameFromRequest returns the name held in a valid access-token cookie.
+func UsernameFromRequest(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(accessTokenCookieName)
+	if err != nil {
+		return "", err
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("Unexpected signing method")
+		}
+		return []byte(GetJWTSecret()), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid || claims.Name == "" {
+		return "", errors.New("invalid access token")
+	}
+
+	return claims.Name, nil
+}
+
 func generateAccessToken(user *models.User) (string, time.Time, error) {
 	expirationTime := time.Now().Add(24 * time.Hour * 7)
 	return generateToken(user, expirationTime, []byte(GetJWTSecret()))
@@ -87,3 +114,15 @@ func logoutTokenCookie(name, token string, w http.ResponseWriter) {
 
 	http.SetCookie(w, cookie)
 }
+
+// remembers the ledger picked in the ledger switcher, RequireLedger checks the
+// user is still a member on every request
+func setLedgerCookie(ledgerID uint, w http.ResponseWriter) {
+	cookie := new(http.Cookie)
+	cookie.Name = ledgerCookieName
+	cookie.Value = strconv.FormatUint(uint64(ledgerID), 10)
+	cookie.Path = "/"
+	cookie.HttpOnly = true
+
+	http.SetCookie(w, cookie)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Today is the current day at midnight UTC, the form transaction dates are stored in.
+func Today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// ParseDay reads a YYYY-MM-DD date as midnight UTC.
+func ParseDay(s string) (time.Time, error) {
+	return time.Parse(time.DateOnly, strings.TrimSpace(s))
+}
+
+// occurredOn reads the occurred_on form value, defaulting to today.
+func occurredOn(r *http.Request) (time.Time, error) {
+	value := r.FormValue("occurred_on")
+	if value == "" {
+		return Today(), nil
+	}
+	return ParseDay(value)
+}
+
+// ParseDateRange reads the optional from and to query parameters. ok is false
+// when neither was given.
+func ParseDateRange(r *http.Request) (from, to time.Time, ok bool, err error) {
+	query := r.URL.Query()
+	if v := query.Get("from"); v != "" {
+		from, err = ParseDay(v)
+		if err != nil {
+			return from, to, false, err
+		}
+		ok = true
+	}
+	if v := query.Get("to"); v != "" {
+		to, err = ParseDay(v)
+		if err != nil {
+			return from, to, false, err
+		}
+		ok = true
+	}
+	if ok && !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return from, to, false, errors.New("to is before from")
+	}
+	return from, to, ok, nil
+}
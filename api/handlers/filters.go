@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var errInvalidTag = errors.New("tags may only contain letters, numbers, - and _")
+
+// ParseTransactionFilter reads the list parameters:
+//
+//	?from=&to=                   inclusive range of days
+//	?min_amount=&max_amount=     inclusive range of amounts in ?currency=, which defaults to currency
+//	?source=                     case insensitive substring of the source
+//	?category_id=&account_id=&tag=
+//	?sort=date|amount|source     newest first by default, amounts are sorted within each currency
+//	?order=asc|desc              defaults to desc, or asc when sorting by source
+//
+// ok is false when none of them were given.
+func ParseTransactionFilter(r *http.Request, currency string) (filter database.TransactionFilter, ok bool, err error) {
+	filter.From, filter.To, ok, err = ParseDateRange(r)
+	if err != nil {
+		return filter, false, err
+	}
+	query := r.URL.Query()
+
+	if c := query.Get("currency"); c != "" {
+		currency = strings.ToUpper(strings.TrimSpace(c))
+		if !money.ValidCurrency(currency) {
+			return filter, false, errors.New("invalid currency")
+		}
+	}
+	for key, bound := range map[string]**money.Money{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		amount, err := money.Parse(v, currency)
+		if err != nil {
+			return filter, false, errors.New("invalid " + strings.ReplaceAll(key, "_", " "))
+		}
+		*bound = &amount
+		ok = true
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && filter.MaxAmount.Minor < filter.MinAmount.Minor {
+		return filter, false, errors.New("max amount is below min amount")
+	}
+
+	if source := strings.TrimSpace(query.Get("source")); source != "" {
+		filter.Source = source
+		ok = true
+	}
+	for key, id := range map[string]*uint{"category_id": &filter.CategoryID, "account_id": &filter.AccountID} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return filter, false, errors.New("invalid " + strings.ReplaceAll(key, "_", " "))
+		}
+		*id = uint(n)
+		ok = true
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		filter.Tag, err = normalizeTag(tag)
+		if err != nil {
+			return filter, false, err
+		}
+		ok = true
+	}
+
+	switch sort := database.SortField(query.Get("sort")); sort {
+	case "":
+	case database.SortDate, database.SortAmount, database.SortSource:
+		filter.Sort = sort
+		ok = true
+	default:
+		return filter, false, errors.New("invalid sort")
+	}
+	switch query.Get("order") {
+	case "":
+		filter.Ascending = filter.Sort == database.SortSource
+	case "asc":
+		filter.Ascending = true
+		ok = true
+	case "desc":
+		ok = true
+	default:
+		return filter, false, errors.New("invalid order")
+	}
+	return filter, ok, nil
+}
+
+// normalizeTag lowercases a tag and turns spaces into dashes, so "Holiday 2026"
+// and "holiday-2026" are the same tag
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+	if tag == "" {
+		return "", errInvalidTag
+	}
+	for _, c := range tag {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '_' {
+			return "", errInvalidTag
+		}
+	}
+	return tag, nil
+}
+
+// parseTags reads a comma separated list of tags, dropping duplicates
+func parseTags(value string) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		tag, err := normalizeTag(part)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func tagModels(tags []string) []models.Tag {
+	var out []models.Tag
+	for _, tag := range tags {
+		out = append(out, models.Tag{Name: tag})
+	}
+	return out
+}
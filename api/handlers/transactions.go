@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ewan-Greer09/finance-app/api/database"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+// transactionFields are the parts of an income or expense an edit can change
+type transactionFields struct {
+	Source     string
+	Notes      string
+	Amount     money.Money
+	OccurredOn time.Time
+	CategoryID uint
+	AccountID  uint
+}
+
+// editFromForm applies an edit form to current. PUT replaces every field, with
+// the same defaults as adding for anything left out, while PATCH only changes
+// the fields that were sent. Either way the amount keeps its currency unless
+// another is given.
+func editFromForm(r *http.Request, db database.Database, sourceField, kind string, current transactionFields) (transactionFields, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return transactionFields{}, err
+	}
+	partial := r.Method == http.MethodPatch
+	sent := func(key string) bool {
+		return !partial || r.Form.Has(key)
+	}
+
+	edited := current
+	if sent(sourceField) {
+		edited.Source = r.FormValue(sourceField)
+	}
+	if sent("notes") {
+		edited.Notes = r.FormValue("notes")
+	}
+	if sent("amount") || r.Form.Has("currency") {
+		value, currency := current.Amount.Decimal(), current.Amount.Currency
+		if r.Form.Has("amount") || !partial {
+			value = r.FormValue("amount")
+		}
+		if c := r.FormValue("currency"); c != "" {
+			currency = c
+		}
+		edited.Amount, err = money.Parse(value, currency)
+		if err != nil {
+			return transactionFields{}, errors.New("invalid amount")
+		}
+	}
+	if sent("occurred_on") {
+		edited.OccurredOn, err = occurredOn(r)
+		if err != nil {
+			return transactionFields{}, errors.New("invalid date")
+		}
+	}
+	if sent("category_id") {
+		category, err := categoryFromForm(r, db, kind)
+		if err != nil {
+			return transactionFields{}, errors.New("invalid category")
+		}
+		edited.CategoryID = category.ID
+	}
+	if sent("account_id") {
+		account, err := accountFromForm(r, db)
+		if err != nil {
+			return transactionFields{}, errors.New("invalid account")
+		}
+		edited.AccountID = account.ID
+	}
+	return edited, nil
+}
+
+// pageView is one page of an income or expense list. More is the URL of the
+// next page, empty on the last one.
+type pageView struct {
+	Items interface{}
+	More  string
+}
+
+// listQuery reads the filter and ?limit=&cursor= page of an income or expense
+// list, amounts without a currency are in currency
+func listQuery(r *http.Request, currency string) (database.TransactionFilter, database.Page, error) {
+	filter, _, err := ParseTransactionFilter(r, currency)
+	if err != nil {
+		return filter, database.Page{}, err
+	}
+	page, err := parsePage(r)
+	return filter, page, err
+}
+
+// parsePage reads the ?limit=&cursor= list parameters
+func parsePage(r *http.Request) (database.Page, error) {
+	query := r.URL.Query()
+	page := database.Page{Limit: database.DefaultPageSize, Cursor: query.Get("cursor")}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > database.MaxPageSize {
+			return database.Page{}, errors.New("invalid limit")
+		}
+		page.Limit = limit
+	}
+	return page, nil
+}
+
+// nextPageURL is the list at path carrying on from next, keeping r's filters
+// and page size. It's empty when there is no next page.
+func nextPageURL(r *http.Request, path string, page database.Page, next string) string {
+	if next == "" {
+		return ""
+	}
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	query.Set("limit", strconv.Itoa(page.Limit))
+	return path + "?" + query.Encode()
+}
+
+// isNextPage reports whether r asked for a later page, which is rendered
+// without the list around it so it can be appended to what's shown
+func isNextPage(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Query().Get("cursor") != ""
+}
@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job is background work run once when the scheduler starts, which covers
+// anything missed while the server was down, and then on every tick.
+type Job struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Scheduler runs jobs one after another on a fixed interval until stopped.
+type Scheduler struct {
+	Logger   *slog.Logger
+	interval time.Duration
+	jobs     []Job
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(logger *slog.Logger, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		Logger:   logger,
+		interval: interval,
+	}
+}
+
+// Add registers a job, it must be called before Start.
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.runAll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	s.Logger.Info("Started scheduler", "interval", s.interval.String(), "jobs", len(s.jobs))
+}
+
+// Stop cancels the running job, if any, and waits for it to return.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	s.Logger.Info("Stopped scheduler")
+}
+
+func (s *Scheduler) runAll(ctx context.Context) {
+	for _, job := range s.jobs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := job.Run(ctx)
+		if err != nil && ctx.Err() == nil {
+			s.Logger.Error("Scheduled job failed", "job", job.Name, "error", err)
+		}
+	}
+}
@@ -1,19 +1,216 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
 	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/money"
 )
 
+// Income and Expense keep gorm's CreatedAt as the audit timestamp, OccurredOn
+// is the day the money actually moved and is what lists and reports use. Each
+// belongs to a ledger and is only visible to its members, UserID is whoever
+// entered it. Status tracks it against the account's bank statements.
 type Income struct {
 	gorm.Model
-	Amount string `json:"amount"` // string to avoid issues with it removing trailing zeros
-	Source string `json:"source"`
+	LedgerID   uint        `json:"ledger_id" gorm:"index"`
+	UserID     uint        `json:"user_id" gorm:"index"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Source     string      `json:"source"`
+	Notes      string      `json:"notes"`
+	OccurredOn time.Time   `json:"occurred_on" gorm:"index"`
+	CategoryID uint        `json:"category_id" gorm:"index"`
+	Category   Category    `json:"category"`
+	Tags       []Tag       `json:"tags" gorm:"many2many:income_tags"`
+	AccountID  uint        `json:"account_id" gorm:"index"`
+	Account    Account     `json:"account"`
+	Status     string      `json:"status" gorm:"default:pending;index"`
 }
 
 type Expense struct {
 	gorm.Model
-	Amount string `json:"amount"`
-	Source string `json:"source"`
+	LedgerID   uint        `json:"ledger_id" gorm:"index"`
+	UserID     uint        `json:"user_id" gorm:"index"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Source     string      `json:"source"`
+	Notes      string      `json:"notes"`
+	OccurredOn time.Time   `json:"occurred_on" gorm:"index"`
+	CategoryID uint        `json:"category_id" gorm:"index"`
+	Category   Category    `json:"category"`
+	Tags       []Tag       `json:"tags" gorm:"many2many:expense_tags"`
+	AccountID  uint        `json:"account_id" gorm:"index"`
+	Account    Account     `json:"account"`
+	Status     string      `json:"status" gorm:"default:pending;index"`
+	// optional breakdown across categories, when set the lines must add up to Amount
+	Splits []ExpenseSplit `json:"splits,omitempty"`
+}
+
+// ExpenseSplit is one line of a split expense, e.g. the groceries on a
+// supermarket receipt that also covered household items.
+type ExpenseSplit struct {
+	gorm.Model
+	ExpenseID  uint        `json:"expense_id" gorm:"index"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	CategoryID uint        `json:"category_id" gorm:"index"`
+	Category   Category    `json:"category"`
+}
+
+var ErrInvalidSplits = errors.New("invalid split lines")
+
+// ValidateSplits checks the split lines are non-zero, in the expense's
+// currency and add up to its amount. An expense without splits is valid.
+func (e Expense) ValidateSplits() error {
+	if len(e.Splits) == 0 {
+		return nil
+	}
+
+	total := money.New(0, e.Amount.Currency)
+	for _, split := range e.Splits {
+		if split.Amount.IsZero() {
+			return fmt.Errorf("%w: lines can't be zero", ErrInvalidSplits)
+		}
+
+		var err error
+		total, err = total.Add(split.Amount)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSplits, err)
+		}
+	}
+
+	if total != e.Amount {
+		return fmt.Errorf("%w: lines add up to %s, not %s", ErrInvalidSplits, total, e.Amount)
+	}
+	return nil
+}
+
+const (
+	AccountTypeCurrent    = "current"
+	AccountTypeCreditCard = "credit_card"
+	AccountTypeCash       = "cash"
+	AccountTypeSavings    = "savings"
+	AccountTypeBrokerage  = "brokerage"
+	AccountTypeISA        = "isa"
+	// a loan's own account, what is owed on it as a negative balance
+	AccountTypeLoan = "loan"
+)
+
+// Account is somewhere a ledger's money is held. Its currency is the currency
+// of the opening balance.
+type Account struct {
+	gorm.Model
+	LedgerID       uint        `json:"ledger_id" gorm:"index"`
+	Name           string      `json:"name"`
+	Type           string      `json:"type"`
+	OpeningBalance money.Money `json:"opening_balance" gorm:"embedded;embeddedPrefix:opening_balance_"`
+}
+
+func (a Account) Currency() string {
+	return a.OpeningBalance.Currency
+}
+
+// Transfer moves money between two of the ledger's accounts, so it is neither
+// an income nor an expense. Amount leaves FromAccount in its currency and
+// ReceivedAmount arrives in ToAccount, they only differ across currencies.
+type Transfer struct {
+	gorm.Model
+	LedgerID       uint        `json:"ledger_id" gorm:"index"`
+	FromAccountID  uint        `json:"from_account_id" gorm:"index"`
+	FromAccount    Account     `json:"from_account"`
+	ToAccountID    uint        `json:"to_account_id" gorm:"index"`
+	ToAccount      Account     `json:"to_account"`
+	Amount         money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	ReceivedAmount money.Money `json:"received_amount" gorm:"embedded;embeddedPrefix:received_"`
+	OccurredOn     time.Time   `json:"occurred_on" gorm:"index"`
+	Note           string      `json:"note"`
+	// each account's statement shows its side of the transfer separately
+	FromStatus string `json:"from_status" gorm:"default:pending"`
+	ToStatus   string `json:"to_status" gorm:"default:pending"`
+}
+
+// where a transaction is in reconciling its account against bank statements
+const (
+	StatusPending    = "pending"    // not ticked off against a statement yet
+	StatusCleared    = "cleared"    // ticked off in a reconciliation that hasn't finished
+	StatusReconciled = "reconciled" // part of a finished reconciliation, locked from edits
+)
+
+// Reconciliation checks an account against a bank statement: transactions up
+// to StatementDate are ticked off as cleared until they add up to
+// ClosingBalance, then finishing it reconciles them. An account has at most
+// one open reconciliation.
+type Reconciliation struct {
+	gorm.Model
+	LedgerID       uint        `json:"ledger_id" gorm:"index"`
+	AccountID      uint        `json:"account_id" gorm:"index"`
+	Account        Account     `json:"account"`
+	StatementDate  time.Time   `json:"statement_date"`
+	ClosingBalance money.Money `json:"closing_balance" gorm:"embedded;embeddedPrefix:closing_balance_"`
+	// nil while it's open
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// ReconcileLine is an income, expense or one side of a transfer on the
+// account being reconciled. Amount is its effect on the account's balance, in
+// the account's currency.
+type ReconcileLine struct {
+	Kind        string // JournalExpense, JournalIncome or JournalTransfer
+	ID          uint
+	OccurredOn  time.Time
+	Description string
+	Amount      money.Money
+	Status      string
+}
+
+// ReconciliationProgress is an open or finished reconciliation. Reconciled is
+// the balance of everything reconciled before it, Cleared adds the lines
+// ticked off so far and Difference is what is still to be found to match the
+// statement. Incomplete is set when some lines couldn't be converted into the
+// account's currency for lack of an exchange rate and were left out.
+type ReconciliationProgress struct {
+	Reconciliation Reconciliation
+	Lines          []ReconcileLine
+	Reconciled     money.Money
+	Cleared        money.Money
+	Difference     money.Money
+	Incomplete     bool
+}
+
+// AccountBalance is an account's balance at the end of a day, in the
+// account's currency. Incomplete is set when some transactions couldn't be
+// converted for lack of an exchange rate and were left out.
+type AccountBalance struct {
+	Account    Account     `json:"account"`
+	Balance    money.Money `json:"balance"`
+	Incomplete bool        `json:"incomplete"`
+}
+
+// Tag is a free-form label, a transaction can carry any number of them.
+type Tag struct {
+	gorm.Model
+	Name string `json:"name" gorm:"uniqueIndex"`
+}
+
+const (
+	CategoryKindExpense = "expense"
+	CategoryKindIncome  = "income"
+)
+
+// Category is a node in the expense or income category tree. Top level
+// categories have no ParentID, children always share their parent's Kind.
+type Category struct {
+	gorm.Model
+	Name     string     `json:"name"`
+	Kind     string     `json:"kind" gorm:"index"`
+	ParentID *uint      `json:"parent_id" gorm:"index"`
+	Children []Category `json:"children,omitempty" gorm:"foreignKey:ParentID"`
 }
 
 type User struct {
@@ -21,4 +218,1081 @@ type User struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 	IsAdmin  bool   `json:"is_admin"`
+	// totals and graphs are converted into this currency, empty means the configured default
+	ReportingCurrency string `json:"reporting_currency" gorm:"size:3"`
+}
+
+const (
+	RoleOwner  = "owner"  // can also manage members
+	RoleEditor = "editor" // can add, change and delete records
+	RoleViewer = "viewer" // read only
+)
+
+// Ledger is a set of books, such as a household's joint finances or someone's
+// private ones. Every user gets a personal ledger and can be invited to others.
+type Ledger struct {
+	gorm.Model
+	Name    string         `json:"name"`
+	Members []LedgerMember `json:"members,omitempty"`
+}
+
+// LedgerMember gives a user a role in a ledger, every ledger keeps at least one owner.
+type LedgerMember struct {
+	gorm.Model
+	LedgerID uint   `json:"ledger_id" gorm:"uniqueIndex:idx_ledger_member"`
+	Ledger   Ledger `json:"ledger"`
+	UserID   uint   `json:"user_id" gorm:"uniqueIndex:idx_ledger_member"`
+	User     User   `json:"-"`
+	Role     string `json:"role"`
+}
+
+func (m LedgerMember) CanEdit() bool {
+	return m.Role == RoleOwner || m.Role == RoleEditor
+}
+
+func (m LedgerMember) IsOwner() bool {
+	return m.Role == RoleOwner
+}
+
+// ExchangeRate is the price of one unit of Base in Quote, as published on Date.
+type ExchangeRate struct {
+	gorm.Model
+	Date  time.Time `json:"date" gorm:"uniqueIndex:idx_exchange_rate"`
+	Base  string    `json:"base" gorm:"size:3;uniqueIndex:idx_exchange_rate"`
+	Quote string    `json:"quote" gorm:"size:3;uniqueIndex:idx_exchange_rate"`
+	Rate  float64   `json:"rate"`
+}
+
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyYearly  = "yearly"
+	FrequencyCustom  = "custom"
+)
+
+// Recurring is a template for an income or expense that is posted
+// automatically, such as rent or a salary. Frequency and Interval describe
+// simple schedules, custom ones use an RRULE instead.
+type Recurring struct {
+	gorm.Model
+	LedgerID   uint        `json:"ledger_id" gorm:"index"`
+	UserID     uint        `json:"user_id" gorm:"index"`
+	Kind       string      `json:"kind"` // CategoryKindExpense or CategoryKindIncome
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Source     string      `json:"source"`
+	CategoryID uint        `json:"category_id" gorm:"index"`
+	Category   Category    `json:"category"`
+	AccountID  uint        `json:"account_id" gorm:"index"`
+	Account    Account     `json:"account"`
+	Frequency  string      `json:"frequency"`
+	Interval   int         `json:"interval"`
+	RRule      string      `json:"rrule"`
+	StartOn    time.Time   `json:"start_on"`
+	EndOn      *time.Time  `json:"end_on"`
+	// the next occurrence still to be posted, nil once the schedule has finished
+	NextOn *time.Time `json:"next_on" gorm:"index"`
+}
+
+// Describe is the schedule in words for the recurring list, e.g. "every 2 weeks".
+func (r Recurring) Describe() string {
+	if r.Frequency == FrequencyCustom {
+		return r.RRule
+	}
+	if r.Interval <= 1 {
+		return r.Frequency
+	}
+
+	units := map[string]string{
+		FrequencyDaily:   "days",
+		FrequencyWeekly:  "weeks",
+		FrequencyMonthly: "months",
+		FrequencyYearly:  "years",
+	}
+	return fmt.Sprintf("every %d %s", r.Interval, units[r.Frequency])
+}
+
+var ErrInvalidBill = errors.New("invalid bill")
+
+// how a bill stands against the day it is next due
+const (
+	BillOverdue  = "overdue"
+	BillDueSoon  = "due soon" // within the reminder window
+	BillUpcoming = "upcoming"
+	BillFinished = "finished" // its schedule has no more due dates
+)
+
+// Bill is a payment due on a schedule, such as a utility or a subscription.
+// Unlike a Recurring one it isn't posted automatically: marking it paid books
+// the expense and moves DueOn to the next due date. An Estimated amount is
+// only a guide, the actual amount is entered when it is paid.
+type Bill struct {
+	gorm.Model
+	LedgerID   uint        `json:"ledger_id" gorm:"index"`
+	UserID     uint        `json:"user_id" gorm:"index"`
+	Payee      string      `json:"payee"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Estimated  bool        `json:"estimated"`
+	CategoryID uint        `json:"category_id" gorm:"index"`
+	Category   Category    `json:"category"`
+	AccountID  uint        `json:"account_id" gorm:"index"`
+	Account    Account     `json:"account"`
+	Frequency  string      `json:"frequency"`
+	Interval   int         `json:"interval"`
+	RRule      string      `json:"rrule"`
+	StartOn    time.Time   `json:"start_on"`
+	EndOn      *time.Time  `json:"end_on"`
+	// the next due date still to be paid, nil once the schedule has finished
+	DueOn *time.Time `json:"due_on" gorm:"index"`
+}
+
+func (b Bill) Validate() error {
+	switch {
+	case b.Payee == "":
+		return fmt.Errorf("%w: payee is required", ErrInvalidBill)
+	case b.Amount.IsNegative() || b.Amount.IsZero():
+		return fmt.Errorf("%w: amount must be more than zero", ErrInvalidBill)
+	case b.Frequency == FrequencyCustom && strings.TrimSpace(b.RRule) == "":
+		return fmt.Errorf("%w: a custom frequency needs an RRULE", ErrInvalidBill)
+	}
+	switch b.Frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly, FrequencyYearly, FrequencyCustom:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown frequency %q", ErrInvalidBill, b.Frequency)
+}
+
+// Recurrence is the bill's due date rule as a Recurring, so it can be
+// scheduled and described the same way
+func (b Bill) Recurrence() Recurring {
+	return Recurring{
+		Frequency: b.Frequency,
+		Interval:  b.Interval,
+		RRule:     b.RRule,
+		StartOn:   b.StartOn,
+		EndOn:     b.EndOn,
+	}
+}
+
+// Status is BillOverdue once the due date has passed, BillDueSoon from
+// remindDays before it and BillUpcoming until then
+func (b Bill) Status(today time.Time, remindDays int) string {
+	switch {
+	case b.DueOn == nil:
+		return BillFinished
+	case b.DueOn.Before(today):
+		return BillOverdue
+	case !b.DueOn.After(today.AddDate(0, 0, remindDays)):
+		return BillDueSoon
+	}
+	return BillUpcoming
+}
+
+// Notification is an in-app message shown to a ledger's members until one of
+// them dismisses it. Bill reminders are raised once per bill and due date.
+type Notification struct {
+	gorm.Model
+	LedgerID uint       `json:"ledger_id" gorm:"index"`
+	Message  string     `json:"message"`
+	BillID   uint       `json:"bill_id,omitempty" gorm:"uniqueIndex:idx_bill_reminder"`
+	DueOn    *time.Time `json:"due_on,omitempty" gorm:"uniqueIndex:idx_bill_reminder"`
+	ReadAt   *time.Time `json:"read_at"`
+}
+
+const (
+	BudgetPeriodWeekly  = "weekly"
+	BudgetPeriodMonthly = "monthly"
+	BudgetPeriodYearly  = "yearly"
+)
+
+const (
+	// unspent and overspent amounts are forgotten at the end of each period
+	RolloverNone = "none"
+	// unspent money carries into the next period, overspending doesn't
+	RolloverUnspent = "unspent"
+	// both unspent money and overspending carry into the next period
+	RolloverAll = "all"
+)
+
+// Budget caps spending in a category, including its subcategories, per
+// period. There is at most one budget per category and period in a ledger.
+type Budget struct {
+	gorm.Model
+	LedgerID   uint        `json:"ledger_id" gorm:"uniqueIndex:idx_ledger_budget"`
+	CategoryID uint        `json:"category_id" gorm:"uniqueIndex:idx_ledger_budget"`
+	Category   Category    `json:"category"`
+	Period     string      `json:"period" gorm:"uniqueIndex:idx_ledger_budget"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	Rollover   string      `json:"rollover"`
+	// rollover is counted from the period containing this day
+	StartOn time.Time `json:"start_on"`
+}
+
+// BudgetProgress is a budget's current period, in the budget's currency.
+// Available is the budget plus anything rolled over, Incomplete is set when
+// some spending couldn't be converted for lack of an exchange rate.
+type BudgetProgress struct {
+	Budget     Budget      `json:"budget"`
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+	RolledOver money.Money `json:"rolled_over"`
+	Available  money.Money `json:"available"`
+	Spent      money.Money `json:"spent"`
+	Remaining  money.Money `json:"remaining"`
+	Incomplete bool        `json:"incomplete"`
+}
+
+// Percent is how much of the available budget has been spent, for progress bars.
+func (p BudgetProgress) Percent() int {
+	if p.Available.Minor <= 0 {
+		if p.Spent.Minor > 0 {
+			return 100
+		}
+		return 0
+	}
+	return int(p.Spent.Minor * 100 / p.Available.Minor)
+}
+
+// Goal is something being saved up for, such as a house deposit. The target
+// date is optional, contributions are in the target's currency.
+type Goal struct {
+	gorm.Model
+	LedgerID      uint               `json:"ledger_id" gorm:"index"`
+	Name          string             `json:"name"`
+	Target        money.Money        `json:"target" gorm:"embedded;embeddedPrefix:target_"`
+	TargetDate    *time.Time         `json:"target_date"`
+	AccountID     uint               `json:"account_id" gorm:"index"`
+	Account       Account            `json:"account"`
+	Contributions []GoalContribution `json:"contributions,omitempty"`
+}
+
+// GoalContribution is money put towards a goal, negative amounts are withdrawals.
+type GoalContribution struct {
+	gorm.Model
+	GoalID     uint        `json:"goal_id" gorm:"index"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	OccurredOn time.Time   `json:"occurred_on" gorm:"index"`
+}
+
+// GoalProgress is how far a goal has come as of a day. RequiredMonthly is only
+// set while there is a target date still ahead, Projected only once there is a
+// contribution pace to go on.
+type GoalProgress struct {
+	Goal            Goal         `json:"goal"`
+	Saved           money.Money  `json:"saved"`
+	Remaining       money.Money  `json:"remaining"`
+	Percent         int          `json:"percent"`
+	RequiredMonthly *money.Money `json:"required_monthly"`
+	Projected       *time.Time   `json:"projected"`
+}
+
+// Progress works out a goal's progress on the day on. The projected
+// completion date assumes saving carries on at the average daily pace since
+// the goal was created or first contributed to, whichever came first.
+func (g Goal) Progress(on time.Time) GoalProgress {
+	on = on.UTC().Truncate(24 * time.Hour)
+	currency := g.Target.Currency
+	p := GoalProgress{Goal: g, Saved: money.New(0, currency)}
+
+	start := g.CreatedAt.UTC().Truncate(24 * time.Hour)
+	for _, c := range g.Contributions {
+		if c.OccurredOn.After(on) || c.Amount.Currency != currency {
+			continue
+		}
+		p.Saved = money.New(p.Saved.Minor+c.Amount.Minor, currency)
+		if c.OccurredOn.Before(start) {
+			start = c.OccurredOn
+		}
+	}
+
+	p.Remaining = money.New(max(g.Target.Minor-p.Saved.Minor, 0), currency)
+	if g.Target.Minor > 0 {
+		p.Percent = int(max(p.Saved.Minor, 0) * 100 / g.Target.Minor)
+	}
+
+	if p.Remaining.IsZero() {
+		p.Projected = &on
+		return p
+	}
+
+	if g.TargetDate != nil && g.TargetDate.After(on) {
+		months := int64(monthsBetween(on, *g.TargetDate))
+		required := money.New((p.Remaining.Minor+months-1)/months, currency)
+		p.RequiredMonthly = &required
+	}
+
+	if p.Saved.Minor > 0 {
+		days := max(int64(on.Sub(start).Hours()/24), 1)
+		needed := (p.Remaining.Minor*days + p.Saved.Minor - 1) / p.Saved.Minor
+		projected := on.AddDate(0, 0, int(needed))
+		p.Projected = &projected
+	}
+	return p
+}
+
+// monthsBetween counts the whole months from from to to, never less than one
+// so there is always at least one payment left to make.
+func monthsBetween(from, to time.Time) int {
+	months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+	if to.Day() < from.Day() {
+		months--
+	}
+	return max(months, 1)
+}
+
+var ErrInvalidLoan = errors.New("invalid loan")
+
+// Loan is money owed, such as a mortgage or car loan, paid back in Term
+// instalments every Interval weeks, months or years from FirstPaymentOn.
+// AnnualRate is the nominal yearly interest rate as a percentage, charged on
+// what is still owed each period. A loan that is already part paid is entered
+// with what is still owed and its next payment.
+type Loan struct {
+	gorm.Model
+	LedgerID       uint        `json:"ledger_id" gorm:"index"`
+	Name           string      `json:"name"`
+	Principal      money.Money `json:"principal" gorm:"embedded;embeddedPrefix:principal_"`
+	AnnualRate     float64     `json:"annual_rate"`
+	Term           int         `json:"term"`
+	Frequency      string      `json:"frequency"` // FrequencyWeekly, FrequencyMonthly or FrequencyYearly
+	Interval       int         `json:"interval"`
+	FirstPaymentOn time.Time   `json:"first_payment_on"`
+	// payments are paid from the account, the interest as an expense under
+	// the category and the principal as a transfer to LiabilityAccount
+	AccountID          uint          `json:"account_id" gorm:"index"`
+	Account            Account       `json:"account"`
+	CategoryID         uint          `json:"category_id" gorm:"index"`
+	Category           Category      `json:"category"`
+	LiabilityAccountID uint          `json:"liability_account_id" gorm:"index"`
+	LiabilityAccount   Account       `json:"liability_account"`
+	Payments           []LoanPayment `json:"payments,omitempty"`
+}
+
+// LoanPayment is an instalment of a loan that has been paid. Its Interest is
+// posted as the Expense ExpenseID and its Principal as the Transfer
+// TransferID, either is 0 when there was none. Payments from before the
+// principal was transferred have the whole payment in the expense.
+type LoanPayment struct {
+	gorm.Model
+	LoanID     uint        `json:"loan_id" gorm:"index"`
+	Number     int         `json:"number"`
+	ExpenseID  uint        `json:"expense_id" gorm:"index"`
+	TransferID uint        `json:"transfer_id" gorm:"index"`
+	Interest   money.Money `json:"interest" gorm:"embedded;embeddedPrefix:interest_"`
+	Principal  money.Money `json:"principal" gorm:"embedded;embeddedPrefix:principal_"`
+}
+
+// Instalment is one payment in a loan's amortization schedule, Balance is
+// what is still owed once it's paid.
+type Instalment struct {
+	Number    int
+	DueOn     time.Time
+	Payment   money.Money
+	Interest  money.Money
+	Principal money.Money
+	Balance   money.Money
+	Paid      bool
+}
+
+// LoanProgress is where a loan stands on a day. Next is nil and Outstanding
+// zero once it's paid off, PayoffOn assumes the rest is paid on schedule.
+type LoanProgress struct {
+	Loan         Loan
+	Schedule     []Instalment
+	Outstanding  money.Money
+	InterestPaid money.Money
+	Next         *Instalment
+	Overdue      int
+	PayoffOn     time.Time
+}
+
+func (l Loan) Validate() error {
+	switch {
+	case strings.TrimSpace(l.Name) == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidLoan)
+	case l.Principal.IsNegative() || l.Principal.IsZero():
+		return fmt.Errorf("%w: principal must be more than zero", ErrInvalidLoan)
+	case l.AnnualRate < 0 || l.AnnualRate >= 100:
+		return fmt.Errorf("%w: rate must be from 0 to 100%%", ErrInvalidLoan)
+	case l.Term < 1 || l.Term > 1200:
+		return fmt.Errorf("%w: term must be from 1 to 1200 payments", ErrInvalidLoan)
+	case l.Interval < 1:
+		return fmt.Errorf("%w: interval must be at least 1", ErrInvalidLoan)
+	}
+	switch l.Frequency {
+	case FrequencyWeekly, FrequencyMonthly, FrequencyYearly:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown frequency %q", ErrInvalidLoan, l.Frequency)
+}
+
+// Describe is the payment frequency in words, e.g. "every 2 weeks".
+func (l Loan) Describe() string {
+	return Recurring{Frequency: l.Frequency, Interval: l.Interval}.Describe()
+}
+
+// DueOn is the day instalment n, counting from 1, is due. Monthly payments
+// from the 31st fall on the last day of shorter months.
+func (l Loan) DueOn(n int) time.Time {
+	first := l.FirstPaymentOn.UTC().Truncate(24 * time.Hour)
+	periods := (n - 1) * max(l.Interval, 1)
+	switch l.Frequency {
+	case FrequencyWeekly:
+		return first.AddDate(0, 0, 7*periods)
+	case FrequencyYearly:
+		return addMonths(first, 12*periods)
+	default:
+		return addMonths(first, periods)
+	}
+}
+
+// addMonths moves t on by months, keeping to the last day of the month when
+// the day doesn't exist in it
+func addMonths(t time.Time, months int) time.Time {
+	month := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	last := month.AddDate(0, 1, -1).Day()
+	return month.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+// periodRate is the interest charged each period, as a fraction
+func (l Loan) periodRate() float64 {
+	perYear := map[string]float64{
+		FrequencyWeekly:  52,
+		FrequencyMonthly: 12,
+		FrequencyYearly:  1,
+	}[l.Frequency] / float64(max(l.Interval, 1))
+	return l.AnnualRate / 100 / perYear
+}
+
+// Amortize is the loan's full repayment schedule: equal payments, each paying
+// the period's interest first and the rest off what is owed. Amounts are
+// rounded to the minor unit, the last payment takes up the difference.
+func (l Loan) Amortize() []Instalment {
+	currency := l.Principal.Currency
+	rate := l.periodRate()
+
+	var payment int64
+	if rate == 0 {
+		payment = (l.Principal.Minor + int64(l.Term) - 1) / int64(l.Term)
+	} else {
+		payment = int64(math.Round(float64(l.Principal.Minor) * rate / (1 - math.Pow(1+rate, -float64(l.Term)))))
+	}
+
+	schedule := make([]Instalment, 0, l.Term)
+	balance := l.Principal.Minor
+	for n := 1; n <= l.Term && balance > 0; n++ {
+		interest := int64(math.Round(float64(balance) * rate))
+		principal := min(payment-interest, balance)
+		if n == l.Term {
+			principal = balance
+		}
+		balance -= principal
+		schedule = append(schedule, Instalment{
+			Number:    n,
+			DueOn:     l.DueOn(n),
+			Payment:   money.New(interest+principal, currency),
+			Interest:  money.New(interest, currency),
+			Principal: money.New(principal, currency),
+			Balance:   money.New(balance, currency),
+		})
+	}
+	return schedule
+}
+
+// Progress works out what is still owed on the day on from the loan's
+// Payments, which instalments are overdue and when it will be paid off.
+func (l Loan) Progress(on time.Time) LoanProgress {
+	on = on.UTC().Truncate(24 * time.Hour)
+	currency := l.Principal.Currency
+	p := LoanProgress{
+		Loan:         l,
+		Schedule:     l.Amortize(),
+		Outstanding:  l.Principal,
+		InterestPaid: money.New(0, currency),
+	}
+
+	// an instalment paid twice, say by restoring a deleted payment after paying
+	// it again, only comes off what is owed once
+	paid := map[int]bool{}
+	for _, payment := range l.Payments {
+		if paid[payment.Number] {
+			continue
+		}
+		paid[payment.Number] = true
+		p.Outstanding = money.New(p.Outstanding.Minor-payment.Principal.Minor, currency)
+		p.InterestPaid = money.New(p.InterestPaid.Minor+payment.Interest.Minor, currency)
+	}
+
+	for i := range p.Schedule {
+		instalment := &p.Schedule[i]
+		instalment.Paid = paid[instalment.Number]
+		if instalment.Paid {
+			continue
+		}
+		if p.Next == nil {
+			p.Next = instalment
+		}
+		if instalment.DueOn.Before(on) {
+			p.Overdue++
+		}
+	}
+	if len(p.Schedule) > 0 {
+		p.PayoffOn = p.Schedule[len(p.Schedule)-1].DueOn
+	}
+	return p
+}
+
+var ErrInvalidTrade = errors.New("invalid trade")
+
+// Security is a share, fund or bond held in a brokerage or ISA account.
+// Securities are shared between ledgers, unlike accounts, and are priced in
+// Currency.
+type Security struct {
+	gorm.Model
+	Symbol   string `json:"symbol" gorm:"uniqueIndex"`
+	Name     string `json:"name"`
+	Currency string `json:"currency" gorm:"size:3"`
+}
+
+// SecurityPrice is a security's closing price on a day, in the security's
+// currency. Prices can have more decimal places than the currency does.
+type SecurityPrice struct {
+	gorm.Model
+	SecurityID uint      `json:"security_id" gorm:"uniqueIndex:idx_security_price"`
+	Date       time.Time `json:"date" gorm:"uniqueIndex:idx_security_price"`
+	Price      float64   `json:"price"`
+}
+
+// PriceQuote is a row of a prices file, before its symbol is matched to a Security.
+type PriceQuote struct {
+	Symbol string
+	Date   time.Time
+	Price  float64
+}
+
+const (
+	TradeBuy      = "buy"
+	TradeSell     = "sell"
+	TradeDividend = "dividend"
+)
+
+// Trade is a buy or sell of a security in an investment account, or a
+// dividend it paid. Amount is the total in the security's currency: what a
+// buy cost including fees, what a sell raised after them, or the dividend
+// received, which is booked as the Income IncomeID.
+type Trade struct {
+	gorm.Model
+	LedgerID   uint        `json:"ledger_id" gorm:"index"`
+	AccountID  uint        `json:"account_id" gorm:"index"`
+	Account    Account     `json:"account"`
+	SecurityID uint        `json:"security_id" gorm:"index"`
+	Security   Security    `json:"security"`
+	Kind       string      `json:"kind"`
+	Quantity   float64     `json:"quantity"`
+	Amount     money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	TradedOn   time.Time   `json:"traded_on" gorm:"index"`
+	IncomeID   uint        `json:"income_id,omitempty" gorm:"index"`
+	// where a buy or sell is in reconciling its account, a dividend's is its income's
+	Status string `json:"status" gorm:"default:pending"`
+}
+
+func (t Trade) Validate() error {
+	switch {
+	case t.Kind != TradeBuy && t.Kind != TradeSell && t.Kind != TradeDividend:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidTrade, t.Kind)
+	case t.Kind != TradeDividend && t.Quantity <= 0:
+		return fmt.Errorf("%w: quantity must be more than zero", ErrInvalidTrade)
+	case t.Amount.IsNegative() || (t.Kind == TradeDividend && t.Amount.IsZero()):
+		return fmt.Errorf("%w: amount must be more than zero", ErrInvalidTrade)
+	case t.Security.Currency != "" && t.Amount.Currency != t.Security.Currency:
+		return fmt.Errorf("%w: amount must be in %s", ErrInvalidTrade, t.Security.Currency)
+	}
+	return nil
+}
+
+// Lot is what is left of one buy, Cost being its share of what the buy cost
+type Lot struct {
+	AcquiredOn time.Time
+	Quantity   float64
+	Cost       money.Money
+}
+
+// Holding is a security held in one account on a day. Sells come out of the
+// oldest lots first, Realized is what they raised over those lots' cost.
+// Price is the latest on or before the day, Priced is false when there is
+// none and MarketValue and Unrealized can't be worked out.
+type Holding struct {
+	Account     Account
+	Security    Security
+	Quantity    float64
+	Lots        []Lot
+	CostBasis   money.Money
+	Realized    money.Money
+	Dividends   money.Money
+	Price       float64
+	PricedOn    time.Time
+	Priced      bool
+	MarketValue money.Money
+	Unrealized  money.Money
+}
+
+// PriceLookup finds a security's latest price on or before a day
+type PriceLookup func(securityID uint, on time.Time) (SecurityPrice, bool)
+
+// PriceHistory looks prices up from prices, which must be in date order
+func PriceHistory(prices []SecurityPrice) PriceLookup {
+	bySecurity := map[uint][]SecurityPrice{}
+	for _, p := range prices {
+		bySecurity[p.SecurityID] = append(bySecurity[p.SecurityID], p)
+	}
+	return func(securityID uint, on time.Time) (SecurityPrice, bool) {
+		history := bySecurity[securityID]
+		i := sort.Search(len(history), func(i int) bool { return history[i].Date.After(on) })
+		if i == 0 {
+			return SecurityPrice{}, false
+		}
+		return history[i-1], true
+	}
+}
+
+// quantityEpsilon is how close to zero a quantity can be and still count as
+// none left, as fractional units don't add up exactly in floating point
+const quantityEpsilon = 1e-9
+
+// Holdings works out every account's holdings on the day on from trades,
+// which must be in the order they happened. A holding that has been sold off
+// is kept while it has realized gains or dividends to report.
+func Holdings(trades []Trade, price PriceLookup, on time.Time) []Holding {
+	type key struct{ account, security uint }
+	var order []key
+	holdings := map[key]*Holding{}
+
+	for _, t := range trades {
+		if t.TradedOn.After(on) {
+			continue
+		}
+		k := key{t.AccountID, t.SecurityID}
+		h, ok := holdings[k]
+		if !ok {
+			currency := t.Amount.Currency
+			h = &Holding{
+				Account:   t.Account,
+				Security:  t.Security,
+				CostBasis: money.New(0, currency),
+				Realized:  money.New(0, currency),
+				Dividends: money.New(0, currency),
+			}
+			holdings[k] = h
+			order = append(order, k)
+		}
+		h.add(t)
+	}
+
+	result := make([]Holding, 0, len(order))
+	for _, k := range order {
+		h := holdings[k]
+		if h.Quantity > quantityEpsilon {
+			h.value(price, on)
+		} else if h.Realized.IsZero() && h.Dividends.IsZero() {
+			continue
+		}
+		result = append(result, *h)
+	}
+	return result
+}
+
+// add applies a trade to the holding
+func (h *Holding) add(t Trade) {
+	switch t.Kind {
+	case TradeBuy:
+		h.Lots = append(h.Lots, Lot{AcquiredOn: t.TradedOn, Quantity: t.Quantity, Cost: t.Amount})
+		h.Quantity += t.Quantity
+		h.CostBasis = money.New(h.CostBasis.Minor+t.Amount.Minor, h.CostBasis.Currency)
+
+	case TradeSell:
+		remaining := t.Quantity
+		var cost int64
+		for remaining > quantityEpsilon && len(h.Lots) > 0 {
+			lot := &h.Lots[0]
+			if lot.Quantity <= remaining+quantityEpsilon {
+				remaining -= lot.Quantity
+				cost += lot.Cost.Minor
+				h.Lots = h.Lots[1:]
+				continue
+			}
+			// part of the lot is sold, its cost goes with it in proportion
+			sold := int64(math.Round(float64(lot.Cost.Minor) * remaining / lot.Quantity))
+			lot.Quantity -= remaining
+			lot.Cost = money.New(lot.Cost.Minor-sold, lot.Cost.Currency)
+			cost += sold
+			remaining = 0
+		}
+		h.Quantity = max(h.Quantity-t.Quantity, 0)
+		h.CostBasis = money.New(h.CostBasis.Minor-cost, h.CostBasis.Currency)
+		h.Realized = money.New(h.Realized.Minor+t.Amount.Minor-cost, h.Realized.Currency)
+
+	case TradeDividend:
+		h.Dividends = money.New(h.Dividends.Minor+t.Amount.Minor, h.Dividends.Currency)
+	}
+}
+
+// value prices the holding on the day on
+func (h *Holding) value(price PriceLookup, on time.Time) {
+	p, ok := price(h.Security.ID, on)
+	if !ok {
+		return
+	}
+	currency := h.CostBasis.Currency
+	scale := math.Pow10(money.Exponent(currency))
+	h.Price, h.PricedOn, h.Priced = p.Price, p.Date, true
+	h.MarketValue = money.New(int64(math.Round(h.Quantity*p.Price*scale)), currency)
+	h.Unrealized = money.New(h.MarketValue.Minor-h.CostBasis.Minor, currency)
+}
+
+// Converter changes an amount into another currency at the rate on a day,
+// rates.Converter's Convert is one
+type Converter func(m money.Money, to string, on time.Time) (money.Money, error)
+
+// Portfolio adds up holdings in one currency. Incomplete is set when a
+// holding had no price or couldn't be converted and was left out of the
+// market value.
+type Portfolio struct {
+	MarketValue money.Money
+	CostBasis   money.Money
+	Unrealized  money.Money
+	Realized    money.Money
+	Dividends   money.Money
+	Incomplete  bool
+}
+
+// ValuePortfolio values holdings in currency at the rates on the day on
+func ValuePortfolio(holdings []Holding, convert Converter, currency string, on time.Time) Portfolio {
+	zero := money.New(0, currency)
+	p := Portfolio{zero, zero, zero, zero, zero, false}
+
+	add := func(total *money.Money, m money.Money) bool {
+		converted, err := convert(m, currency, on)
+		if err != nil {
+			p.Incomplete = true
+			return false
+		}
+		*total, _ = total.Add(converted)
+		return true
+	}
+	for _, h := range holdings {
+		add(&p.Realized, h.Realized)
+		add(&p.Dividends, h.Dividends)
+		if h.Quantity == 0 {
+			continue
+		}
+		if !h.Priced {
+			p.Incomplete = true
+			continue
+		}
+		if add(&p.MarketValue, h.MarketValue) {
+			add(&p.CostBasis, h.CostBasis)
+		}
+	}
+	p.Unrealized, _ = p.MarketValue.Sub(p.CostBasis)
+	return p
+}
+
+var ErrInvalidAsset = errors.New("invalid asset")
+
+const (
+	AssetKindAsset     = "asset"
+	AssetKindLiability = "liability"
+)
+
+// Asset is something owned or owed outside the accounts, such as a house, a
+// car or a debt that isn't tracked as a Loan. Its Value is entered by hand and
+// kept up to date by revaluing it, ValuedOn is when that was last done.
+type Asset struct {
+	gorm.Model
+	LedgerID uint        `json:"ledger_id" gorm:"index"`
+	Name     string      `json:"name"`
+	Kind     string      `json:"kind"`
+	Value    money.Money `json:"value" gorm:"embedded;embeddedPrefix:value_"`
+	ValuedOn time.Time   `json:"valued_on"`
+}
+
+func (a Asset) Validate() error {
+	switch {
+	case a.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidAsset)
+	case a.Kind != AssetKindAsset && a.Kind != AssetKindLiability:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidAsset, a.Kind)
+	case a.Value.IsNegative():
+		return fmt.Errorf("%w: value can't be negative", ErrInvalidAsset)
+	}
+	return nil
+}
+
+// NetWorth is what a ledger is worth on a day in one currency. Accounts is
+// the balance of the ledger's accounts bar loan accounts, Investments the
+// market value of its holdings and Assets its manual assets. Liabilities is
+// what its loan accounts owe and its manual liabilities, and Total the rest
+// less Liabilities. Incomplete is set when something had no price or exchange
+// rate and was left out.
+type NetWorth struct {
+	Date        time.Time   `json:"date" gorm:"uniqueIndex:idx_net_worth_day"`
+	Accounts    money.Money `json:"accounts" gorm:"embedded;embeddedPrefix:accounts_"`
+	Investments money.Money `json:"investments" gorm:"embedded;embeddedPrefix:investments_"`
+	Assets      money.Money `json:"assets" gorm:"embedded;embeddedPrefix:assets_"`
+	Liabilities money.Money `json:"liabilities" gorm:"embedded;embeddedPrefix:liabilities_"`
+	Total       money.Money `json:"total" gorm:"embedded;embeddedPrefix:total_"`
+	Incomplete  bool        `json:"incomplete"`
+}
+
+// NetWorthSnapshot is a ledger's net worth as it was recorded on a day, a
+// background job keeps one a day so it can be charted over time
+type NetWorthSnapshot struct {
+	gorm.Model
+	LedgerID uint `json:"ledger_id" gorm:"uniqueIndex:idx_net_worth_day"`
+	NetWorth `gorm:"embedded"`
+}
+
+// what a posting is made against
+const (
+	PostingAccount  = "account"  // money held in one of the Accounts
+	PostingCategory = "category" // money earned or spent, by income or expense category
+	PostingEquity   = "equity"   // opening balances and the two sides of a currency exchange
+	// securities bought and sold through an investment account, at what was paid or received
+	PostingInvestment = "investment"
+)
+
+// the records journal entries are posted for
+const (
+	JournalExpense  = "expense"
+	JournalIncome   = "income"
+	JournalTransfer = "transfer"
+	JournalTrade    = "trade"   // buying or selling a Security, dividends are incomes
+	JournalOpening  = "opening" // an Account's opening balance
+)
+
+var ErrUnbalanced = errors.New("journal entry doesn't balance")
+
+// JournalEntry is the double-entry record of an income, expense, transfer,
+// trade or opening balance, and is what account balances are worked out from. Entries
+// are kept in step with the record they were posted for, Source and SourceID,
+// and their postings always add up to zero in each currency.
+type JournalEntry struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"created_at"`
+	LedgerID    uint      `json:"ledger_id" gorm:"index"`
+	Source      string    `json:"source" gorm:"uniqueIndex:idx_journal_source"`
+	SourceID    uint      `json:"source_id" gorm:"uniqueIndex:idx_journal_source"`
+	OccurredOn  time.Time `json:"occurred_on" gorm:"index"`
+	Description string    `json:"description"`
+	Postings    []Posting `json:"postings"`
+}
+
+// Posting is one side of a journal entry. Debits are positive and credits
+// negative, so money into an account or spent in a category is positive.
+type Posting struct {
+	ID             uint        `json:"id" gorm:"primarykey"`
+	JournalEntryID uint        `json:"journal_entry_id" gorm:"index"`
+	Type           string      `json:"type"` // PostingAccount, PostingCategory, PostingEquity or PostingInvestment
+	AccountID      uint        `json:"account_id" gorm:"index"`
+	CategoryID     uint        `json:"category_id" gorm:"index"`
+	Amount         money.Money `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+}
+
+// Validate checks the entry has at least two postings and that they add up to
+// zero in every currency.
+func (e JournalEntry) Validate() error {
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("%w: needs at least two postings", ErrUnbalanced)
+	}
+
+	totals := map[string]int64{}
+	for _, p := range e.Postings {
+		totals[p.Amount.Currency] += p.Amount.Minor
+	}
+	for currency, total := range totals {
+		if total != 0 {
+			return fmt.Errorf("%w: %s is off by %s", ErrUnbalanced, currency, money.New(total, currency))
+		}
+	}
+	return nil
+}
+
+const (
+	RuleMatchContains = "contains" // the source contains the pattern, ignoring case
+	RuleMatchRegex    = "regex"    // the source matches the pattern as a Go regular expression
+)
+
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule tidies up new incomes or expenses of its Kind, such as renaming
+// "AMZN MKTP UK*2K3L" to "Amazon" and filing it under Shopping. Every
+// condition that is set must hold, unset ones are ignored. The ledger's rules
+// run in Priority order, lowest first, and each sees the changes of the ones
+// before it.
+type Rule struct {
+	gorm.Model
+	LedgerID uint   `json:"ledger_id" gorm:"index"`
+	Kind     string `json:"kind"` // CategoryKindExpense or CategoryKindIncome
+	Priority int    `json:"priority"`
+
+	// conditions, an amount bound without a currency isn't set and only
+	// transactions in the bound's currency match one that is
+	Match     string      `json:"match"` // RuleMatchContains or RuleMatchRegex
+	Pattern   string      `json:"pattern"`
+	MinAmount money.Money `json:"min_amount" gorm:"embedded;embeddedPrefix:min_amount_"`
+	MaxAmount money.Money `json:"max_amount" gorm:"embedded;embeddedPrefix:max_amount_"`
+	AccountID uint        `json:"account_id" gorm:"index"`
+	Account   Account     `json:"account"`
+
+	// actions, the category is only set on transactions that are still uncategorised
+	RenameTo   string   `json:"rename_to"`
+	CategoryID uint     `json:"category_id" gorm:"index"`
+	Category   Category `json:"category"`
+	Tag        string   `json:"tag"`
+}
+
+// Validate checks the rule has a condition and an action, and that its pattern
+// and amount range make sense.
+func (r Rule) Validate() error {
+	if r.Kind != CategoryKindExpense && r.Kind != CategoryKindIncome {
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidRule, r.Kind)
+	}
+
+	switch r.Match {
+	case "":
+		if r.Pattern != "" {
+			return fmt.Errorf("%w: pattern without a match type", ErrInvalidRule)
+		}
+	case RuleMatchContains:
+		if r.Pattern == "" {
+			return fmt.Errorf("%w: pattern is required", ErrInvalidRule)
+		}
+	case RuleMatchRegex:
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown match type %q", ErrInvalidRule, r.Match)
+	}
+
+	if r.MinAmount.Currency != "" && r.MaxAmount.Currency != "" {
+		cmp, err := r.MinAmount.Cmp(r.MaxAmount)
+		if err != nil {
+			return fmt.Errorf("%w: amount range is in two currencies", ErrInvalidRule)
+		}
+		if cmp > 0 {
+			return fmt.Errorf("%w: minimum amount is above the maximum", ErrInvalidRule)
+		}
+	}
+
+	if r.Match == "" && r.MinAmount.Currency == "" && r.MaxAmount.Currency == "" && r.AccountID == 0 {
+		return fmt.Errorf("%w: needs at least one condition", ErrInvalidRule)
+	}
+	if r.RenameTo == "" && r.CategoryID == 0 && r.Tag == "" {
+		return fmt.Errorf("%w: needs at least one action", ErrInvalidRule)
+	}
+	return nil
+}
+
+// Matches reports whether a transaction with source, amount and account meets
+// every condition of the rule. An invalid pattern matches nothing.
+func (r Rule) Matches(source string, amount money.Money, accountID uint) bool {
+	switch r.Match {
+	case RuleMatchContains:
+		if !strings.Contains(strings.ToLower(source), strings.ToLower(r.Pattern)) {
+			return false
+		}
+	case RuleMatchRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil || !re.MatchString(source) {
+			return false
+		}
+	}
+
+	if r.MinAmount.Currency != "" {
+		cmp, err := amount.Cmp(r.MinAmount)
+		if err != nil || cmp < 0 {
+			return false
+		}
+	}
+	if r.MaxAmount.Currency != "" {
+		cmp, err := amount.Cmp(r.MaxAmount)
+		if err != nil || cmp > 0 {
+			return false
+		}
+	}
+	return r.AccountID == 0 || r.AccountID == accountID
+}
+
+// RuleOutcome is what a ledger's rules make of a transaction. CategoryID is 0
+// when no rule sets one, Tags are the ones to add in the order they were given.
+type RuleOutcome struct {
+	Source     string
+	CategoryID uint
+	Tags       []string
+}
+
+// ApplyRules runs the rules for kind over a transaction in order, see Rule.
+func ApplyRules(rules []Rule, kind, source string, amount money.Money, accountID uint) RuleOutcome {
+	out := RuleOutcome{Source: source}
+	for _, rule := range rules {
+		if rule.Kind != kind || !rule.Matches(out.Source, amount, accountID) {
+			continue
+		}
+		if rule.RenameTo != "" {
+			out.Source = rule.RenameTo
+		}
+		if rule.CategoryID != 0 {
+			out.CategoryID = rule.CategoryID
+		}
+		if rule.Tag != "" && !slices.Contains(out.Tags, rule.Tag) {
+			out.Tags = append(out.Tags, rule.Tag)
+		}
+	}
+	return out
+}
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// the kinds of record the audit log covers
+const (
+	AuditExpense  = "expense"
+	AuditIncome   = "income"
+	AuditTransfer = "transfer"
+	AuditUser     = "user"
+)
+
+// AuditEntry records one change to an income, expense, transfer or user. Entries are
+// only ever added, the table refuses updates and deletes.
+type AuditEntry struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	// who made the change, ActorID is 0 for the app's own jobs or when only
+	// the username was known
+	ActorID   uint   `json:"actor_id"`
+	Actor     string `json:"actor" gorm:"index"`
+	RequestID string `json:"request_id" gorm:"index"`
+	Action    string `json:"action"`
+	Entity    string `json:"entity" gorm:"index:idx_audit_entity"`
+	EntityID  uint   `json:"entity_id" gorm:"index:idx_audit_entity"`
+	// JSON of the record either side of the change, a create has no Before
+	// and a delete no After
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// SearchResult is an income or expense matching a search. Snippet is the
+// best matching part of its source, notes or tags, see database.Search.
+type SearchResult struct {
+	Kind       string
+	ID         uint
+	Source     string
+	Amount     money.Money
+	OccurredOn time.Time
+	Category   string
+	Snippet    string
 }
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAmortize(t *testing.T) {
+	tests := []struct {
+		name string
+		loan Loan
+		// payment, interest, principal and balance of each instalment
+		want [][4]int64
+	}{
+		{
+			name: "no interest, the last payment is short",
+			loan: Loan{Principal: money.New(100000, "GBP"), Term: 3, Frequency: FrequencyMonthly, Interval: 1},
+			want: [][4]int64{{33334, 0, 33334, 66666}, {33334, 0, 33334, 33332}, {33332, 0, 33332, 0}},
+		},
+		{
+			name: "the last payment takes up the rounding",
+			loan: Loan{Principal: money.New(120000, "GBP"), AnnualRate: 12, Term: 12, Frequency: FrequencyMonthly, Interval: 1},
+			want: [][4]int64{
+				{10662, 1200, 9462, 110538}, {10662, 1105, 9557, 100981}, {10662, 1010, 9652, 91329},
+				{10662, 913, 9749, 81580}, {10662, 816, 9846, 71734}, {10662, 717, 9945, 61789},
+				{10662, 618, 10044, 51745}, {10662, 517, 10145, 41600}, {10662, 416, 10246, 31354},
+				{10662, 314, 10348, 21006}, {10662, 210, 10452, 10554}, {10660, 106, 10554, 0},
+			},
+		},
+		{
+			name: "yen have no minor units",
+			loan: Loan{Principal: money.New(100000, "JPY"), AnnualRate: 5, Term: 3, Frequency: FrequencyYearly, Interval: 1},
+			want: [][4]int64{{36721, 5000, 31721, 68279}, {36721, 3414, 33307, 34972}, {36721, 1749, 34972, 0}},
+		},
+		{
+			name: "weekly interest rounds to nothing",
+			loan: Loan{Principal: money.New(1000, "USD"), AnnualRate: 10, Term: 5, Frequency: FrequencyWeekly, Interval: 1},
+			want: [][4]int64{{201, 2, 199, 801}, {201, 2, 199, 602}, {201, 1, 200, 402}, {201, 1, 200, 202}, {202, 0, 202, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := tt.loan.Amortize()
+			if len(schedule) != len(tt.want) {
+				t.Fatalf("Amortize made %d instalments, want %d", len(schedule), len(tt.want))
+			}
+			var principal int64
+			for i, instalment := range schedule {
+				got := [4]int64{instalment.Payment.Minor, instalment.Interest.Minor, instalment.Principal.Minor, instalment.Balance.Minor}
+				if got != tt.want[i] {
+					t.Errorf("instalment %d = %v, want %v", instalment.Number, got, tt.want[i])
+				}
+				if instalment.Payment.Currency != tt.loan.Principal.Currency {
+					t.Errorf("instalment %d is in %s, want %s", instalment.Number, instalment.Payment.Currency, tt.loan.Principal.Currency)
+				}
+				principal += instalment.Principal.Minor
+			}
+			if principal != tt.loan.Principal.Minor {
+				t.Errorf("principal paid = %d, want %d", principal, tt.loan.Principal.Minor)
+			}
+		})
+	}
+}
+
+func TestLoanDueOn(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency string
+		interval  int
+		first     string
+		n         int
+		want      string
+	}{
+		{"first", FrequencyMonthly, 1, "2024-01-31", 1, "2024-01-31"},
+		{"end of a leap February", FrequencyMonthly, 1, "2024-01-31", 2, "2024-02-29"},
+		{"back to the 31st", FrequencyMonthly, 1, "2024-01-31", 3, "2024-03-31"},
+		{"end of April", FrequencyMonthly, 1, "2024-01-31", 4, "2024-04-30"},
+		{"quarterly into February", FrequencyMonthly, 3, "2023-11-30", 2, "2024-02-29"},
+		{"fortnightly", FrequencyWeekly, 2, "2024-01-01", 3, "2024-01-29"},
+		{"yearly from a leap day", FrequencyYearly, 1, "2024-02-29", 2, "2025-02-28"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loan := Loan{Frequency: tt.frequency, Interval: tt.interval, FirstPaymentOn: date(tt.first)}
+			if got := loan.DueOn(tt.n); !got.Equal(date(tt.want)) {
+				t.Errorf("DueOn(%d) = %s, want %s", tt.n, got.Format(time.DateOnly), tt.want)
+			}
+		})
+	}
+}
+
+func TestLoanProgress(t *testing.T) {
+	loan := Loan{
+		Principal:      money.New(100000, "GBP"),
+		Term:           3,
+		Frequency:      FrequencyMonthly,
+		Interval:       1,
+		FirstPaymentOn: date("2024-01-15"),
+		Payments: []LoanPayment{
+			{Number: 1, Principal: money.New(33334, "GBP"), Interest: money.New(0, "GBP")},
+			// the same instalment paid again only counts once
+			{Number: 1, Principal: money.New(33334, "GBP"), Interest: money.New(0, "GBP")},
+		},
+	}
+
+	p := loan.Progress(date("2024-03-20"))
+	if p.Outstanding != money.New(66666, "GBP") {
+		t.Errorf("Outstanding = %v, want 666.66", p.Outstanding)
+	}
+	if p.Next == nil || p.Next.Number != 2 {
+		t.Fatalf("Next = %v, want instalment 2", p.Next)
+	}
+	if p.Overdue != 2 {
+		t.Errorf("Overdue = %d, want 2", p.Overdue)
+	}
+	if !p.PayoffOn.Equal(date("2024-03-15")) {
+		t.Errorf("PayoffOn = %s, want 2024-03-15", p.PayoffOn.Format(time.DateOnly))
+	}
+
+	for _, n := range []int{2, 3} {
+		instalment := p.Schedule[n-1]
+		loan.Payments = append(loan.Payments, LoanPayment{Number: n, Principal: instalment.Principal, Interest: instalment.Interest})
+	}
+	p = loan.Progress(date("2024-03-20"))
+	if !p.Outstanding.IsZero() || p.Next != nil || p.Overdue != 0 {
+		t.Errorf("paid off loan has Outstanding %v, Next %v, Overdue %d", p.Outstanding, p.Next, p.Overdue)
+	}
+}
+
+func TestHoldings(t *testing.T) {
+	account := Account{Model: gorm.Model{ID: 1}}
+	shares := Security{Model: gorm.Model{ID: 1}, Symbol: "SHR", Currency: "GBP"}
+	fund := Security{Model: gorm.Model{ID: 2}, Symbol: "FND", Currency: "GBP"}
+	flat := Security{Model: gorm.Model{ID: 3}, Symbol: "FLT", Currency: "GBP"}
+	thirds := Security{Model: gorm.Model{ID: 4}, Symbol: "THD", Currency: "GBP"}
+	trade := func(security Security, kind string, quantity float64, amount int64, on string) Trade {
+		return Trade{
+			AccountID: account.ID, Account: account, SecurityID: security.ID, Security: security,
+			Kind: kind, Quantity: quantity, Amount: money.New(amount, "GBP"), TradedOn: date(on),
+		}
+	}
+	trades := []Trade{
+		trade(shares, TradeBuy, 10, 100000, "2024-01-01"),
+		trade(shares, TradeBuy, 10, 150000, "2024-02-01"),
+		// all of the first lot and half of the second
+		trade(shares, TradeSell, 15, 200000, "2024-03-01"),
+		trade(shares, TradeDividend, 0, 3000, "2024-03-15"),
+		trade(fund, TradeBuy, 1, 50000, "2024-01-01"),
+		trade(fund, TradeSell, 1, 45000, "2024-02-01"),
+		trade(flat, TradeBuy, 2, 1000, "2024-01-01"),
+		trade(flat, TradeSell, 2, 1000, "2024-02-01"),
+		trade(thirds, TradeBuy, 3, 1000, "2024-01-01"),
+		trade(thirds, TradeSell, 1, 400, "2024-02-01"),
+	}
+	price := PriceHistory([]SecurityPrice{
+		{SecurityID: shares.ID, Date: date("2024-03-10"), Price: 160},
+		{SecurityID: shares.ID, Date: date("2024-04-10"), Price: 999},
+	})
+
+	holdings := Holdings(trades, price, date("2024-03-31"))
+	if len(holdings) != 3 {
+		t.Fatalf("Holdings = %d holdings, want 3 as FLT is sold off without a gain", len(holdings))
+	}
+
+	h := holdings[0]
+	if h.Security.Symbol != "SHR" || h.Quantity != 5 {
+		t.Fatalf("first holding = %s x %v, want SHR x 5", h.Security.Symbol, h.Quantity)
+	}
+	if len(h.Lots) != 1 || h.Lots[0].Quantity != 5 || !h.Lots[0].AcquiredOn.Equal(date("2024-02-01")) {
+		t.Errorf("SHR lots = %+v, want half of the second buy", h.Lots)
+	}
+	want := map[string][2]money.Money{
+		"CostBasis":   {h.CostBasis, money.New(75000, "GBP")},
+		"Realized":    {h.Realized, money.New(25000, "GBP")},
+		"Dividends":   {h.Dividends, money.New(3000, "GBP")},
+		"MarketValue": {h.MarketValue, money.New(80000, "GBP")},
+		"Unrealized":  {h.Unrealized, money.New(5000, "GBP")},
+	}
+	for name, got := range want {
+		if got[0] != got[1] {
+			t.Errorf("SHR %s = %v, want %v", name, got[0], got[1])
+		}
+	}
+	if !h.Priced || !h.PricedOn.Equal(date("2024-03-10")) {
+		t.Errorf("SHR priced %v on %s, want the 2024-03-10 price", h.Priced, h.PricedOn.Format(time.DateOnly))
+	}
+
+	if h := holdings[1]; h.Security.Symbol != "FND" || h.Quantity != 0 || h.Realized != money.New(-5000, "GBP") {
+		t.Errorf("sold off holding = %s x %v realized %v, want FND x 0 realized -50.00", h.Security.Symbol, h.Quantity, h.Realized)
+	}
+	// a third of the lot's cost goes with the unit sold
+	if h := holdings[2]; h.CostBasis != money.New(667, "GBP") || h.Realized != money.New(67, "GBP") || h.Priced {
+		t.Errorf("THD cost %v realized %v priced %v, want 6.67, 0.67 and no price", h.CostBasis, h.Realized, h.Priced)
+	}
+
+	// trades after the day don't count yet
+	early := Holdings(trades, price, date("2024-01-15"))
+	if len(early) != 4 || early[0].Quantity != 10 || early[0].Priced {
+		t.Errorf("Holdings on 2024-01-15 = %+v, want 10 SHR with no price yet", early)
+	}
+}
+
+func TestValuePortfolio(t *testing.T) {
+	convert := func(m money.Money, to string, on time.Time) (money.Money, error) {
+		if m.Currency != to {
+			return money.Money{}, errors.New("no rate")
+		}
+		return m, nil
+	}
+	holdings := []Holding{
+		{Quantity: 5, Priced: true, MarketValue: money.New(80000, "GBP"), CostBasis: money.New(75000, "GBP"), Realized: money.New(25000, "GBP"), Dividends: money.New(3000, "GBP")},
+		{Quantity: 1, CostBasis: money.New(1000, "GBP"), Realized: money.New(0, "GBP"), Dividends: money.New(0, "GBP")},
+		{Quantity: 2, Priced: true, MarketValue: money.New(500, "USD"), CostBasis: money.New(400, "USD"), Realized: money.New(0, "USD"), Dividends: money.New(0, "USD")},
+	}
+
+	p := ValuePortfolio(holdings, convert, "GBP", date("2024-03-31"))
+	if p.MarketValue != money.New(80000, "GBP") || p.CostBasis != money.New(75000, "GBP") || p.Unrealized != money.New(5000, "GBP") {
+		t.Errorf("ValuePortfolio = %+v, want only the priced GBP holding valued", p)
+	}
+	if p.Realized != money.New(25000, "GBP") || p.Dividends != money.New(3000, "GBP") {
+		t.Errorf("ValuePortfolio realized %v dividends %v, want 250.00 and 30.00", p.Realized, p.Dividends)
+	}
+	if !p.Incomplete {
+		t.Error("ValuePortfolio should be incomplete with an unpriced and an unconverted holding")
+	}
+}
+
+func TestBillValidate(t *testing.T) {
+	valid := Bill{Payee: "Water", Amount: money.New(3000, "GBP"), Frequency: FrequencyMonthly, Interval: 1}
+	tests := []struct {
+		name string
+		edit func(b *Bill)
+		ok   bool
+	}{
+		{"valid", func(b *Bill) {}, true},
+		{"custom with a rule", func(b *Bill) { b.Frequency, b.RRule = FrequencyCustom, "FREQ=MONTHLY;BYDAY=-1FR" }, true},
+		{"no payee", func(b *Bill) { b.Payee = "" }, false},
+		{"no amount", func(b *Bill) { b.Amount = money.New(0, "GBP") }, false},
+		{"unknown frequency", func(b *Bill) { b.Frequency = "fortnightly" }, false},
+		{"no frequency", func(b *Bill) { b.Frequency = "" }, false},
+		{"custom without a rule", func(b *Bill) { b.Frequency, b.RRule = FrequencyCustom, " " }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bill := valid
+			tt.edit(&bill)
+			err := bill.Validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("Validate() = %v, want ok %v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidBill) {
+				t.Fatalf("Validate() = %v, want ErrInvalidBill", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAmount    = errors.New("invalid amount")
+	ErrInvalidCurrency  = errors.New("invalid currency code")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// Money is an exact amount held as integer minor units (pence, cents) plus an
+// ISO 4217 currency code. It is embedded into models with a column prefix,
+// e.g. `gorm:"embedded;embeddedPrefix:amount_"`.
+type Money struct {
+	Minor    int64  `json:"minor"`
+	Currency string `json:"currency" gorm:"size:3"`
+}
+
+// currencies with a non-standard number of minor digits, everything else uses 2
+var exponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"ISK": 0,
+	"BHD": 3,
+	"KWD": 3,
+	"OMR": 3,
+	"TND": 3,
+}
+
+var symbols = map[string]string{
+	"GBP": "£",
+	"EUR": "€",
+	"USD": "$",
+	"JPY": "¥",
+	"INR": "₹",
+}
+
+// Symbol returns the display symbol for a currency, or the code itself with a
+// trailing space when there isn't a well known one.
+func Symbol(currency string) string {
+	if sym, ok := symbols[currency]; ok {
+		return sym
+	}
+	return currency + " "
+}
+
+// Exponent returns the number of minor digits used by a currency.
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// New returns an amount of minor units in the given currency.
+func New(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: strings.ToUpper(currency)}
+}
+
+// Parse reads a decimal string such as "12", "-3.5" or "1,234.56" into minor
+// units. It rejects anything with more decimal places than the currency allows.
+func Parse(s, currency string) (Money, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !ValidCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidCurrency, currency)
+	}
+
+	raw := s
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, frac, hasPoint := strings.Cut(s, ".")
+	whole, ok := ungroup(whole)
+	if !ok {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	if whole == "" && frac == "" {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	if hasPoint && frac == "" {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	if !isDigits(whole) || !isDigits(frac) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+
+	exp := Exponent(currency)
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, raw, exp)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, raw)
+	}
+	if neg {
+		minor = -minor
+	}
+
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// MustParse is like Parse but panics on error, for constants and seed data.
+func MustParse(s, currency string) Money {
+	m, err := Parse(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// ValidCurrency reports whether code looks like an ISO 4217 code.
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ungroup removes thousands separators from the whole part of an amount,
+// e.g. "1,234,567". Commas anywhere else, such as "1,2,3", are rejected.
+func ungroup(whole string) (string, bool) {
+	if !strings.Contains(whole, ",") {
+		return whole, true
+	}
+	groups := strings.Split(whole, ",")
+	if len(groups[0]) < 1 || len(groups[0]) > 3 {
+		return "", false
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return "", false
+		}
+	}
+	return strings.Join(groups, ""), true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimal formats the amount without a currency, e.g. "-1234.50".
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	digits := strconv.FormatInt(minor, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+// String formats the amount with its currency code, e.g. "12.50 GBP".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Format formats the amount for display, e.g. "£12.50" or "-CHF 3.00".
+func (m Money) Format() string {
+	if m.Minor < 0 {
+		return "-" + Symbol(m.Currency) + m.Abs().Decimal()
+	}
+	return Symbol(m.Currency) + m.Decimal()
+}
+
+// Float64 is for charting only, never do arithmetic with the result.
+func (m Money) Float64() float64 {
+	f, _ := strconv.ParseFloat(m.Decimal(), 64)
+	return f
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.Currency}
+}
+
+func (m Money) Abs() Money {
+	if m.Minor < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// Add returns m+o. Both amounts must share a currency, a zero value with no
+// currency set takes on the currency of the other side.
+func (m Money) Add(o Money) (Money, error) {
+	cur, err := commonCurrency(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: cur}, nil
+}
+
+// Sub returns m-o, with the same currency rules as Add.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+// Mul multiplies the amount by a whole number.
+func (m Money) Mul(n int64) Money {
+	return Money{Minor: m.Minor * n, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or 1 depending on whether m is less than, equal to or
+// greater than o.
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := commonCurrency(m, o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Minor < o.Minor:
+		return -1, nil
+	case m.Minor > o.Minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Sum adds up amounts that all share one currency.
+func Sum(currency string, amounts ...Money) (Money, error) {
+	total := New(0, currency)
+	for _, a := range amounts {
+		var err error
+		total, err = total.Add(a)
+		if err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+func commonCurrency(a, b Money) (string, error) {
+	switch {
+	case a.Currency == b.Currency:
+		return a.Currency, nil
+	case a.Currency == "" && a.Minor == 0:
+		return b.Currency, nil
+	case b.Currency == "" && b.Minor == 0:
+		return a.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.Currency, b.Currency)
+}
+
+// Convert changes m into currency to using rate, the price of one unit of m's
+// currency in to. The result is rounded half away from zero to whole minor units.
+func Convert(m Money, to string, rate float64) (Money, error) {
+	to = strings.ToUpper(to)
+	if m.Currency == to {
+		return m, nil
+	}
+	if !ValidCurrency(to) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidCurrency, to)
+	}
+
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(rate, 'g', -1, 64))
+	if !ok || r.Sign() <= 0 {
+		return Money{}, fmt.Errorf("invalid exchange rate %v", rate)
+	}
+
+	v := new(big.Rat).SetInt64(m.Minor)
+	v.Mul(v, r)
+	shift := Exponent(to) - Exponent(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		v.Mul(v, scale)
+	} else {
+		v.Quo(v, scale)
+	}
+
+	return Money{Minor: round(v), Currency: to}, nil
+}
+
+// round rounds half away from zero
+func round(v *big.Rat) int64 {
+	num, den := new(big.Int).Set(v.Num()), v.Denom()
+	neg := num.Sign() < 0
+	num.Abs(num)
+
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
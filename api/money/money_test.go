@@ -0,0 +1,142 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		currency string
+		want     Money
+		err      error
+	}{
+		{"whole", "12", "GBP", New(1200, "GBP"), nil},
+		{"one decimal", "-3.5", "GBP", New(-350, "GBP"), nil},
+		{"two decimals", "0.07", "gbp", New(7, "GBP"), nil},
+		{"leading point", ".5", "USD", New(50, "USD"), nil},
+		{"plus sign", "+4.20", "EUR", New(420, "EUR"), nil},
+		{"thousands", "1,234.56", "GBP", New(123456, "GBP"), nil},
+		{"millions", "-1,234,567", "GBP", New(-123456700, "GBP"), nil},
+		{"yen", "1500", "JPY", New(1500, "JPY"), nil},
+		{"yen thousands", "1,500", "JPY", New(1500, "JPY"), nil},
+		{"three minor digits", "1.234", "KWD", New(1234, "KWD"), nil},
+		{"yen decimals", "1500.5", "JPY", Money{}, ErrInvalidAmount},
+		{"too many decimals", "1.234", "GBP", Money{}, ErrInvalidAmount},
+		{"stray commas", "1,2,3", "GBP", Money{}, ErrInvalidAmount},
+		{"short group", "12,34", "GBP", Money{}, ErrInvalidAmount},
+		{"long first group", "1234,567", "GBP", Money{}, ErrInvalidAmount},
+		{"leading comma", ",123", "GBP", Money{}, ErrInvalidAmount},
+		{"comma in decimals", "1.2,3", "GBP", Money{}, ErrInvalidAmount},
+		{"trailing point", "12.", "GBP", Money{}, ErrInvalidAmount},
+		{"empty", "", "GBP", Money{}, ErrInvalidAmount},
+		{"letters", "12a", "GBP", Money{}, ErrInvalidAmount},
+		{"symbol", "£12", "GBP", Money{}, ErrInvalidAmount},
+		{"bad currency", "12", "GB", Money{}, ErrInvalidCurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in, tt.currency)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Parse(%q, %q) error = %v, want %v", tt.in, tt.currency, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q, %q) = %v, want %v", tt.in, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in      Money
+		decimal string
+		format  string
+	}{
+		{New(1250, "GBP"), "12.50", "£12.50"},
+		{New(-300, "CHF"), "-3.00", "-CHF 3.00"},
+		{New(5, "USD"), "0.05", "$0.05"},
+		{New(-5, "EUR"), "-0.05", "-€0.05"},
+		{New(0, "GBP"), "0.00", "£0.00"},
+		{New(1500, "JPY"), "1500", "¥1500"},
+		{New(-7, "JPY"), "-7", "-¥7"},
+		{New(1234, "KWD"), "1.234", "KWD 1.234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in.String(), func(t *testing.T) {
+			if got := tt.in.Decimal(); got != tt.decimal {
+				t.Errorf("Decimal() = %q, want %q", got, tt.decimal)
+			}
+			if got := tt.in.Format(); got != tt.format {
+				t.Errorf("Format() = %q, want %q", got, tt.format)
+			}
+			// whatever is formatted has to parse back to the same amount
+			back, err := Parse(tt.in.Decimal(), tt.in.Currency)
+			if err != nil || back != tt.in {
+				t.Errorf("Parse(Decimal()) = %v, %v, want %v", back, err, tt.in)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Money
+		to   string
+		rate float64
+		want Money
+	}{
+		{"same currency", New(1234, "GBP"), "GBP", 2, New(1234, "GBP")},
+		{"exact", New(1000, "GBP"), "USD", 1.25, New(1250, "USD")},
+		{"rounds half up", New(1, "GBP"), "USD", 1.5, New(2, "USD")},
+		{"rounds down", New(1, "GBP"), "USD", 1.49, New(1, "USD")},
+		{"rounds half away from zero", New(-1, "GBP"), "USD", 1.5, New(-2, "USD")},
+		{"into yen", New(1050, "GBP"), "JPY", 190.5, New(2000, "JPY")},
+		{"into yen rounds", New(1, "GBP"), "JPY", 150, New(2, "JPY")},
+		{"out of yen", New(1000, "JPY"), "GBP", 0.00525, New(525, "GBP")},
+		{"out of yen rounds", New(1, "JPY"), "GBP", 0.005, New(1, "GBP")},
+		{"into three digits", New(100, "USD"), "KWD", 0.3075, New(308, "KWD")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(tt.in, tt.to, tt.rate)
+			if err != nil {
+				t.Fatalf("Convert(%v, %s, %v) error = %v", tt.in, tt.to, tt.rate, err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert(%v, %s, %v) = %v, want %v", tt.in, tt.to, tt.rate, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Convert(New(100, "GBP"), "USD", 0); err == nil {
+		t.Error("Convert with a zero rate should fail")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Money
+		want Money
+		err  error
+	}{
+		{"same currency", New(150, "GBP"), New(-50, "GBP"), New(100, "GBP"), nil},
+		{"zero value takes currency", Money{}, New(50, "EUR"), New(50, "EUR"), nil},
+		{"mismatch", New(1, "GBP"), New(1, "USD"), Money{}, ErrCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.a.Add(tt.b)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Add error = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("Add = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
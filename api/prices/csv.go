@@ -0,0 +1,90 @@
+package prices
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// LoadFile reads a prices CSV from disk.
+func LoadFile(path string) ([]models.PriceQuote, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseCSV(f)
+}
+
+// ParseCSV reads closing prices, one row per security per day. The header
+// names the columns, in any order, and anything else is ignored:
+//
+//	Date,Symbol,Close
+//	2024-02-02,VWRL,104.56
+//
+// The price column can be called Price or Close.
+func ParseCSV(r io.Reader) ([]models.PriceQuote, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	date, okDate := columns["date"]
+	symbol, okSymbol := columns["symbol"]
+	price, okPrice := columns["price"]
+	if !okPrice {
+		price, okPrice = columns["close"]
+	}
+	if !okDate || !okSymbol || !okPrice {
+		return nil, fmt.Errorf("prices csv needs Date, Symbol and Price or Close columns")
+	}
+
+	var quotes []models.PriceQuote
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) <= max(date, symbol, price) {
+			return nil, fmt.Errorf("line %d: missing columns", line)
+		}
+
+		// blank prices are days without a close, such as holidays
+		value := strings.TrimSpace(row[price])
+		if value == "" || strings.EqualFold(value, "N/A") {
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, strings.TrimSpace(row[date]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q: %w", line, row[date], err)
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 {
+			return nil, fmt.Errorf("line %d: invalid price %q", line, value)
+		}
+
+		quotes = append(quotes, models.PriceQuote{
+			Symbol: strings.ToUpper(strings.TrimSpace(row[symbol])),
+			Date:   day,
+			Price:  p,
+		})
+	}
+	return quotes, nil
+}
@@ -0,0 +1,114 @@
+package rates
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+	"github.com/Ewan-Greer09/finance-app/api/money"
+)
+
+var ErrNoRate = errors.New("no exchange rate")
+
+// RateStore is the part of database.Database the converter needs.
+type RateStore interface {
+	GetRate(base, quote string, on time.Time) (models.ExchangeRate, error)
+}
+
+// Converter converts amounts using the most recent stored rate on or before a
+// given day. Pairs without a direct rate are crossed through the euro, which
+// is what the ECB files are quoted against. Lookups are cached, so make a new
+// Converter per request rather than sharing one.
+type Converter struct {
+	store RateStore
+	cache map[string]float64
+}
+
+func NewConverter(store RateStore) *Converter {
+	return &Converter{
+		store: store,
+		cache: map[string]float64{},
+	}
+}
+
+// Convert returns m in currency to, at the rate for the day on.
+func (c *Converter) Convert(m money.Money, to string, on time.Time) (money.Money, error) {
+	if m.Currency == to {
+		return m, nil
+	}
+
+	rate, err := c.Rate(m.Currency, to, on)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.Convert(m, to, rate)
+}
+
+// Rate returns the price of one unit of from in to, on the day on.
+func (c *Converter) Rate(from, to string, on time.Time) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	on = on.UTC()
+	key := from + to + on.Format(time.DateOnly)
+	if rate, ok := c.cache[key]; ok {
+		return rate, nil
+	}
+
+	rate, err := c.lookup(from, to, on)
+	if err != nil {
+		return 0, err
+	}
+	if rate == 0 {
+		rate, err = c.cross(from, to, on)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	c.cache[key] = rate
+	return rate, nil
+}
+
+// lookup tries the pair in both directions, returning 0 when neither is stored
+func (c *Converter) lookup(from, to string, on time.Time) (float64, error) {
+	// rates are stored at midnight, so compare against the end of the day
+	day := on.Truncate(24 * time.Hour).Add(24*time.Hour - time.Nanosecond)
+
+	r, err := c.store.GetRate(from, to, day)
+	if err == nil {
+		return r.Rate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	r, err = c.store.GetRate(to, from, day)
+	if err == nil {
+		return 1 / r.Rate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (c *Converter) cross(from, to string, on time.Time) (float64, error) {
+	if from == ecbBase || to == ecbBase {
+		return 0, fmt.Errorf("%w for %s/%s on %s", ErrNoRate, from, to, on.Format(time.DateOnly))
+	}
+
+	fromEUR, err := c.Rate(ecbBase, from, on)
+	if err != nil {
+		return 0, err
+	}
+	toEUR, err := c.Rate(ecbBase, to, on)
+	if err != nil {
+		return 0, err
+	}
+	return toEUR / fromEUR, nil
+}
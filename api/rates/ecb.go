@@ -0,0 +1,153 @@
+package rates
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// ECB reference rates are quoted as the price of one euro in each currency
+const ecbBase = "EUR"
+
+// LoadFile reads an ECB-style rates file from disk.
+func LoadFile(path string) ([]models.ExchangeRate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(path, f)
+}
+
+// Parse reads an ECB-style rates file, picking the format from the extension
+// of name.
+func Parse(name string, r io.Reader) ([]models.ExchangeRate, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".xml":
+		return ParseXML(r)
+	case ".csv":
+		return ParseCSV(r)
+	}
+	return nil, fmt.Errorf("unsupported rates file %q, expected .xml or .csv", name)
+}
+
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ParseXML reads the eurofxref-daily/hist XML format:
+//
+//	<Cube><Cube time="2024-02-02"><Cube currency="USD" rate="1.0804"/></Cube></Cube>
+func ParseXML(r io.Reader) ([]models.ExchangeRate, error) {
+	var env ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&env); err != nil {
+		return nil, err
+	}
+
+	var rates []models.ExchangeRate
+	for _, day := range env.Days {
+		date, err := time.Parse(time.DateOnly, day.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate date %q: %w", day.Time, err)
+		}
+		for _, r := range day.Rates {
+			rate, ok, err := parseRate(r.Rate)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", day.Time, r.Currency, err)
+			}
+			if ok {
+				rates = append(rates, newRate(date, r.Currency, rate))
+			}
+		}
+	}
+	return rates, nil
+}
+
+// ParseCSV reads the eurofxref-hist CSV format, one row per day with a column
+// per currency:
+//
+//	Date,USD,JPY,
+//	2024-02-02,1.0804,159.33,
+func ParseCSV(r io.Reader) ([]models.ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 || !strings.EqualFold(strings.TrimSpace(header[0]), "date") {
+		return nil, fmt.Errorf("rates csv must start with a Date column")
+	}
+
+	var rates []models.ExchangeRate
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		date, err := time.Parse(time.DateOnly, strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate date %q: %w", row[0], err)
+		}
+		for i := 1; i < len(row) && i < len(header); i++ {
+			currency := strings.TrimSpace(header[i])
+			if currency == "" {
+				continue // the ECB files end every line with a trailing comma
+			}
+			rate, ok, err := parseRate(row[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", row[0], currency, err)
+			}
+			if ok {
+				rates = append(rates, newRate(date, currency, rate))
+			}
+		}
+	}
+	return rates, nil
+}
+
+// parseRate returns ok=false for the blanks and N/A the ECB uses for missing days
+func parseRate(s string) (float64, bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "N/A") {
+		return 0, false, nil
+	}
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if rate <= 0 {
+		return 0, false, fmt.Errorf("rate must be positive, got %v", rate)
+	}
+	return rate, true, nil
+}
+
+func newRate(date time.Time, currency string, rate float64) models.ExchangeRate {
+	return models.ExchangeRate{
+		Date:  date.UTC(),
+		Base:  ecbBase,
+		Quote: strings.ToUpper(strings.TrimSpace(currency)),
+		Rate:  rate,
+	}
+}
@@ -0,0 +1,84 @@
+package recurring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Ewan-Greer09/finance-app/api/models"
+)
+
+// Store is the part of database.Database that posting needs
+type Store interface {
+	GetDueRecurring(on time.Time) ([]models.Recurring, error)
+	PostRecurring(recurring models.Recurring, on time.Time, next *time.Time) error
+}
+
+// ScheduleFor builds the schedule of a recurring template, with its EndOn
+// applied as the last day.
+func ScheduleFor(recurring models.Recurring) (Schedule, error) {
+	var s Schedule
+	var err error
+	switch recurring.Frequency {
+	case models.FrequencyDaily, models.FrequencyWeekly, models.FrequencyMonthly, models.FrequencyYearly:
+		s, err = Every(recurring.Frequency, recurring.Interval)
+	case models.FrequencyCustom:
+		s, err = ParseRRule(recurring.RRule)
+	default:
+		err = fmt.Errorf("%w: unknown frequency %q", ErrInvalidRule, recurring.Frequency)
+	}
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	if recurring.EndOn != nil && (s.Until.IsZero() || recurring.EndOn.Before(s.Until)) {
+		s.Until = day(*recurring.EndOn)
+	}
+	return s, nil
+}
+
+// PostDue posts every occurrence due on or before today, including the ones
+// missed while the server was down. It returns how many were posted and keeps
+// going past a broken template so one bad rule can't block the rest.
+func PostDue(ctx context.Context, store Store, today time.Time) (int, error) {
+	due, err := store.GetDueRecurring(today)
+	if err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	var errs []error
+	for _, recurring := range due {
+		s, err := ScheduleFor(recurring)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recurring %d: %w", recurring.ID, err))
+			continue
+		}
+
+		on := *recurring.NextOn
+		for !on.After(today) {
+			if ctx.Err() != nil {
+				return posted, ctx.Err()
+			}
+
+			var next *time.Time
+			if t, ok := s.Next(recurring.StartOn, on); ok {
+				next = &t
+			}
+			err = store.PostRecurring(recurring, on, next)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("recurring %d on %s: %w", recurring.ID, on.Format(time.DateOnly), err))
+				break
+			}
+			posted++
+
+			if next == nil {
+				break
+			}
+			on = *next
+		}
+	}
+
+	return posted, errors.Join(errs...)
+}
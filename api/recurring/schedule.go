@@ -0,0 +1,418 @@
+package recurring
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// stops rules that can never match, such as the 30th of February, from looping forever
+const maxPeriods = 100000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+// Weekday is a BYDAY entry, N is the optional ordinal: 1 for the first Monday
+// of the month, -1 for the last, 0 for every one.
+type Weekday struct {
+	N   int
+	Day time.Weekday
+}
+
+// Schedule is the subset of an RFC 5545 RRULE we support. Occurrences are
+// whole days at midnight UTC, the same as transaction dates.
+//
+// Like RFC 5545, a monthly or yearly rule on a day some months don't have,
+// such as the 31st or the 29th of February, skips those months. Clamp moves
+// it back to the last day of the month instead, which is what the plain
+// frequencies from Every do: "monthly from the 31st" should still be paid in
+// April. A custom rule can ask for the same with BYMONTHDAY=-1.
+type Schedule struct {
+	Freq       string
+	Interval   int
+	ByDay      []Weekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+	Count      int
+	Until      time.Time
+	Clamp      bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Every returns a plain schedule such as every 2 weeks, clamped to the end of
+// shorter months.
+func Every(freq string, interval int) (Schedule, error) {
+	s := Schedule{Freq: strings.ToUpper(freq), Interval: interval, Clamp: true}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	return s, s.validate()
+}
+
+// ParseRRule reads a rule such as "FREQ=MONTHLY;BYDAY=-1FR" (last Friday of
+// every month). FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and BYMONTH are
+// supported, anything else is rejected rather than silently ignored.
+func ParseRRule(rule string) (Schedule, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	s := Schedule{Interval: 1}
+
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return Schedule{}, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			s.Freq = strings.ToUpper(value)
+		case "INTERVAL":
+			s.Interval, err = strconv.Atoi(value)
+		case "COUNT":
+			s.Count, err = strconv.Atoi(value)
+			if err == nil && s.Count < 1 {
+				err = errors.New("COUNT must be positive")
+			}
+		case "UNTIL":
+			s.Until, err = parseUntil(value)
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, err := parseWeekday(day)
+				if err != nil {
+					return Schedule{}, err
+				}
+				s.ByDay = append(s.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return Schedule{}, fmt.Errorf("%w: BYMONTHDAY %q", ErrInvalidRule, day)
+				}
+				s.ByMonthDay = append(s.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(month)
+				if err != nil || n < 1 || n > 12 {
+					return Schedule{}, fmt.Errorf("%w: BYMONTH %q", ErrInvalidRule, month)
+				}
+				s.ByMonth = append(s.ByMonth, time.Month(n))
+			}
+		default:
+			return Schedule{}, fmt.Errorf("%w: %s is not supported", ErrInvalidRule, key)
+		}
+		if err != nil {
+			return Schedule{}, fmt.Errorf("%w: %s: %v", ErrInvalidRule, key, err)
+		}
+	}
+
+	if s.Count > 0 && !s.Until.IsZero() {
+		return Schedule{}, fmt.Errorf("%w: COUNT and UNTIL can't both be set", ErrInvalidRule)
+	}
+	return s, s.validate()
+}
+
+func (s Schedule) validate() error {
+	switch s.Freq {
+	case Daily, Weekly, Monthly, Yearly:
+	case "":
+		return fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	default:
+		return fmt.Errorf("%w: unknown FREQ %q", ErrInvalidRule, s.Freq)
+	}
+	if s.Interval < 1 {
+		return fmt.Errorf("%w: INTERVAL must be positive", ErrInvalidRule)
+	}
+	for _, wd := range s.ByDay {
+		if wd.N != 0 && s.Freq != Monthly && s.Freq != Yearly {
+			return fmt.Errorf("%w: numbered BYDAY needs a MONTHLY or YEARLY rule", ErrInvalidRule)
+		}
+	}
+	return nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if len(value) > 8 {
+		t, err := time.Parse("20060102T150405Z", value)
+		return day(t), err
+	}
+	return time.Parse("20060102", value)
+}
+
+func parseWeekday(s string) (Weekday, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return Weekday{}, fmt.Errorf("%w: BYDAY %q", ErrInvalidRule, s)
+	}
+	day, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("%w: BYDAY %q", ErrInvalidRule, s)
+	}
+
+	n := 0
+	if prefix := s[:len(s)-2]; prefix != "" {
+		var err error
+		n, err = strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Weekday{}, fmt.Errorf("%w: BYDAY %q", ErrInvalidRule, s)
+		}
+	}
+	return Weekday{N: n, Day: day}, nil
+}
+
+// First returns the first occurrence on or after start.
+func (s Schedule) First(start time.Time) (time.Time, bool) {
+	return s.Next(start, day(start).AddDate(0, 0, -1))
+}
+
+// Next returns the first occurrence strictly after after, for a schedule that
+// began on start. ok is false once the schedule has finished.
+func (s Schedule) Next(start, after time.Time) (next time.Time, ok bool) {
+	s.each(day(start), day(after), func(t time.Time) bool {
+		if t.After(after) {
+			next, ok = t, true
+			return false
+		}
+		return true
+	})
+	return next, ok
+}
+
+// Between returns every occurrence from from to to inclusive.
+func (s Schedule) Between(start, from, to time.Time) []time.Time {
+	var days []time.Time
+	s.each(day(start), day(from), func(t time.Time) bool {
+		if t.After(to) {
+			return false
+		}
+		if !t.Before(from) {
+			days = append(days, t)
+		}
+		return true
+	})
+	return days
+}
+
+// each calls fn with every occurrence in order until it returns false,
+// beginning with the period that holds from so a long running schedule isn't
+// walked from its start every time. A COUNT can only be honoured by counting
+// from the start, so those are still walked in full, at most COUNT occurrences.
+func (s Schedule) each(start, from time.Time, fn func(time.Time) bool) {
+	k := 0
+	if s.Count == 0 {
+		k = s.periodOf(start, from)
+	}
+
+	n := 0
+	for end := k + maxPeriods; k < end; k++ {
+		for _, t := range s.period(start, k) {
+			if t.Before(start) {
+				continue
+			}
+			if !s.Until.IsZero() && t.After(s.Until) {
+				return
+			}
+			n++
+			if s.Count > 0 && n > s.Count {
+				return
+			}
+			if !fn(t) {
+				return
+			}
+		}
+	}
+}
+
+// periodOf returns the k of the period t falls in, every earlier period only
+// has days before t. Days before start are in period 0.
+func (s Schedule) periodOf(start, t time.Time) int {
+	if !t.After(start) {
+		return 0
+	}
+	var k int
+	switch s.Freq {
+	case Daily:
+		k = int(t.Sub(start).Hours() / 24)
+	case Weekly:
+		k = int(monday(t).Sub(monday(start)).Hours() / (24 * 7))
+	case Monthly:
+		k = (t.Year()-start.Year())*12 + int(t.Month()-start.Month())
+	case Yearly:
+		k = t.Year() - start.Year()
+	}
+	return k / s.Interval
+}
+
+// period returns the sorted candidate days in the k'th period after start.
+func (s Schedule) period(start time.Time, k int) []time.Time {
+	var days []time.Time
+	switch s.Freq {
+	case Daily:
+		t := start.AddDate(0, 0, k*s.Interval)
+		if s.inMonth(t.Month()) && s.onWeekday(t) && s.onMonthDay(t) {
+			days = append(days, t)
+		}
+	case Weekly:
+		week := monday(start).AddDate(0, 0, 7*k*s.Interval)
+		if len(s.ByDay) == 0 {
+			days = append(days, week.AddDate(0, 0, (int(start.Weekday())+6)%7))
+		}
+		for _, wd := range s.ByDay {
+			t := week.AddDate(0, 0, (int(wd.Day)+6)%7)
+			if s.inMonth(t.Month()) {
+				days = append(days, t)
+			}
+		}
+	case Monthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(k*s.Interval), 1, 0, 0, 0, 0, time.UTC)
+		if s.inMonth(first.Month()) {
+			days = s.inMonthDays(first, start.Day())
+		}
+	case Yearly:
+		months := s.ByMonth
+		if len(months) == 0 {
+			months = []time.Month{start.Month()}
+		}
+		for _, month := range months {
+			first := time.Date(start.Year()+k*s.Interval, month, 1, 0, 0, 0, 0, time.UTC)
+			days = append(days, s.inMonthDays(first, start.Day())...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	// BYMONTHDAY=31,-1 can name the same day twice
+	unique := days[:0]
+	for i, t := range days {
+		if i == 0 || !t.Equal(days[i-1]) {
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// inMonthDays picks the days in the month starting at first. With no BYDAY or
+// BYMONTHDAY the start's day of the month is used, a month without that day
+// is skipped unless the schedule is clamped.
+func (s Schedule) inMonthDays(first time.Time, startDay int) []time.Time {
+	last := first.AddDate(0, 1, -1).Day()
+	if len(s.ByDay) == 0 && len(s.ByMonthDay) == 0 {
+		if startDay > last && !s.Clamp {
+			return nil
+		}
+		return []time.Time{first.AddDate(0, 0, min(startDay, last)-1)}
+	}
+
+	var days []time.Time
+	if len(s.ByDay) == 0 {
+		for _, n := range s.ByMonthDay {
+			if d := monthDay(n, last); d > 0 {
+				days = append(days, first.AddDate(0, 0, d-1))
+			}
+		}
+		return days
+	}
+
+	// BYMONTHDAY narrows BYDAY down when both are given
+	for _, wd := range s.ByDay {
+		var matches []time.Time
+		for t := first; t.Month() == first.Month(); t = t.AddDate(0, 0, 1) {
+			if t.Weekday() == wd.Day {
+				matches = append(matches, t)
+			}
+		}
+		if wd.N > 0 && wd.N <= len(matches) {
+			matches = matches[wd.N-1 : wd.N]
+		} else if wd.N < 0 && -wd.N <= len(matches) {
+			matches = matches[len(matches)+wd.N : len(matches)+wd.N+1]
+		} else if wd.N != 0 {
+			matches = nil
+		}
+		for _, t := range matches {
+			if s.onMonthDay(t) {
+				days = append(days, t)
+			}
+		}
+	}
+	return days
+}
+
+func (s Schedule) inMonth(m time.Month) bool {
+	if len(s.ByMonth) == 0 {
+		return true
+	}
+	for _, month := range s.ByMonth {
+		if month == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) onWeekday(t time.Time) bool {
+	if len(s.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range s.ByDay {
+		if wd.Day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) onMonthDay(t time.Time) bool {
+	if len(s.ByMonthDay) == 0 {
+		return true
+	}
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, n := range s.ByMonthDay {
+		if monthDay(n, last) == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDay resolves a BYMONTHDAY, negative values count back from the last
+// day. It returns 0 for days the month doesn't have.
+func monthDay(n, last int) int {
+	if n < 0 {
+		n = last + n + 1
+	}
+	if n < 1 || n > last {
+		return 0
+	}
+	return n
+}
+
+// monday returns the Monday of t's week
+func monday(t time.Time) time.Time {
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+func day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,207 @@
+package recurring
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func dates(ts []time.Time) []string {
+	var out []string
+	for _, t := range ts {
+		out = append(out, t.Format(time.DateOnly))
+	}
+	return out
+}
+
+func mustEvery(freq string, interval int) Schedule {
+	s, err := Every(freq, interval)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func mustRRule(rule string) Schedule {
+	s, err := ParseRRule(rule)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		start    string
+		from, to string
+		want     []string
+	}{
+		{
+			name:     "monthly on the 31st clamps",
+			schedule: mustEvery(Monthly, 1),
+			start:    "2023-01-31", from: "2023-01-01", to: "2023-05-31",
+			want: []string{"2023-01-31", "2023-02-28", "2023-03-31", "2023-04-30", "2023-05-31"},
+		},
+		{
+			name:     "monthly on the 31st clamps in a leap year",
+			schedule: mustEvery(Monthly, 1),
+			start:    "2024-01-31", from: "2024-02-01", to: "2024-02-29",
+			want: []string{"2024-02-29"},
+		},
+		{
+			name:     "monthly rule on the 31st skips shorter months",
+			schedule: mustRRule("FREQ=MONTHLY"),
+			start:    "2023-01-31", from: "2023-01-01", to: "2023-05-31",
+			want: []string{"2023-01-31", "2023-03-31", "2023-05-31"},
+		},
+		{
+			name:     "last day of the month",
+			schedule: mustRRule("FREQ=MONTHLY;BYMONTHDAY=-1"),
+			start:    "2023-01-15", from: "2023-01-01", to: "2023-04-30",
+			want: []string{"2023-01-31", "2023-02-28", "2023-03-31", "2023-04-30"},
+		},
+		{
+			name:     "30th and last day don't repeat a day",
+			schedule: mustRRule("FREQ=MONTHLY;BYMONTHDAY=30,-1"),
+			start:    "2023-01-01", from: "2023-01-01", to: "2023-04-30",
+			want: []string{"2023-01-30", "2023-01-31", "2023-02-28", "2023-03-30", "2023-03-31", "2023-04-30"},
+		},
+		{
+			name:     "yearly on the 29th of February clamps",
+			schedule: mustEvery(Yearly, 1),
+			start:    "2024-02-29", from: "2024-01-01", to: "2026-12-31",
+			want: []string{"2024-02-29", "2025-02-28", "2026-02-28"},
+		},
+		{
+			name:     "yearly rule on the 29th of February skips",
+			schedule: mustRRule("FREQ=YEARLY"),
+			start:    "2024-02-29", from: "2024-01-01", to: "2028-12-31",
+			want: []string{"2024-02-29", "2028-02-29"},
+		},
+		{
+			name:     "last Friday",
+			schedule: mustRRule("FREQ=MONTHLY;BYDAY=-1FR"),
+			start:    "2023-01-01", from: "2023-01-01", to: "2023-03-31",
+			want: []string{"2023-01-27", "2023-02-24", "2023-03-31"},
+		},
+		{
+			name:     "every other week on two days",
+			schedule: mustRRule("FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"),
+			start:    "2023-01-04", from: "2023-01-01", to: "2023-01-31",
+			want: []string{"2023-01-05", "2023-01-16", "2023-01-19", "2023-01-30"},
+		},
+		{
+			name:     "count is taken from the start",
+			schedule: mustRRule("FREQ=MONTHLY;COUNT=3"),
+			start:    "2023-01-10", from: "2023-02-01", to: "2023-12-31",
+			want: []string{"2023-02-10", "2023-03-10"},
+		},
+		{
+			name:     "until is inclusive",
+			schedule: mustRRule("FREQ=DAILY;INTERVAL=3;UNTIL=20230110"),
+			start:    "2023-01-01", from: "2023-01-01", to: "2023-12-31",
+			want: []string{"2023-01-01", "2023-01-04", "2023-01-07", "2023-01-10"},
+		},
+		{
+			name:     "from long after the start",
+			schedule: mustEvery(Weekly, 3),
+			start:    "2000-01-03", from: "2023-06-01", to: "2023-07-15",
+			want: []string{"2023-06-19", "2023-07-10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dates(tt.schedule.Between(date(tt.start), date(tt.from), date(tt.to)))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Between = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Between = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		start    string
+		after    string
+		want     string
+	}{
+		{"first is the start", mustEvery(Daily, 1), "2023-01-01", "2022-12-31", "2023-01-01"},
+		{"clamped back in February", mustEvery(Monthly, 1), "2023-01-31", "2023-01-31", "2023-02-28"},
+		{"back to the 31st after February", mustEvery(Monthly, 1), "2023-01-31", "2023-02-28", "2023-03-31"},
+		{"skips April", mustRRule("FREQ=MONTHLY"), "2023-01-31", "2023-03-31", "2023-05-31"},
+		{"quarterly", mustEvery(Monthly, 3), "2023-11-30", "2023-11-30", "2024-02-29"},
+		{"decades later", mustEvery(Monthly, 1), "1990-01-31", "2023-04-30", "2023-05-31"},
+		{"after isn't an occurrence", mustEvery(Weekly, 2), "2023-01-02", "2023-01-20", "2023-01-30"},
+		{"yearly in the next year", mustEvery(Yearly, 1), "2020-06-15", "2023-06-15", "2024-06-15"},
+		{"last one by count", mustRRule("FREQ=DAILY;COUNT=3"), "2023-01-01", "2023-01-02", "2023-01-03"},
+		{"finished by count", mustRRule("FREQ=DAILY;COUNT=3"), "2023-01-01", "2023-01-03", ""},
+		{"finished by until", mustRRule("FREQ=MONTHLY;UNTIL=20230301"), "2023-01-01", "2023-03-01", ""},
+		{"never matches", mustRRule("FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30"), "2023-01-01", "2023-01-01", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, ok := tt.schedule.Next(date(tt.start), date(tt.after))
+			if tt.want == "" {
+				if ok {
+					t.Fatalf("Next = %s, want finished", next.Format(time.DateOnly))
+				}
+				return
+			}
+			if !ok || !next.Equal(date(tt.want)) {
+				t.Fatalf("Next = %s, %v, want %s", next.Format(time.DateOnly), ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  bool
+	}{
+		{"FREQ=MONTHLY;BYDAY=-1FR", false},
+		{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR", false},
+		{"FREQ=YEARLY;BYMONTH=1,7;BYMONTHDAY=1", false},
+		{"FREQ=DAILY;UNTIL=20231231T000000Z", false},
+		{"", true},
+		{"INTERVAL=2", true},
+		{"FREQ=HOURLY", true},
+		{"FREQ=MONTHLY;INTERVAL=0", true},
+		{"FREQ=MONTHLY;COUNT=0", true},
+		{"FREQ=MONTHLY;COUNT=2;UNTIL=20231231", true},
+		{"FREQ=WEEKLY;BYDAY=1MO", true},
+		{"FREQ=MONTHLY;BYDAY=XX", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", true},
+		{"FREQ=MONTHLY;BYMONTH=13", true},
+		{"FREQ=MONTHLY;BYSETPOS=1", true},
+		{"FREQ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := ParseRRule(tt.rule)
+			if (err != nil) != tt.err {
+				t.Fatalf("ParseRRule(%q) error = %v, want error %v", tt.rule, err, tt.err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRule) {
+				t.Fatalf("ParseRRule(%q) error = %v, want ErrInvalidRule", tt.rule, err)
+			}
+		})
+	}
+}